@@ -1,8 +1,13 @@
 package app
 
 import (
+	"fmt"
 	"log"
+	nethttp "net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/valyala/fasthttp"
@@ -10,6 +15,7 @@ import (
 	"gopkg.in/virgil.v5/cryptoapi"
 	sdk "gopkg.in/virgil.v5/sdk"
 
+	"github.com/VirgilSecurity/virgil-services-auth/core"
 	"github.com/VirgilSecurity/virgil-services-auth/core/handlers"
 	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
 	"github.com/VirgilSecurity/virgil-services-auth/http"
@@ -26,6 +32,10 @@ var (
 			IsPublic() bool
 			Identifier() []byte
 		}, error)
+		ExportPublicKey(key interface {
+			IsPublic() bool
+			Identifier() []byte
+		}) ([]byte, error)
 
 		ExtractPublicKey(interface {
 			IsPrivate() bool
@@ -45,6 +55,14 @@ var (
 			Identifier() []byte
 		}) ([]byte, error)
 
+		DecryptThenVerify(cipherData []byte, key interface {
+			IsPrivate() bool
+			Identifier() []byte
+		}, verifierKeys ...interface {
+			IsPublic() bool
+			Identifier() []byte
+		}) ([]byte, error)
+
 		Sign(data []byte, key interface {
 			IsPrivate() bool
 			Identifier() []byte
@@ -81,6 +99,7 @@ type VirgilClient struct {
 	Host               string
 	AuthorityCardID    string
 	AuthorityPublicKey string
+	AuthoritiesFile    string // replaces AuthorityCardID and AuthorityPublicKey with a list of authorities
 }
 type PrivateKey struct {
 	Key      string
@@ -92,6 +111,44 @@ type Config struct {
 	VirgilClient          VirgilClient
 	PrivateServiceKey     PrivateKey
 	UseSha256Fingerprints bool
+	DualFingerprints      bool // the keys of both fingerprint formats are accepted during the migration
+	PolicyFile            string
+	Issuer                string
+	RegistrationToken     string
+	RequireRequestSign    bool
+	PublicURL             string
+	PairwiseSecret        string
+	UserInfoCacheTTL      time.Duration
+	CardRevalidation      time.Duration
+	CardCache             CardCache
+	CardsService          CardsService
+	CardStore             CardStore
+	AdminToken            string
+	InlineCards           bool
+	TenantsFile           string // the instance serves the tenants of the file instead of a single application
+
+	Tenant    string // name of the tenant that is put into the access tokens, it's empty without tenants
+	Namespace string // prefix of the collection names of the tenant
+}
+
+type CardsService struct {
+	Timeout          time.Duration
+	Retries          int
+	RetryBackoff     time.Duration
+	FailureThreshold int
+	OpenTimeout      time.Duration
+}
+
+// CardStore tells where the cards are got from: the Cards service or the local store
+type CardStore struct {
+	Kind string // one of CardStoreRemote, CardStoreMongo and CardStoreDir
+	Dir  string
+}
+
+type CardCache struct {
+	TTL         time.Duration
+	NotFoundTTL time.Duration
+	Size        int
 }
 
 var (
@@ -104,26 +161,96 @@ func Init(conf Config) {
 	setupCrypto(conf.UseSha256Fingerprints)
 	logger = log.New(os.Stdout, "", log.LstdFlags|log.Lshortfile)
 
+	var tenants []Tenant
+	if conf.TenantsFile != "" {
+		var err error
+		if tenants, err = LoadTenants(conf.TenantsFile); err != nil {
+			logger.Fatalf("Cannot load tenants: %+v", err)
+		}
+	}
+	if len(tenants) == 0 {
+		checkRequired(conf)
+	}
+	for _, t := range tenants {
+		checkRequired(tenantConfig(conf, t))
+	}
+	db, err := initDB(conf.DBConnection)
+	if err != nil {
+		logger.Fatalf("Cannot connect to db: %+v", err)
+	}
+
+	if len(tenants) == 0 {
+		server = fasthttp.Server{
+			Handler: initRouter(conf, db).Handler,
+		}
+		return
+	}
+	routing := &http.Tenants{
+		Hosts:    make(map[string]*http.Router),
+		Prefixes: make(map[string]*http.Router),
+		HealthChecker: &http.HealthChecker{
+			CheckList: []http.Checker{
+				&repo.HealthChecker{
+					S: db.Session,
+				},
+				versionChecker{conf.Version},
+			},
+		},
+	}
+	for _, t := range tenants {
+		r := initRouter(tenantConfig(conf, t), db)
+		if t.Host != "" {
+			routing.Hosts[t.Host] = r
+		}
+		if t.PathPrefix != "" {
+			routing.Prefixes[t.PathPrefix] = r
+		}
+	}
+	server = fasthttp.Server{
+		Handler: routing.Handler,
+	}
+}
+
+func checkRequired(conf Config) {
 	requiredParams := []string{
 		conf.DBConnection,
-		conf.VirgilClient.AppID,
-		conf.VirgilClient.APIKeyID,
-		conf.VirgilClient.APIKeyStr,
 		conf.PrivateServiceKey.Key,
 	}
+	// the Cards service isn't called if the cards are kept locally
+	if conf.CardStore.Kind == CardStoreRemote {
+		requiredParams = append(requiredParams, conf.VirgilClient.AppID, conf.VirgilClient.APIKeyID, conf.VirgilClient.APIKeyStr)
+	}
 	for _, val := range requiredParams {
 		if val == "" {
 			logger.Fatalf("Required arguments were not filled. Run '[CMD] --help' for more information. Required arguments are marked *")
 		}
 	}
-	db, err := initDB(conf.DBConnection)
-	if err != nil {
-		logger.Fatalf("Cannot connect to db: %+v", err)
+}
+
+// initRouter makes the handlers of the service or of one of its tenants
+func initRouter(conf Config, database *mgo.Database) *http.Router {
+	db := collections{database, conf.Namespace}
+	if err := ensureIndexes(db); err != nil {
+		logger.Fatalf("Cannot create db indexes: %+v", err)
 	}
 
-	cardManager, err := initCardManager(conf.VirgilClient)
-	if err != nil {
-		logger.Fatalf("Cannot init card manager: %+v", err)
+	var cardSource services.CardSource
+	var cardVerifier sdk.CardVerifier
+	var err error
+	if conf.CardStore.Kind == CardStoreRemote {
+		cardSource, err = initCardManager(conf.VirgilClient, conf.CardsService.Timeout)
+		if err != nil {
+			logger.Fatalf("Cannot init card manager: %+v", err)
+		}
+		// the cards of the Cards service are verified by the adapter, so the failures are classified
+		if cardVerifier, err = initCardVerifier(conf.VirgilClient); err != nil {
+			logger.Fatalf("Cannot init card verifier: %+v", err)
+		}
+	} else {
+		cardSource, err = initLocalCards(conf, database, logger)
+		if err != nil {
+			logger.Fatalf("Cannot init local card store: %+v", err)
+		}
 	}
 
 	sk, err := crypto.ImportPrivateKey([]byte(conf.PrivateServiceKey.Key), conf.PrivateServiceKey.Password)
@@ -135,51 +262,228 @@ func Init(conf Config) {
 		logger.Fatalf("Cannot extract public key: %+v", err)
 	}
 
+	clients := &repo.Client{
+		C: db.C("client"),
+	}
+	devices := &repo.DeviceCode{
+		C: db.C("device_code"),
+	}
+	consents := &repo.Consent{
+		C: db.C("consent"),
+	}
+
+	cardService := &services.ResilientCards{
+		Cards:            &services.CardErrors{Cards: cardSource, Verifier: cardVerifier},
+		Logger:           logger,
+		Timeout:          conf.CardsService.Timeout,
+		Retries:          conf.CardsService.Retries,
+		RetryBackoff:     conf.CardsService.RetryBackoff,
+		FailureThreshold: conf.CardsService.FailureThreshold,
+		OpenTimeout:      conf.CardsService.OpenTimeout,
+	}
+	cards := &services.CardCache{
+		Cards:       cardService,
+		TTL:         conf.CardCache.TTL,
+		NotFoundTTL: conf.CardCache.NotFoundTTL,
+		MaxSize:     conf.CardCache.Size,
+	}
+
+	cipher := &services.Crypto{
+		PrivateKey: sk,
+		Crypto:     crypto,
+		Format:     fingerprintFormat(conf.UseSha256Fingerprints),
+	}
+	if conf.DualFingerprints {
+		cipher.Dual, err = initDualFingerprints(conf)
+		if err != nil {
+			logger.Fatalf("Cannot init dual fingerprints: %+v", err)
+		}
+	}
+
+	grant := &handlers.Grant{
+		Logger: logger,
+		MakeCode: &repo.Code{
+			C: db.C("code"),
+		},
+		AttemptRepo: &repo.Attempt{
+			C: db.C("attempt"),
+		},
+		Cipher:    cipher,
+		Client:    cards,
+		Clients:   clients,
+		ServiceID: conf.Issuer,
+		Devices:   devices,
+		Consents:  consents,
+	}
+	if conf.InlineCards {
+		verifier, err := initCardVerifier(conf.VirgilClient)
+		if err != nil {
+			logger.Fatalf("Cannot init card verifier: %+v", err)
+		}
+		grant.InlineCards = &services.InlineCards{Crypto: cardCrypto, Verifier: verifier}
+	}
+	baseURL := strings.TrimSuffix(conf.PublicURL, "/")
+	metadata := &http.Metadata{
+		Issuer:       conf.Issuer,
+		BaseURL:      baseURL,
+		SubjectTypes: []string{core.SubjectTypePublic},
+	}
+	if conf.PairwiseSecret != "" {
+		metadata.SubjectTypes = append(metadata.SubjectTypes, core.SubjectTypePairwise)
+	}
+	if conf.PolicyFile != "" {
+		policy, err := services.LoadPolicy(conf.PolicyFile)
+		if err != nil {
+			logger.Fatalf("Cannot load policy: %+v", err)
+		}
+		go reloadOnHangup(policy)
+		grant.Policy = policy
+		metadata.Scopes = policy
+	}
+	if conf.VirgilClient.AuthoritiesFile != "" {
+		list, err := services.LoadAuthorities(conf.VirgilClient.AuthoritiesFile)
+		if err != nil {
+			logger.Fatalf("Cannot load authorities: %+v", err)
+		}
+		authorities := &services.Authorities{List: list}
+		if grant.Policy != nil {
+			authorities.Policy = grant.Policy
+		}
+		grant.Policy = authorities
+	}
+
+	auth := &handlers.Auth{
+		Logger: logger,
+		CodeRepo: &repo.Code{
+			C: db.C("code"),
+		},
+		TokenRepo: &repo.AccessToken{
+			PrivateKey: sk,
+			PublicKey:  pk,
+			Crypto:     crypto,
+			Issuer:     conf.Issuer,
+			Tenant:     conf.Tenant,
+		},
+		RefreshRepo: &repo.Refresh{
+			C: db.C("refresh_token"),
+		},
+		Clients: &services.ClientAuthenticator{
+			Repo:     clients,
+			Crypto:   crypto,
+			Cards:    cardService,
+			Audience: conf.Issuer,
+			JTI: &repo.JTI{
+				C: db.C("jti"),
+			},
+		},
+		Assertions: &services.JWTBearerVerifier{
+			Cards:    cardService,
+			Crypto:   crypto,
+			Audience: conf.Issuer,
+			JTI: &repo.JTI{
+				C: db.C("jti"),
+			},
+		},
+		IDTokens: &repo.IDToken{
+			PrivateKey: sk,
+			Crypto:     crypto,
+			Issuer:     conf.Issuer,
+		},
+		PairwiseSecret:   conf.PairwiseSecret,
+		Cards:            cardService,
+		CardRevalidation: conf.CardRevalidation,
+		Policy:           grant.Policy,
+		Devices:          devices,
+		VerificationURI:  baseURL + "/v5/device",
+	}
+	metadata.GrantTypes = auth.GrantTypes()
+
 	routing := http.Router{
 		Auth: &http.Auth{
-			Handler: &handlers.Auth{
-				Logger: logger,
-				CodeRepo: &repo.Code{
-					C: db.C("code"),
-				},
-				TokenRepo: &repo.AccessToken{
-					PrivateKey: sk,
-					PublicKey:  pk,
-					Crypto:     crypto,
-				},
-				RefreshRepo: &repo.Refresh{
-					C: db.C("refresh_token"),
-				},
-			},
+			Handler: auth,
 		},
 		Grant: &http.Grant{
-			Handler: &handlers.Grant{
-				Logger: logger,
-				MakeCode: &repo.Code{
-					C: db.C("code"),
-				},
-				AttemptRepo: &repo.Attempt{
-					C: db.C("attempt"),
-				},
-				Cipher: &services.Crypto{
-					PrivateKey: sk,
-					Crypto:     crypto,
+			Handler: grant,
+			Signatures: &services.RequestSignVerifier{
+				Clients: clients,
+				Cards:   cardService,
+				Crypto:  crypto,
+				Used: &repo.JTI{
+					C: db.C("request_sign"),
 				},
-				Client: cardManager,
+				Logger:   logger,
+				Required: conf.RequireRequestSign,
+			},
+			Logger: logger,
+		},
+		Consents: &http.Consents{
+			Handler: &handlers.Consents{
+				TokenRepo:   auth.TokenRepo,
+				Consents:    consents,
+				RefreshRepo: auth.RefreshRepo,
+				Logger:      logger,
 			},
 		},
+		UserInfo: &http.UserInfo{
+			Handler: &handlers.UserInfo{
+				TokenRepo: auth.TokenRepo,
+				Client:    cards,
+				Logger:    logger,
+				CacheTTL:  conf.UserInfoCacheTTL,
+			},
+		},
+		Metadata: metadata,
 		HealthChecker: &http.HealthChecker{
 			CheckList: []http.Checker{
 				&repo.HealthChecker{
-					S: db.Session,
+					S: database.Session,
 				},
 				versionChecker{conf.Version},
+				cardService,
+				cards,
 			},
 		},
 	}
-	server = fasthttp.Server{
-		Handler: routing.Handler,
+	if cipher.Dual != nil {
+		routing.HealthChecker.CheckList = append(routing.HealthChecker.CheckList, cipher)
+	}
+	if conf.RegistrationToken != "" {
+		routing.Registration = &http.Registration{
+			Handler: &handlers.Registration{
+				Logger:  logger,
+				Clients: clients,
+				Audit: &repo.Audit{
+					C: db.C("audit"),
+				},
+				Hasher:             services.Bcrypt{},
+				InitialAccessToken: conf.RegistrationToken,
+				BaseURL:            baseURL,
+			},
+		}
+	}
+	if conf.AdminToken != "" {
+		routing.Admin = &http.Admin{
+			Handler: &handlers.Admin{
+				Token:   conf.AdminToken,
+				Cards:   cards,
+				Formats: cipher,
+			},
+		}
+	}
+	return &routing
+}
+
+// ensureIndexes creates the indexes that the repositories rely on in the collections of the tenant
+func ensureIndexes(db collections) error {
+	if err := (&repo.DeviceCode{C: db.C("device_code")}).EnsureIndexes(); err != nil {
+		return err
 	}
+	for _, name := range []string{"jti", "request_sign"} {
+		if err := (&repo.JTI{C: db.C(name)}).EnsureIndexes(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func initDB(conStr string) (*mgo.Database, error) {
@@ -190,7 +494,9 @@ func initDB(conStr string) (*mgo.Database, error) {
 	return session.DB(""), nil
 }
 
-func initCardManager(conf VirgilClient) (*sdk.CardManager, error) {
+// initCardManager makes the client of the Cards service. Its HTTP requests are limited by the timeout of the calls, so a
+// call that timed out doesn't keep the connection.
+func initCardManager(conf VirgilClient, timeout time.Duration) (*sdk.CardManager, error) {
 	// import a private key
 	apiKey, err := crypto.ImportPrivateKey([]byte(conf.APIKeyStr), conf.APIKeyPassword)
 	if err != nil {
@@ -203,36 +509,114 @@ func initCardManager(conf VirgilClient) (*sdk.CardManager, error) {
 	}
 	accessTokenProvider := sdk.NewCachingJwtProvider(authenticatedQueryToServerSide)
 
-	// setup card verifier
-	var cardVerifier *sdk.VirgilCardVerifier
-	if conf.AuthorityPublicKey != "" {
-		if conf.AuthorityCardID == "" {
-			logger.Fatalf("Authority card id missed")
-		}
+	// the cards are verified by services.CardErrors
+	noVerifier, err := sdk.NewVirgilCardVerifier(cardCrypto, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Virgil card verifier: %v", err)
+	}
 
-		authPK, err := crypto.ImportPublicKey([]byte(conf.AuthorityPublicKey))
+	return sdk.NewCardManager(&sdk.CardManagerParams{
+		Crypto:              cardCrypto,
+		CardVerifier:        noVerifier,
+		AccessTokenProvider: accessTokenProvider,
+		ApiUrl:              conf.Host,
+		HttpClient:          &nethttp.Client{Timeout: timeout},
+	})
+}
+
+// initCardVerifier makes the verifier of the cards got from the Cards service or the local card store. The cards are
+// self-signed and signed by Virgil or, if they're set, by the authority or one of the authorities of the list.
+func initCardVerifier(conf VirgilClient) (*services.CardSignatures, error) {
+	authorities, err := initAuthorities(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := sdk.NewVirgilCardVerifier(cardCrypto, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Virgil card verifier: %v", err)
+	}
+	checks := []services.SignatureCheck{{Signers: []string{"self"}, Verifier: self}}
+
+	if len(authorities) == 0 {
+		v, err := sdk.NewVirgilCardVerifier(cardCrypto, false, true)
 		if err != nil {
-			logger.Fatalf("Cannot import authority public key: %+v", err)
+			return nil, fmt.Errorf("cannot create Virgil card verifier: %v", err)
 		}
+		checks = append(checks, services.SignatureCheck{Signers: []string{"virgil"}, Verifier: v})
+		return &services.CardSignatures{Checks: checks}, nil
+	}
 
-		cardVerifier, err = sdk.NewVirgilCardVerifier(cardCrypto, true, false, sdk.NewWhitelist(&sdk.VerifierCredentials{
-			Signer:    conf.AuthorityCardID,
-			PublicKey: authPK,
-		}))
-	} else {
-		cardVerifier, err = sdk.NewVirgilCardVerifier(cardCrypto, true, true)
+	signers := make([]string, 0, len(authorities))
+	for _, a := range authorities {
+		signers = append(signers, a.Signer)
 	}
+	v, err := sdk.NewVirgilCardVerifier(cardCrypto, false, false, sdk.NewWhitelist(authorities...))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Virgil card verifier: %v", err)
+	}
+	checks = append(checks, services.SignatureCheck{Signers: signers, Verifier: v})
+	return &services.CardSignatures{Checks: checks}, nil
+}
 
+// initAuthorities returns the credentials of the authority or of the authorities of the list, any of them signs the
+// cards. It returns nil if the cards are signed by Virgil.
+func initAuthorities(conf VirgilClient) ([]*sdk.VerifierCredentials, error) {
+	if conf.AuthoritiesFile != "" {
+		return initAuthoritiesList(conf)
+	}
+	if conf.AuthorityPublicKey == "" {
+		return nil, nil
+	}
+	if conf.AuthorityCardID == "" {
+		return nil, fmt.Errorf("authority card id missed")
+	}
+
+	authPK, err := crypto.ImportPublicKey([]byte(conf.AuthorityPublicKey))
 	if err != nil {
-		logger.Fatalf("Cannot create Virgil card verifier: %+v", err)
+		return nil, fmt.Errorf("cannot import authority public key: %v", err)
 	}
+	return []*sdk.VerifierCredentials{{
+		Signer:    conf.AuthorityCardID,
+		PublicKey: authPK,
+	}}, nil
+}
 
-	return sdk.NewCardManager(&sdk.CardManagerParams{
-		Crypto:              cardCrypto,
-		CardVerifier:        cardVerifier,
-		AccessTokenProvider: accessTokenProvider,
-		ApiUrl:              conf.Host,
-	})
+// initAuthoritiesList returns the credentials of the authorities of the list
+func initAuthoritiesList(conf VirgilClient) ([]*sdk.VerifierCredentials, error) {
+	if conf.AuthorityCardID != "" || conf.AuthorityPublicKey != "" {
+		return nil, fmt.Errorf("authority card id and public key can't be used with the authorities file")
+	}
+	list, err := services.LoadAuthorities(conf.AuthoritiesFile)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]*sdk.VerifierCredentials, 0, len(list))
+	for _, a := range list {
+		pk, err := crypto.ImportPublicKey([]byte(a.PublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("cannot import public key of authority %v: %v", a.Name, err)
+		}
+		credentials = append(credentials, &sdk.VerifierCredentials{
+			Signer:    a.CardID,
+			PublicKey: pk,
+		})
+	}
+	return credentials, nil
+}
+
+// reloadOnHangup rereads the policy file on SIGHUP
+func reloadOnHangup(policy *services.Policy) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if err := policy.Reload(); err != nil {
+			logger.Printf("Cannot reload policy: %+v", err)
+			continue
+		}
+		logger.Printf("Policy %v reloaded", policy.Path)
+	}
 }
 
 func Run(address string) {
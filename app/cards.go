@@ -0,0 +1,89 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
+	"github.com/VirgilSecurity/virgil-services-auth/services"
+)
+
+const (
+	CardStoreRemote = "remote" // the Virgil Cards service
+	CardStoreMongo  = "mongo"  // the card collection of the service db
+	CardStoreDir    = "dir"    // the card files of CardStore.Dir
+)
+
+// initLocalCards opens the local card store, the db is used by the mongo store only
+func initLocalCards(conf Config, database *mgo.Database, logger *log.Logger) (*services.LocalCards, error) {
+	verifier, err := initCardVerifier(conf.VirgilClient)
+	if err != nil {
+		return nil, err
+	}
+	cards := &services.LocalCards{
+		Crypto:   cardCrypto,
+		Verifier: verifier,
+		Logger:   logger,
+	}
+	switch conf.CardStore.Kind {
+	case CardStoreMongo:
+		cards.Store = &repo.Card{
+			C: database.C(conf.Namespace + "card"),
+		}
+	case CardStoreDir:
+		info, err := os.Stat(conf.CardStore.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%v isn't a directory", conf.CardStore.Dir)
+		}
+		cards.Store = &repo.CardDir{
+			Path:   conf.CardStore.Dir,
+			Crypto: cardCrypto,
+			Logger: logger,
+		}
+	default:
+		return nil, fmt.Errorf("card store %q isn't local", conf.CardStore.Kind)
+	}
+	return cards, nil
+}
+
+// openLocalCards opens the local card store out of the service, e.g. by the commands
+func openLocalCards(conf Config) (*services.LocalCards, error) {
+	setupCrypto(conf.UseSha256Fingerprints)
+
+	var database *mgo.Database
+	if conf.CardStore.Kind == CardStoreMongo {
+		var err error
+		if database, err = initDB(conf.DBConnection); err != nil {
+			return nil, fmt.Errorf("cannot connect to db: %v", err)
+		}
+	}
+	return initLocalCards(conf, database, log.New(os.Stderr, "", log.LstdFlags))
+}
+
+// ImportCard verifies the exported card and adds it to the local card store. It returns the card id.
+func ImportCard(conf Config, raw string) (string, error) {
+	cards, err := openLocalCards(conf)
+	if err != nil {
+		return "", err
+	}
+	card, err := cards.Import(raw)
+	if err != nil {
+		return "", err
+	}
+	return card.Id, nil
+}
+
+// RemoveCard removes the card from the local card store
+func RemoveCard(conf Config, id string) error {
+	cards, err := openLocalCards(conf)
+	if err != nil {
+		return err
+	}
+	return cards.Remove(id)
+}
@@ -13,3 +13,10 @@ func setupCrypto(useSha256Fingerprints bool) {
 	cardCrypto = &virgil_crypto_go.CardCrypto{Crypto: c}
 	tokenSigner = virgil_crypto_go.NewVirgilAccessTokenSigner()
 }
+
+// newCrypto makes the crypto of the fingerprint format, e.g. the other format of the dual mode
+func newCrypto(useSha256Fingerprints bool) fingerprintCrypto {
+	c := virgil_crypto_go.NewVirgilCrypto()
+	c.UseSha256Fingerprints = useSha256Fingerprints
+	return c
+}
@@ -0,0 +1,49 @@
+package app
+
+import (
+	"fmt"
+
+	"gopkg.in/virgil.v5/cryptoapi"
+
+	"github.com/VirgilSecurity/virgil-services-auth/services"
+)
+
+const (
+	FingerprintSHA256 = "sha256" // the old format of the key identifiers
+	FingerprintSHA512 = "sha512"
+)
+
+type fingerprintCrypto interface {
+	services.PublicKeyCodec
+	ImportPrivateKey([]byte, string) (interface {
+		IsPrivate() bool
+		Identifier() []byte
+	}, error)
+}
+
+func fingerprintFormat(useSha256Fingerprints bool) string {
+	if useSha256Fingerprints {
+		return FingerprintSHA256
+	}
+	return FingerprintSHA512
+}
+
+// initDualFingerprints makes the other fingerprint format of the dual mode, the one that isn't chosen by
+// UseSha256Fingerprints
+func initDualFingerprints(conf Config) (*services.DualFingerprints, error) {
+	alt := newCrypto(!conf.UseSha256Fingerprints)
+	sk, err := alt.ImportPrivateKey([]byte(conf.PrivateServiceKey.Key), conf.PrivateServiceKey.Password)
+	if err != nil {
+		return nil, fmt.Errorf("cannot import private key: %v", err)
+	}
+	privateKey, ok := sk.(cryptoapi.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key of unsupported type %T", sk)
+	}
+	return &services.DualFingerprints{
+		Format:     fingerprintFormat(!conf.UseSha256Fingerprints),
+		PrivateKey: privateKey,
+		Export:     crypto,
+		Import:     alt,
+	}, nil
+}
@@ -16,3 +16,10 @@ func setupCrypto(useSha256Fingerprints bool) {
 	}
 	tokenSigner = cryptoimpl.NewVirgilAccessTokenSigner()
 }
+
+// newCrypto makes the crypto of the fingerprint format, e.g. the other format of the dual mode
+func newCrypto(useSha256Fingerprints bool) fingerprintCrypto {
+	c := cryptoimpl.NewVirgilCrypto()
+	c.UseSHA256Fingerprints = useSha256Fingerprints
+	return c
+}
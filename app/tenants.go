@@ -0,0 +1,157 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Tenant is a Virgil application served by the instance. The requests are routed to the tenant by the host name or
+// the path prefix. The settings that aren't set in the tenant are taken from the common ones.
+//
+// Tenants file example:
+//
+//	[
+//	  {
+//	    "name": "acme",
+//	    "host": "auth.acme.com",
+//	    "path_prefix": "/acme",
+//	    "app_id": "<app id>",
+//	    "api_key_id": "<api key id>",
+//	    "api_key": "<api key>",
+//	    "key": "<private key of the tenant>",
+//	    "authorities": "/etc/virgil-auth/acme/authorities.json",
+//	    "policy": "/etc/virgil-auth/acme/policy.json"
+//	  }
+//	]
+type Tenant struct {
+	Name           string `json:"name"`
+	Host           string `json:"host"`
+	PathPrefix     string `json:"path_prefix"` // a single path segment, e.g. /acme
+	AppID          string `json:"app_id"`
+	APIKeyID       string `json:"api_key_id"`
+	APIKey         string `json:"api_key"`
+	APIKeyPassword string `json:"api_key_password"`
+	Key            string `json:"key"`
+	KeyPassword    string `json:"key_password"`
+	Authorities    string `json:"authorities"` // path to the list of authorities
+	Policy         string `json:"policy"`      // path to the policy file
+	Issuer         string `json:"issuer"`
+	PublicURL      string `json:"public_url"`
+	Namespace      string `json:"namespace"` // prefix of the collection names, "{name}_" by default
+}
+
+var pathPrefixRegexp = regexp.MustCompile(`^/[^/]+$`)
+
+// LoadTenants reads the tenant registry from the file
+func LoadTenants(path string) ([]Tenant, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []Tenant
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("tenants %v: %v", path, err)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("tenants %v: the list is empty", path)
+	}
+	names, routes := make(map[string]bool), make(map[string]bool)
+	for i := range list {
+		t := &list[i]
+		t.Host = strings.ToLower(t.Host)
+		if t.Namespace == "" {
+			t.Namespace = t.Name + "_"
+		}
+		switch {
+		case t.Name == "":
+			return nil, fmt.Errorf("tenants %v: tenant #%v: name missed", path, i)
+		case names[t.Name]:
+			return nil, fmt.Errorf("tenants %v: tenant %v is duplicated", path, t.Name)
+		case t.Host == "" && t.PathPrefix == "":
+			return nil, fmt.Errorf("tenants %v: tenant %v: host or path_prefix missed", path, t.Name)
+		case t.PathPrefix != "" && !pathPrefixRegexp.MatchString(t.PathPrefix):
+			return nil, fmt.Errorf("tenants %v: tenant %v: path_prefix must be a single path segment", path, t.Name)
+		case routes["host "+t.Host] || routes["path "+t.PathPrefix]:
+			return nil, fmt.Errorf("tenants %v: tenant %v: host or path_prefix is used by another tenant", path, t.Name)
+		case routes["namespace "+t.Namespace]:
+			return nil, fmt.Errorf("tenants %v: tenant %v: namespace is used by another tenant", path, t.Name)
+		}
+		names[t.Name] = true
+		routes["namespace "+t.Namespace] = true
+		if t.Host != "" {
+			routes["host "+t.Host] = true
+		}
+		if t.PathPrefix != "" {
+			routes["path "+t.PathPrefix] = true
+		}
+	}
+	return list, nil
+}
+
+// TenantConfig returns the config of the tenant of the file conf.TenantsFile, e.g. for the commands. The config is
+// returned as is if the name is empty.
+func TenantConfig(conf Config, name string) (Config, error) {
+	if name == "" {
+		return conf, nil
+	}
+	if conf.TenantsFile == "" {
+		return conf, fmt.Errorf("tenants file isn't set")
+	}
+	list, err := LoadTenants(conf.TenantsFile)
+	if err != nil {
+		return conf, err
+	}
+	for _, t := range list {
+		if t.Name == name {
+			return tenantConfig(conf, t), nil
+		}
+	}
+	return conf, fmt.Errorf("tenant %v isn't found", name)
+}
+
+// tenantConfig overrides the common settings with the ones of the tenant
+func tenantConfig(conf Config, t Tenant) Config {
+	conf.Tenant, conf.Namespace = t.Name, t.Namespace
+	if t.AppID != "" {
+		conf.VirgilClient.AppID = t.AppID
+	}
+	if t.APIKeyID != "" {
+		conf.VirgilClient.APIKeyID = t.APIKeyID
+		conf.VirgilClient.APIKeyStr = t.APIKey
+		conf.VirgilClient.APIKeyPassword = t.APIKeyPassword
+	}
+	if t.Key != "" {
+		conf.PrivateServiceKey = PrivateKey{Key: t.Key, Password: t.KeyPassword}
+	}
+	if t.Authorities != "" {
+		conf.VirgilClient.AuthoritiesFile = t.Authorities
+		conf.VirgilClient.AuthorityCardID, conf.VirgilClient.AuthorityPublicKey = "", ""
+	}
+	if t.Policy != "" {
+		conf.PolicyFile = t.Policy
+	}
+	if t.Issuer != "" {
+		conf.Issuer = t.Issuer
+	}
+	if t.PublicURL != "" {
+		conf.PublicURL = t.PublicURL
+	} else if conf.PublicURL != "" {
+		conf.PublicURL = strings.TrimSuffix(conf.PublicURL, "/") + t.PathPrefix
+	}
+	return conf
+}
+
+// collections are the collections of the tenant, their names are prefixed by the tenant namespace
+type collections struct {
+	db     *mgo.Database
+	prefix string
+}
+
+func (c collections) C(name string) *mgo.Collection {
+	return c.db.C(c.prefix + name)
+}
@@ -0,0 +1,51 @@
+package core
+
+import "fmt"
+
+// CardErrorKind is the reason of a failed card lookup
+type CardErrorKind int
+
+const (
+	CardNotFound         CardErrorKind = iota + 1 // the card doesn't exist on the Cards service
+	CardForbidden                                 // the card exists but the service has no access to it
+	CardIDInvalid                                 // the card id is malformed
+	CardSignatureMissing                          // the card isn't signed by a required verifier
+	CardSignatureInvalid                          // a signature of the card is invalid
+	CardsUnavailable                              // the Cards service can't be reached
+	CardMalformed                                 // the raw card can't be parsed
+)
+
+var cardErrorKindNames = map[CardErrorKind]string{
+	CardNotFound:         "card not found",
+	CardForbidden:        "card access forbidden",
+	CardIDInvalid:        "card id invalid",
+	CardSignatureMissing: "card signature missing",
+	CardSignatureInvalid: "card signature invalid",
+	CardsUnavailable:     "cards service unavailable",
+	CardMalformed:        "card malformed",
+}
+
+func (k CardErrorKind) String() string {
+	if name, ok := cardErrorKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("card error %d", int(k))
+}
+
+// CardError is returned by the card clients instead of the errors of the Virgil SDK
+type CardError struct {
+	Kind CardErrorKind
+	Err  error
+}
+
+func (e *CardError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+// CardErrorKindOf returns the kind of the card error, it's 0 if err isn't a CardError
+func CardErrorKindOf(err error) CardErrorKind {
+	if cerr, ok := err.(*CardError); ok {
+		return cerr.Kind
+	}
+	return 0
+}
@@ -0,0 +1,20 @@
+package core
+
+const (
+	// ChallengeMethodEncryption is the default proof of possession: the owner decrypts the message and re-encrypts
+	// it for the service.
+	ChallengeMethodEncryption = "encryption"
+	// ChallengeMethodSignature is the proof of possession by the signature over SignatureChallengeData.
+	ChallengeMethodSignature = "signature"
+)
+
+// ValidChallengeMethod reports whether the challenge method is supported. The empty one is the default.
+func ValidChallengeMethod(method string) bool {
+	return method == "" || method == ChallengeMethodEncryption || method == ChallengeMethodSignature
+}
+
+// SignatureChallengeData is what the owner signs in the signature challenge method. The attempt id and the service
+// id bind the signature to the handshake so that it cannot be reused with another attempt or another service.
+func SignatureChallengeData(attemptID, serviceID, challenge string) []byte {
+	return []byte(attemptID + "\n" + serviceID + "\n" + challenge)
+}
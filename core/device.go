@@ -0,0 +1,28 @@
+package core
+
+import "strings"
+
+// GrantTypeDeviceCode is the grant type of the device authorization grant (RFC 8628 section 3.4)
+const GrantTypeDeviceCode = "urn:ietf:params:oauth:grant-type:device_code"
+
+// NormalizeUserCode brings the user code entered by the owner to the stored form: the dash and spaces are dropped
+// and letters are upper-cased (RFC 8628 section 6.1).
+func NormalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+		return r
+	}, code)
+}
+
+// FormatUserCode splits the user code in two halves with the dash to make it readable
+func FormatUserCode(code string) string {
+	if len(code) < 2 {
+		return code
+	}
+	return code[:len(code)/2] + "-" + code[len(code)/2:]
+}
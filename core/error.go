@@ -17,6 +17,26 @@ const (
 	StatusErrorAccessTokenBroken                ResponseStatus = 53080
 	StatusErrorRefreshTokenNotFound             ResponseStatus = 53090
 	StatusErrorCardInvalid                      ResponseStatus = 53100
+	StatusErrorScopeNotAllowed                  ResponseStatus = 53110
+	StatusErrorClientAuthenticationFailed       ResponseStatus = 53120
+	StatusErrorGrantTypeNotAllowed              ResponseStatus = 53130
+	StatusErrorInvalidClientMetadata            ResponseStatus = 53140
+	StatusErrorRegistrationTokenInvalid         ResponseStatus = 53150
+	StatusErrorCodeChallengeInvalid             ResponseStatus = 53160
+	StatusErrorCodeVerifierInvalid              ResponseStatus = 53170
+	StatusErrorRedirectURIInvalid               ResponseStatus = 53180
+	StatusErrorRequestSignInvalid               ResponseStatus = 53190
+	StatusErrorAssertionInvalid                 ResponseStatus = 53200
+	StatusErrorChallengeMethodUnsupported       ResponseStatus = 53210
+	StatusErrorAuthorizationPending             ResponseStatus = 53220
+	StatusErrorSlowDown                         ResponseStatus = 53230
+	StatusErrorUserCodeInvalid                  ResponseStatus = 53240
+	StatusErrorSubjectTokenInvalid              ResponseStatus = 53250
+	StatusErrorAudienceNotAllowed               ResponseStatus = 53260
+	StatusErrorConsentRequired                  ResponseStatus = 53270
+	StatusErrorOwnerCardRevoked                 ResponseStatus = 53280
+	StatusErrorAdminTokenInvalid                ResponseStatus = 53290
+	StatusErrorCardsServiceUnavailable          ResponseStatus = 53300
 
 	StatusErrorInternalApplicationError ResponseStatus = 10000
 )
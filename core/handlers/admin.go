@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"crypto/subtle"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type CardPurger interface {
+	Purge(id string)
+}
+
+// FingerprintCounter counts the answers of the fingerprint formats by the clients
+type FingerprintCounter interface {
+	ClientFormats() map[string]map[string]uint64
+}
+
+// Admin serves the operator calls, they are authorized by the admin token
+type Admin struct {
+	Token   string
+	Cards   CardPurger
+	Formats FingerprintCounter
+}
+
+// PurgeCard removes the card from the card cache, e.g. after the card was revoked
+func (a *Admin) PurgeCard(resp core.Response, token string, cardID string) {
+	if !a.authorize(resp, token) {
+		return
+	}
+	a.Cards.Purge(cardID)
+	resp.NoContent()
+}
+
+// Fingerprints returns the number of the answers of every fingerprint format by the clients
+func (a *Admin) Fingerprints(resp core.Response, token string) {
+	if !a.authorize(resp, token) {
+		return
+	}
+	resp.Success(a.Formats.ClientFormats())
+}
+
+func (a *Admin) authorize(resp core.Response, token string) bool {
+	if a.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		resp.Error(core.StatusErrorAdminTokenInvalid)
+		return false
+	}
+	return true
+}
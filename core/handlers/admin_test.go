@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type FakeCardPurger struct {
+	mock.Mock
+}
+
+func (p *FakeCardPurger) Purge(id string) {
+	p.Called(id)
+}
+
+func TestPurgeCard_ValidToken_CardPurged(t *testing.T) {
+	p := new(FakeCardPurger)
+	p.On("Purge", "card").Once()
+	resp := new(FakeResponse)
+	resp.On("NoContent").Once()
+
+	a := &Admin{Token: "admin", Cards: p}
+	a.PurgeCard(resp, "admin", "card")
+
+	p.AssertExpectations(t)
+	resp.AssertExpectations(t)
+}
+
+type FakeFingerprintCounter map[string]map[string]uint64
+
+func (c FakeFingerprintCounter) ClientFormats() map[string]map[string]uint64 {
+	return c
+}
+
+func TestFingerprints_ValidToken_ReturnClientFormats(t *testing.T) {
+	counts := FakeFingerprintCounter{"web": {"sha512": 1}}
+	resp := new(FakeResponse)
+	resp.On("Success", map[string]map[string]uint64(counts)).Once()
+
+	a := &Admin{Token: "admin", Formats: counts}
+	a.Fingerprints(resp, "admin")
+
+	resp.AssertExpectations(t)
+}
+
+func TestFingerprints_InvalidToken_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorAdminTokenInvalid).Once()
+
+	a := &Admin{Token: "admin", Formats: FakeFingerprintCounter{}}
+	a.Fingerprints(resp, "wrong")
+
+	resp.AssertExpectations(t)
+}
+
+func TestPurgeCard_InvalidToken_ReturnErr(t *testing.T) {
+	table := map[string]string{
+		"admin": "wrong",
+		"":      "",
+	}
+	for token, sent := range table {
+		p := new(FakeCardPurger)
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorAdminTokenInvalid).Once()
+
+		a := &Admin{Token: token, Cards: p}
+		a.PurgeCard(resp, sent, "card")
+
+		p.AssertNotCalled(t, "Purge", mock.Anything)
+		resp.AssertExpectations(t)
+	}
+}
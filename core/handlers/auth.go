@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
+	virgil "gopkg.in/virgil.v5/sdk"
+
 	"github.com/VirgilSecurity/virgil-services-auth/core"
 	"github.com/VirgilSecurity/virgil-services-auth/db"
 )
@@ -10,18 +15,78 @@ import (
 const (
 	grantTypeAccessCode   = "access_code"
 	grantTypeRefreshToken = "refresh_token"
+	grantTypeJWTBearer    = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	grantTypeClientCredentials = "client_credentials"
+	grantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
 )
 
+type ClientAuthenticator interface {
+	Authenticate(cred core.ClientCredentials) (*db.Client, error)
+}
+
+type AssertionVerifier interface {
+	Verify(assertion string) (*virgil.Card, error)
+}
+
 type Auth struct {
 	Logger      Logger
 	CodeRepo    db.CodeRepo
 	TokenRepo   db.TokenRepo
 	RefreshRepo db.RefreshRepo
+	Clients     ClientAuthenticator
+	Assertions  AssertionVerifier // JWT bearer grant, the grant is unsupported without it
+	Policy      Policy            // optional, applied to the JWT bearer grant as to the handshake
+
+	Devices         db.DeviceCodeRepo // device authorization grant, the grant is unsupported without it
+	VerificationURI string            // page where the owner enters the user code
+
+	IDTokens       db.IDTokenMaker // optional, id_token isn't issued without it
+	PairwiseSecret string          // key of pairwise subject identifiers
+
+	// Cards re-validates the owner card on refresh, the card isn't checked without it. A valid card isn't checked
+	// again for CardRevalidation.
+	Cards            CardClient
+	CardRevalidation time.Duration
+
+	validCards expiringCache
+}
+
+// GrantTypes returns the grant types that the handler supports with its configuration
+func (s *Auth) GrantTypes() []string {
+	types := []string{grantTypeAccessCode, grantTypeRefreshToken}
+	if s.Assertions != nil {
+		types = append(types, grantTypeJWTBearer)
+	}
+	types = append(types, grantTypeClientCredentials)
+	if s.Devices != nil {
+		types = append(types, core.GrantTypeDeviceCode)
+	}
+	return append(types, grantTypeTokenExchange)
 }
 
 func (s *Auth) AccessToken(resp core.Response, code core.AccessCode) {
-	if code.GrantType != grantTypeAccessCode {
+	switch {
+	case code.GrantType == grantTypeAccessCode:
+		s.exchangeCode(resp, code)
+	case code.GrantType == grantTypeJWTBearer && s.Assertions != nil:
+		s.jwtBearer(resp, code)
+	case code.GrantType == grantTypeClientCredentials:
+		s.clientCredentials(resp, code)
+	case code.GrantType == core.GrantTypeDeviceCode && s.Devices != nil:
+		s.deviceCode(resp, code)
+	case code.GrantType == grantTypeTokenExchange:
+		s.tokenExchange(resp, code)
+	default:
 		resp.Error(core.StatusErrorUnsupportedGrantType)
+	}
+}
+
+func (s *Auth) exchangeCode(resp core.Response, code core.AccessCode) {
+	client, ok := s.authenticate(resp, code.ClientCredentials, code.GrantType)
+	if !ok {
 		return
 	}
 	m, err := s.CodeRepo.GetCode(code.Code)
@@ -34,6 +99,12 @@ func (s *Auth) AccessToken(resp core.Response, code core.AccessCode) {
 		resp.Error(core.StatusErrorCodeNotFound)
 		return
 	}
+	// the code that was requested for another client is treated as not existing one. The handshake without a client
+	// isn't signed, so its code cannot be exchanged by the client that requires signed handshakes.
+	if (m.ClientID != "" && m.ClientID != client.ID) || (m.ClientID == "" && client.RequireRequestSign) {
+		resp.Error(core.StatusErrorCodeNotFound)
+		return
+	}
 	if m.Used {
 		resp.Error(core.StatusErrorCodeWasUsed)
 		return
@@ -42,41 +113,329 @@ func (s *Auth) AccessToken(resp core.Response, code core.AccessCode) {
 		resp.Error(core.StatusErrorCodeExpired)
 		return
 	}
-	token, err := s.TokenRepo.Make(m.OwnerID, m.Scope)
+	// RFC 6749 section 4.1.3: the redirect_uri of the browser flow must be repeated
+	if m.RedirectURI != "" && m.RedirectURI != code.RedirectURI {
+		resp.Error(core.StatusErrorRedirectURIInvalid)
+		return
+	}
+	if !verifyCode(m, client, code.CodeVerifier) {
+		resp.Error(core.StatusErrorCodeVerifierInvalid)
+		return
+	}
+	grant, ok := clientGrant(m.Grant, client)
+	if !ok {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return
+	}
+	// the code is used only by the exchange that passed the checks, so another client cannot burn it
+	used, err := s.CodeRepo.Use(m.Code)
 	if err != nil {
-		s.Logger.Printf("AccessToken[Make token]: %v", err)
+		s.Logger.Printf("AccessToken[Use code]: %v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
 		return
 	}
-	refresh, err := s.RefreshRepo.Make(m.OwnerID, m.Scope)
+	if !used {
+		resp.Error(core.StatusErrorCodeWasUsed)
+		return
+	}
+	s.issueTokens(resp, grant, client, client.IssueRefreshToken)
+}
+
+// jwtBearer issues tokens for the assertion signed with the card key (RFC 7523 section 2.1)
+func (s *Auth) jwtBearer(resp core.Response, req core.AccessCode) {
+	client, ok := s.authenticate(resp, req.ClientCredentials, req.GrantType)
+	if !ok {
+		return
+	}
+	card, err := s.Assertions.Verify(req.Assertion)
+	if err != nil {
+		if status, ok := cardErrorStatus(err); ok {
+			resp.Error(status)
+			return
+		}
+		cardServiceError(resp, s.Logger, "AccessToken[verify assertion]", err)
+		return
+	}
+	if card == nil {
+		resp.Error(core.StatusErrorAssertionInvalid)
+		return
+	}
+	grant := db.Grant{
+		OwnerID:      card.Id,
+		Scope:        req.Scope,
+		Identity:     card.Identity,
+		IdentityType: cardIdentityType(card),
+		AuthTime:     time.Now().Unix(),
+		ACR:          core.ACRCardAssertion,
+	}
+	if grant.Scope == "" {
+		grant.Scope = core.ScopeWildcard
+	}
+	if !applyPolicy(s.Policy, s.Logger, card, client.ID, &grant) {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return
+	}
+	grant, ok = clientGrant(grant, client)
+	if !ok {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return
+	}
+	s.issueTokens(resp, grant, client, client.IssueRefreshToken)
+}
+
+// clientCredentials issues the access token to the confidential client itself (RFC 6749 section 4.4). The token has
+// no owner, the client_id tells whose it is, and the scope is limited to the registered scopes of the client.
+func (s *Auth) clientCredentials(resp core.Response, req core.AccessCode) {
+	client, ok := s.authenticate(resp, req.ClientCredentials, req.GrantType)
+	if !ok {
+		return
+	}
+	if client.Public() {
+		resp.Error(core.StatusErrorClientAuthenticationFailed)
+		return
+	}
+	scope, ok := core.NarrowScope(req.Scope, client.Scopes)
+	if !ok {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return
+	}
+	grant, _ := clientGrant(db.Grant{Scope: scope}, client)
+	// RFC 6749 section 4.4.3: a refresh token should not be included
+	s.issueTokens(resp, grant, client, false)
+}
+
+// DeviceAuthorization starts the device authorization grant (RFC 8628 section 3.1). The owner approves the user code
+// by the handshake on another device that holds the card.
+func (s *Auth) DeviceAuthorization(resp core.Response, req core.DeviceAuthorizationRequest) {
+	if s.Devices == nil {
+		resp.Error(core.StatusErrorUnsupportedGrantType)
+		return
+	}
+	client, ok := s.authenticate(resp, req.ClientCredentials, core.GrantTypeDeviceCode)
+	if !ok {
+		return
+	}
+	scope := req.Scope
+	if scope == "" {
+		scope = core.ScopeWildcard
+	}
+	if len(client.Scopes) != 0 {
+		if scope, ok = core.NarrowScope(scope, client.Scopes); !ok {
+			resp.Error(core.StatusErrorScopeNotAllowed)
+			return
+		}
+	}
+	d, err := s.Devices.Make(db.Grant{ClientID: client.ID, Scope: scope})
+	if err != nil {
+		s.Logger.Printf("DeviceAuthorization[Make device code]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	userCode := core.FormatUserCode(d.UserCode)
+	resp.Success(&core.DeviceAuthorization{
+		DeviceCode:              d.Code,
+		UserCode:                userCode,
+		VerificationURI:         s.VerificationURI,
+		VerificationURIComplete: s.VerificationURI + "?user_code=" + url.QueryEscape(userCode),
+		ExpiresIn:               int(d.Expired.Sub(time.Now()) / time.Second),
+		Interval:                d.Interval,
+	})
+}
+
+// deviceCode issues tokens when the owner has approved the device authorization (RFC 8628 section 3.4). The device
+// that polls more often than the interval is asked to slow down and the interval grows.
+func (s *Auth) deviceCode(resp core.Response, req core.AccessCode) {
+	client, ok := s.authenticate(resp, req.ClientCredentials, req.GrantType)
+	if !ok {
+		return
+	}
+	d, err := s.Devices.Poll(req.DeviceCode)
+	if err != nil {
+		s.Logger.Printf("AccessToken[Poll device code]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	if d == nil || d.ClientID != client.ID {
+		resp.Error(core.StatusErrorCodeNotFound)
+		return
+	}
+	if time.Now().After(d.Expired) {
+		resp.Error(core.StatusErrorCodeExpired)
+		return
+	}
+	if !d.Approved {
+		if time.Since(d.LastPoll) < time.Duration(d.Interval)*time.Second {
+			if err = s.Devices.SlowDown(d.Code); err != nil {
+				s.Logger.Printf("AccessToken[Slow down device code]: %v", err)
+				resp.Error(core.StatusErrorInternalApplicationError)
+				return
+			}
+			resp.Error(core.StatusErrorSlowDown)
+			return
+		}
+		resp.Error(core.StatusErrorAuthorizationPending)
+		return
+	}
+	used, err := s.Devices.Use(d.Code)
+	if err != nil {
+		s.Logger.Printf("AccessToken[Use device code]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	if !used {
+		resp.Error(core.StatusErrorCodeWasUsed)
+		return
+	}
+	grant, ok := clientGrant(d.Grant, client)
+	if !ok {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return
+	}
+	s.issueTokens(resp, grant, client, client.IssueRefreshToken)
+}
+
+// tokenExchange issues the token of the subject token owner to the client that acts on the owner's behalf
+// (RFC 8693). The scope can be narrowed and the audience can be changed to one of the client's exchange audiences.
+// The acting clients are kept in the act claim.
+func (s *Auth) tokenExchange(resp core.Response, req core.AccessCode) {
+	client, ok := s.authenticate(resp, req.ClientCredentials, req.GrantType)
+	if !ok {
+		return
+	}
+	if req.SubjectTokenType != tokenTypeAccessToken {
+		resp.Error(core.StatusErrorSubjectTokenInvalid)
+		return
+	}
+	subject, err := s.TokenRepo.Get(req.SubjectToken)
+	if err != nil {
+		resp.Error(core.StatusErrorSubjectTokenInvalid)
+		return
+	}
+	// the exchanged token doesn't outlive the subject one
+	ttl := int(subject.Expired.Sub(time.Now()) / time.Second)
+	if ttl <= 0 {
+		resp.Error(core.StatusErrorSubjectTokenInvalid)
+		return
+	}
+	grant := db.Grant{
+		OwnerID:  subject.OwnerID,
+		Scope:    subject.Scope,
+		TokenTTL: ttl,
+		Audience: subject.Audience,
+		Actors:   append([]string{client.ID}, subject.Actors...),
+
+		Authority: subject.Authority,
+	}
+	if req.Scope != "" {
+		// the scope of the exchanged token can't be wider than the subject one
+		if grant.Scope, ok = core.NarrowScope(req.Scope, strings.Fields(subject.Scope)); !ok {
+			resp.Error(core.StatusErrorScopeNotAllowed)
+			return
+		}
+	}
+	if req.Audience != "" {
+		if !hasString(client.ExchangeAudiences, req.Audience) {
+			resp.Error(core.StatusErrorAudienceNotAllowed)
+			return
+		}
+		grant.Audience = req.Audience
+	}
+	grant, ok = clientGrant(grant, client)
+	if !ok {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return
+	}
+	token, err := s.TokenRepo.Make(grant)
 	if err != nil {
-		s.Logger.Printf("AccessToken[Make refresh token]: %v", err)
+		s.Logger.Printf("AccessToken[Make exchanged token]: %v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
 		return
 	}
 	resp.Success(&core.Token{
+		Token:           token.Token,
+		ExpiresIn:       token.ExpiresIn,
+		Type:            "bearer",
+		IssuedTokenType: tokenTypeAccessToken,
+	})
+}
+
+// issueTokens makes the access token, the refresh token if it's requested and the id_token if the owner was
+// authenticated and the openid scope is granted
+func (s *Auth) issueTokens(resp core.Response, grant db.Grant, client *db.Client, withRefresh bool) {
+	token, err := s.TokenRepo.Make(grant)
+	if err != nil {
+		s.Logger.Printf("AccessToken[Make token]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	result := &core.Token{
 		Token:     token.Token,
-		Refresh:   refresh.Token,
 		ExpiresIn: token.ExpiresIn,
 		Type:      "bearer",
-	})
+	}
+	if s.IDTokens != nil && grant.AuthTime != 0 && core.HasScope(grant.Scope, core.ScopeOpenID) {
+		sub, err := s.subject(grant.OwnerID, client)
+		if err == nil {
+			result.IDToken, err = s.IDTokens.Make(grant, sub)
+		}
+		if err != nil {
+			s.Logger.Printf("AccessToken[Make id token]: %v", err)
+			resp.Error(core.StatusErrorInternalApplicationError)
+			return
+		}
+	}
+	if withRefresh {
+		refresh, err := s.RefreshRepo.Make(grant)
+		if err != nil {
+			s.Logger.Printf("AccessToken[Make refresh token]: %v", err)
+			resp.Error(core.StatusErrorInternalApplicationError)
+			return
+		}
+		result.Refresh = refresh.Token
+	}
+	resp.Success(result)
 }
-func (s *Auth) Refresh(resp core.Response, grantType string, token string) {
+func (s *Auth) Refresh(resp core.Response, grantType string, token string, cred core.ClientCredentials) {
 	if grantType != grantTypeRefreshToken {
 		resp.Error(core.StatusErrorUnsupportedGrantType)
 		return
 	}
+	client, ok := s.authenticate(resp, cred, grantType)
+	if !ok {
+		return
+	}
 	refreshToken, err := s.RefreshRepo.Get(token)
 	if err != nil {
 		s.Logger.Printf("Refresh[Get refresh token]: %v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
 		return
 	}
-	if refreshToken == nil {
+	if refreshToken == nil || (refreshToken.ClientID != "" && refreshToken.ClientID != client.ID) {
 		resp.Error(core.StatusErrorRefreshTokenNotFound)
 		return
 	}
-	accessToken, err := s.TokenRepo.Make(refreshToken.OwnerID, refreshToken.Scope)
+	if refreshToken.ClientID == "" {
+		// the token was issued before the clients were registered, it's bound to the first client that uses it
+		bound, err := s.RefreshRepo.Bind(refreshToken.Token, client.ID)
+		if err != nil {
+			s.Logger.Printf("Refresh[Bind refresh token]: %v", err)
+			resp.Error(core.StatusErrorInternalApplicationError)
+			return
+		}
+		if !bound {
+			resp.Error(core.StatusErrorRefreshTokenNotFound)
+			return
+		}
+	}
+	if !s.revalidateCard(resp, refreshToken) {
+		return
+	}
+	grant, ok := clientGrant(refreshToken.Grant, client)
+	if !ok {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return
+	}
+	accessToken, err := s.TokenRepo.Make(grant)
 	if err != nil {
 		s.Logger.Printf("Refresh[Get access token]: %v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
@@ -88,17 +447,199 @@ func (s *Auth) Refresh(resp core.Response, grantType string, token string) {
 	})
 }
 func (s *Auth) Verify(resp core.Response, token string) {
+	accessToken, status := s.accessToken(token)
+	if accessToken == nil {
+		resp.Error(status)
+		return
+	}
+	resp.Success(&core.OwnerCard{
+		ID:       accessToken.OwnerID,
+		Scope:    accessToken.Scope,
+		ClientID: accessToken.ClientID,
+		Audience: accessToken.Audience,
+		Actor:    core.NewActor(accessToken.Actors),
+
+		Authority: accessToken.Authority,
+	})
+}
+
+// Introspect returns the state of the token (RFC 7662). Any authenticated client may introspect access tokens as it
+// may verify them, but a refresh token is active only for the client that it was issued to. The token type hint is
+// ignored, both kinds of tokens are looked up.
+func (s *Auth) Introspect(resp core.Response, req core.TokenRequest) {
+	client, ok := s.authenticateClient(resp, req.ClientCredentials)
+	if !ok {
+		return
+	}
+	if accessToken, _ := s.accessToken(req.Token); accessToken != nil {
+		resp.Success(&core.Introspection{
+			Active:    true,
+			Scope:     accessToken.Scope,
+			ClientID:  accessToken.ClientID,
+			Subject:   accessToken.OwnerID,
+			Audience:  accessToken.Audience,
+			ExpiresAt: accessToken.Expired.Unix(),
+			TokenType: "bearer",
+		})
+		return
+	}
+	refreshToken, err := s.RefreshRepo.Get(req.Token)
+	if err != nil {
+		s.Logger.Printf("Introspect[Get refresh token]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	if refreshToken == nil || refreshToken.ClientID != client.ID || time.Now().After(refreshToken.Expired) {
+		resp.Success(&core.Introspection{})
+		return
+	}
+	resp.Success(&core.Introspection{
+		Active:    true,
+		Scope:     refreshToken.Scope,
+		ClientID:  refreshToken.ClientID,
+		Subject:   refreshToken.OwnerID,
+		ExpiresAt: refreshToken.Expired.Unix(),
+	})
+}
+
+// Revoke removes the refresh token of the client (RFC 7009). Access tokens aren't stored, so they stay valid until
+// they expire. The unknown token and the token of another client aren't errors and are left as they are, so the
+// revocation can be repeated.
+func (s *Auth) Revoke(resp core.Response, req core.TokenRequest) {
+	client, ok := s.authenticateClient(resp, req.ClientCredentials)
+	if !ok {
+		return
+	}
+	refreshToken, err := s.RefreshRepo.Get(req.Token)
+	if err != nil {
+		s.Logger.Printf("Revoke[Get refresh token]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	if refreshToken != nil && refreshToken.ClientID == client.ID {
+		if err := s.RefreshRepo.Remove(refreshToken.Token); err != nil {
+			s.Logger.Printf("Revoke[Remove refresh token]: %v", err)
+			resp.Error(core.StatusErrorInternalApplicationError)
+			return
+		}
+	}
+	resp.Success(struct{}{})
+}
+
+// accessToken returns the valid access token, or nil and the reason why the token isn't valid
+func (s *Auth) accessToken(token string) (*db.AccessToken, core.ResponseStatus) {
 	accessToken, err := s.TokenRepo.Get(token)
 	if err != nil {
 		// HACK: We don't log this err because it contains many correct errors (token="", sign fraud and etc)
 		//s.Logger.Printf("Verify[get token]: %v", err)
-		resp.Error(core.StatusErrorAccessTokenBroken)
-		return
+		return nil, core.StatusErrorAccessTokenBroken
 	}
 
 	if time.Now().After(accessToken.Expired) {
-		resp.Error(core.StatusErrorAccessTokenExpired)
-		return
+		return nil, core.StatusErrorAccessTokenExpired
+	}
+	return accessToken, 0
+}
+
+// revalidateCard checks that the owner card is neither revoked nor replaced by a new card and that its signatures are
+// still valid. The refresh token of such a card is revoked. The inline card that the Cards service doesn't have yet
+// isn't revoked, it's checked once it appears there. Other errors of the Cards service, e.g. a denied access of the
+// service itself, keep the token.
+func (s *Auth) revalidateCard(resp core.Response, refreshToken *db.RefreshToken) bool {
+	if s.Cards == nil || s.validCards.get(refreshToken.OwnerID) != nil {
+		return true
+	}
+	card, err := s.Cards.GetCard(refreshToken.OwnerID)
+	if err != nil {
+		switch core.CardErrorKindOf(err) {
+		case core.CardNotFound:
+			if refreshToken.InlineCard {
+				return true
+			}
+		case core.CardSignatureMissing, core.CardSignatureInvalid:
+		default:
+			cardServiceError(resp, s.Logger, "Refresh[GetCard]", err)
+			return false
+		}
+	} else if !card.IsOutdated {
+		s.validCards.put(refreshToken.OwnerID, true, s.CardRevalidation)
+		return true
+	}
+	if err = s.RefreshRepo.Remove(refreshToken.Token); err != nil {
+		s.Logger.Printf("Refresh[Remove refresh token]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return false
+	}
+	resp.Error(core.StatusErrorOwnerCardRevoked)
+	return false
+}
+
+// subject returns the sub of the id_token: the card id or the pairwise identifier if the client is registered with it
+func (s *Auth) subject(cardID string, client *db.Client) (string, error) {
+	if client.SubjectType != core.SubjectTypePairwise {
+		return cardID, nil
+	}
+	if s.PairwiseSecret == "" {
+		return "", fmt.Errorf("client %v requires pairwise subjects but the pairwise secret isn't set", client.ID)
+	}
+	return core.PairwiseSubject(s.PairwiseSecret, client.ID, cardID), nil
+}
+
+// authenticate checks the client credentials and that the client may use the grant type
+func (s *Auth) authenticate(resp core.Response, cred core.ClientCredentials, grantType string) (*db.Client, bool) {
+	client, ok := s.authenticateClient(resp, cred)
+	if !ok {
+		return nil, false
+	}
+	if !client.AllowsGrantType(grantType) {
+		resp.Error(core.StatusErrorGrantTypeNotAllowed)
+		return nil, false
+	}
+	return client, true
+}
+
+// authenticateClient authenticates the client that calls an endpoint other than the token one, any grant type is fine
+func (s *Auth) authenticateClient(resp core.Response, cred core.ClientCredentials) (*db.Client, bool) {
+	client, err := s.Clients.Authenticate(cred)
+	if err != nil {
+		// the application card of the client is not found or invalid
+		if _, ok := cardErrorStatus(err); ok {
+			resp.Error(core.StatusErrorClientAuthenticationFailed)
+			return nil, false
+		}
+		cardServiceError(resp, s.Logger, "Authenticate client "+cred.ID, err)
+		return nil, false
+	}
+	if client == nil {
+		resp.Error(core.StatusErrorClientAuthenticationFailed)
+		return nil, false
+	}
+	return client, true
+}
+
+// clientGrant binds the grant to the client and applies the client restrictions
+func clientGrant(grant db.Grant, client *db.Client) (db.Grant, bool) {
+	grant.ClientID = client.ID
+	grant.CodeChallenge, grant.CodeChallengeMethod = "", ""
+	grant.RedirectURI, grant.State, grant.ChallengeMethod, grant.UserCode = "", "", "", ""
+	if len(client.Scopes) != 0 {
+		scope, ok := core.NarrowScope(grant.Scope, client.Scopes)
+		if !ok {
+			return grant, false
+		}
+		grant.Scope = scope
+	}
+	if client.AccessTokenTTL > 0 && (grant.TokenTTL == 0 || client.AccessTokenTTL < grant.TokenTTL) {
+		grant.TokenTTL = client.AccessTokenTTL
+	}
+	return grant, true
+}
+
+// verifyCode checks the PKCE code verifier. A code without the challenge is accepted only if the client doesn't
+// require PKCE.
+func verifyCode(code *db.Code, client *db.Client, verifier string) bool {
+	if code.CodeChallenge == "" {
+		return !client.RequirePKCE
 	}
-	resp.Success(&core.OwnerCard{ID: accessToken.OwnerID, Scope: accessToken.Scope})
+	return core.VerifyCodeChallenge(code.CodeChallenge, code.CodeChallengeMethod, verifier)
 }
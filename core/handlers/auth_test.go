@@ -2,11 +2,15 @@ package handlers
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	virgil "gopkg.in/virgil.v5/sdk"
+
 	"github.com/VirgilSecurity/virgil-services-auth/core"
 	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -23,6 +27,14 @@ func (r *FakeResponse) Success(model interface{}) {
 	r.Called(model)
 }
 
+func (r *FakeResponse) Created(model interface{}) {
+	r.Called(model)
+}
+
+func (r *FakeResponse) NoContent() {
+	r.Called()
+}
+
 type FakeCodeRepo struct {
 	mock.Mock
 }
@@ -33,13 +45,17 @@ func (r *FakeCodeRepo) GetCode(code string) (dCode *db.Code, err error) {
 	err = args.Error(1)
 	return
 }
+func (r *FakeCodeRepo) Use(code string) (bool, error) {
+	args := r.Called(code)
+	return args.Bool(0), args.Error(1)
+}
 
 type FakeTokenRepo struct {
 	mock.Mock
 }
 
-func (s *FakeTokenRepo) Make(ownerID string, scope string) (t *db.AccessToken, err error) {
-	args := s.Called(ownerID)
+func (s *FakeTokenRepo) Make(grant db.Grant) (t *db.AccessToken, err error) {
+	args := s.Called(grant.OwnerID)
 	t, _ = args.Get(0).(*db.AccessToken)
 	err = args.Error(1)
 	return
@@ -56,8 +72,8 @@ type FakeRefreshRepo struct {
 	mock.Mock
 }
 
-func (r *FakeRefreshRepo) Make(ownerID string, scope string) (t *db.RefreshToken, err error) {
-	args := r.Called(ownerID)
+func (r *FakeRefreshRepo) Make(grant db.Grant) (t *db.RefreshToken, err error) {
+	args := r.Called(grant.OwnerID)
 	t, _ = args.Get(0).(*db.RefreshToken)
 	err = args.Error(1)
 	return
@@ -68,6 +84,73 @@ func (r *FakeRefreshRepo) Get(token string) (t *db.RefreshToken, err error) {
 	err = args.Error(1)
 	return
 }
+func (r *FakeRefreshRepo) Remove(token string) error {
+	return r.Called(token).Error(0)
+}
+func (r *FakeRefreshRepo) Bind(token, clientID string) (bool, error) {
+	args := r.Called(token, clientID)
+	return args.Bool(0), args.Error(1)
+}
+func (r *FakeRefreshRepo) RemoveByClient(ownerID, clientID string) error {
+	return r.Called(ownerID, clientID).Error(0)
+}
+
+type FakeClientAuthenticator struct {
+	mock.Mock
+}
+
+func (a *FakeClientAuthenticator) Authenticate(cred core.ClientCredentials) (c *db.Client, err error) {
+	args := a.Called(cred)
+	c, _ = args.Get(0).(*db.Client)
+	err = args.Error(1)
+	return
+}
+
+// authenticatedClient authenticates any credentials as the client
+func authenticatedClient(client db.Client) *FakeClientAuthenticator {
+	a := new(FakeClientAuthenticator)
+	a.On("Authenticate", mock.Anything).Return(&client, nil)
+	return a
+}
+
+type FakeDeviceCodeRepo struct {
+	mock.Mock
+}
+
+func (r *FakeDeviceCodeRepo) Make(grant db.Grant) (d *db.DeviceCode, err error) {
+	args := r.Called(grant.ClientID, grant.Scope)
+	d, _ = args.Get(0).(*db.DeviceCode)
+	err = args.Error(1)
+	return
+}
+
+func (r *FakeDeviceCodeRepo) GetByUserCode(userCode string) (d *db.DeviceCode, err error) {
+	args := r.Called(userCode)
+	d, _ = args.Get(0).(*db.DeviceCode)
+	err = args.Error(1)
+	return
+}
+
+func (r *FakeDeviceCodeRepo) Approve(userCode string, grant db.Grant) (bool, error) {
+	args := r.Called(userCode, grant.OwnerID, grant.Scope)
+	return args.Bool(0), args.Error(1)
+}
+
+func (r *FakeDeviceCodeRepo) Poll(code string) (d *db.DeviceCode, err error) {
+	args := r.Called(code)
+	d, _ = args.Get(0).(*db.DeviceCode)
+	err = args.Error(1)
+	return
+}
+
+func (r *FakeDeviceCodeRepo) SlowDown(code string) error {
+	return r.Called(code).Error(0)
+}
+
+func (r *FakeDeviceCodeRepo) Use(code string) (bool, error) {
+	args := r.Called(code)
+	return args.Bool(0), args.Error(1)
+}
 
 func TestAccessToken_UnsupportedGrantType_ReturnErr(t *testing.T) {
 	resp := new(FakeResponse)
@@ -89,7 +172,8 @@ func TestAccessToken_CodeRepoReturnErr_ReturnErr(t *testing.T) {
 	l := new(FakeLogger)
 	l.On("Printf").Once()
 
-	a := Auth{CodeRepo: r, Logger: l}
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}})
+	a := Auth{Clients: clients, CodeRepo: r, Logger: l}
 	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
 
 	resp.AssertExpectations(t)
@@ -103,7 +187,7 @@ func TestAccessToken_CodeRepoReturnNil_ReturnErr(t *testing.T) {
 	r := new(FakeCodeRepo)
 	r.On("GetCode", mock.Anything).Return(nil, nil)
 
-	a := Auth{CodeRepo: r}
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}}), CodeRepo: r}
 	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
 
 	resp.AssertExpectations(t)
@@ -116,7 +200,7 @@ func TestAccessToken_CodeRepoCodeUsed_ReturnErr(t *testing.T) {
 	r := new(FakeCodeRepo)
 	r.On("GetCode", mock.Anything).Return(&db.Code{Used: true}, nil)
 
-	a := Auth{CodeRepo: r}
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}}), CodeRepo: r}
 	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
 
 }
@@ -128,7 +212,7 @@ func TestAccessToken_CodeRepoCodeExpired_ReturnErr(t *testing.T) {
 	r := new(FakeCodeRepo)
 	r.On("GetCode", mock.Anything).Return(&db.Code{Used: false, Expired: time.Now().Add(-10 * time.Hour).UTC()}, nil)
 
-	a := Auth{CodeRepo: r}
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}}), CodeRepo: r}
 	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
 
 	resp.AssertExpectations(t)
@@ -140,6 +224,7 @@ func TestAccessToken_TokenRepoReturnErr_ReturnErr(t *testing.T) {
 
 	r := new(FakeCodeRepo)
 	r.On("GetCode", mock.Anything).Return(&db.Code{Used: false, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+	r.On("Use", mock.Anything).Return(true, nil)
 
 	l := new(FakeLogger)
 	l.On("Printf").Once()
@@ -147,7 +232,8 @@ func TestAccessToken_TokenRepoReturnErr_ReturnErr(t *testing.T) {
 	tr := new(FakeTokenRepo)
 	tr.On("Make", mock.Anything, mock.Anything).Return(nil, fmt.Errorf("ERROR"))
 
-	a := Auth{CodeRepo: r, Logger: l, TokenRepo: tr}
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}})
+	a := Auth{Clients: clients, CodeRepo: r, Logger: l, TokenRepo: tr}
 	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
 
 	resp.AssertExpectations(t)
@@ -160,6 +246,7 @@ func TestAccessToken_RefreshRepoReturnErr_ReturnErr(t *testing.T) {
 
 	r := new(FakeCodeRepo)
 	r.On("GetCode", mock.Anything).Return(&db.Code{Used: false, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+	r.On("Use", mock.Anything).Return(true, nil)
 
 	l := new(FakeLogger)
 	l.On("Printf").Once()
@@ -170,7 +257,8 @@ func TestAccessToken_RefreshRepoReturnErr_ReturnErr(t *testing.T) {
 	rr := new(FakeRefreshRepo)
 	rr.On("Make", mock.Anything).Return("", fmt.Errorf("ERROR"))
 
-	a := Auth{CodeRepo: r, Logger: l, TokenRepo: tr, RefreshRepo: rr}
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}, IssueRefreshToken: true})
+	a := Auth{Clients: clients, CodeRepo: r, Logger: l, TokenRepo: tr, RefreshRepo: rr}
 	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
 
 	resp.AssertExpectations(t)
@@ -194,7 +282,8 @@ func TestAccessToken_ReturnResult(t *testing.T) {
 	resp.On("Success", expected).Once()
 
 	r := new(FakeCodeRepo)
-	r.On("GetCode", code).Return(&db.Code{Used: false, OwnerID: ownerID, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+	r.On("GetCode", code).Return(&db.Code{Used: false, Grant: db.Grant{OwnerID: ownerID}, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+	r.On("Use", mock.Anything).Return(true, nil)
 
 	tr := new(FakeTokenRepo)
 	tr.On("Make", ownerID).Return(&db.AccessToken{Token: expected.Token, ExpiresIn: expected.ExpiresIn}, nil)
@@ -202,18 +291,740 @@ func TestAccessToken_ReturnResult(t *testing.T) {
 	rr := new(FakeRefreshRepo)
 	rr.On("Make", ownerID).Return(&db.RefreshToken{Token: expected.Refresh}, nil)
 
-	a := Auth{CodeRepo: r, TokenRepo: tr, RefreshRepo: rr}
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}, IssueRefreshToken: true})
+	a := Auth{Clients: clients, CodeRepo: r, TokenRepo: tr, RefreshRepo: rr}
 	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: code})
 
 	resp.AssertExpectations(t)
 }
 
+func TestAccessToken_ClientAuthenticationFailed_ReturnErr(t *testing.T) {
+	cred := core.ClientCredentials{ID: "client", Secret: "wrong"}
+
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorClientAuthenticationFailed).Once()
+
+	c := new(FakeClientAuthenticator)
+	c.On("Authenticate", cred).Return(nil, nil)
+
+	a := Auth{Clients: c}
+	a.AccessToken(resp, core.AccessCode{ClientCredentials: cred, GrantType: grantTypeAccessCode})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_ClientAuthenticatorReturnErr_ReturnInternalErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	c := new(FakeClientAuthenticator)
+	c.On("Authenticate", mock.Anything).Return(nil, fmt.Errorf("ERROR"))
+
+	l := new(FakeLogger)
+	l.On("Printf").Once()
+
+	a := Auth{Clients: c, Logger: l}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
+
+	resp.AssertExpectations(t)
+	l.AssertExpectations(t)
+}
+
+func TestAccessToken_GrantTypeNotAllowedForClient_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorGrantTypeNotAllowed).Once()
+
+	c := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+
+	a := Auth{Clients: c}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_CodeOfAnotherClient_ReturnCodeNotFound(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCodeNotFound).Once()
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", mock.Anything).Return(&db.Code{Grant: db.Grant{ClientID: "another"}, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}}), CodeRepo: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
+
+	resp.AssertExpectations(t)
+	// the code isn't burnt by the wrong client
+	r.AssertNotCalled(t, "Use", mock.Anything)
+}
+
+func TestAccessToken_CodeWithoutClientForSigningClient_ReturnCodeNotFound(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCodeNotFound).Once()
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", mock.Anything).Return(&db.Code{Grant: db.Grant{OwnerID: "card"}, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+
+	c := authenticatedClient(db.Client{ID: "app", GrantTypes: []string{grantTypeAccessCode}, RequireRequestSign: true})
+
+	a := Auth{Clients: c, CodeRepo: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode})
+
+	resp.AssertExpectations(t)
+	// the code isn't burnt by the wrong client
+	r.AssertNotCalled(t, "Use", mock.Anything)
+}
+
+func TestAccessToken_CodeUsedConcurrently_ReturnCodeWasUsed(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCodeWasUsed).Once()
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", "code").Return(&db.Code{Code: "code", Grant: db.Grant{OwnerID: "card"}, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+	r.On("Use", "code").Return(false, nil).Once()
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}}), CodeRepo: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code"})
+
+	resp.AssertExpectations(t)
+	r.AssertExpectations(t)
+}
+
+func TestAccessToken_ClientWithoutRefreshTokens_ReturnOnlyAccessToken(t *testing.T) {
+	expected := &core.Token{
+		Token:     "token",
+		ExpiresIn: 60,
+		Type:      "bearer",
+	}
+
+	resp := new(FakeResponse)
+	resp.On("Success", expected).Once()
+
+	c := authenticatedClient(db.Client{
+		ID:             "client",
+		GrantTypes:     []string{grantTypeAccessCode},
+		Scopes:         []string{"profile", "mail"},
+		AccessTokenTTL: 60,
+	})
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", "code").Return(&db.Code{Grant: db.Grant{OwnerID: "owner", Scope: "*"}, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+	r.On("Use", mock.Anything).Return(true, nil)
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "owner").Return(&db.AccessToken{Token: expected.Token, ExpiresIn: expected.ExpiresIn}, nil)
+
+	a := Auth{Clients: c, CodeRepo: r, TokenRepo: tr}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code"})
+
+	resp.AssertExpectations(t)
+	tr.AssertExpectations(t)
+}
+
+const (
+	testCodeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testCodeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestAccessToken_RedirectURIMismatch_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRedirectURIInvalid).Once()
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", "code").Return(&db.Code{
+		Grant:   db.Grant{OwnerID: "owner", RedirectURI: "https://app.example.com/cb"},
+		Expired: time.Now().Add(10 * time.Hour).UTC(),
+	}, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}}), CodeRepo: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_CodeVerifierMismatch_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCodeVerifierInvalid).Once()
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", "code").Return(&db.Code{
+		Grant:   db.Grant{OwnerID: "owner", CodeChallenge: testCodeChallenge, CodeChallengeMethod: core.CodeChallengeMethodS256},
+		Expired: time.Now().Add(10 * time.Hour).UTC(),
+	}, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeAccessCode}}), CodeRepo: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code", CodeVerifier: strings.Repeat("a", 43)})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_ClientRequiresPKCE_CodeWithoutChallenge_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCodeVerifierInvalid).Once()
+
+	c := authenticatedClient(db.Client{
+		ID:          "client",
+		GrantTypes:  []string{grantTypeAccessCode},
+		RequirePKCE: true,
+	})
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", "code").Return(&db.Code{Grant: db.Grant{OwnerID: "owner"}, Expired: time.Now().Add(10 * time.Hour).UTC()}, nil)
+
+	a := Auth{Clients: c, CodeRepo: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code", CodeVerifier: testCodeVerifier})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_CodeVerifierMatches_ReturnToken(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Token{Token: "token", ExpiresIn: 60, Type: "bearer"}).Once()
+
+	c := authenticatedClient(db.Client{
+		ID:          "client",
+		GrantTypes:  []string{grantTypeAccessCode},
+		RequirePKCE: true,
+	})
+
+	r := new(FakeCodeRepo)
+	r.On("GetCode", "code").Return(&db.Code{
+		Grant:   db.Grant{OwnerID: "owner", CodeChallenge: testCodeChallenge, CodeChallengeMethod: core.CodeChallengeMethodS256},
+		Expired: time.Now().Add(10 * time.Hour).UTC(),
+	}, nil)
+	r.On("Use", mock.Anything).Return(true, nil)
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "owner").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil)
+
+	a := Auth{Clients: c, CodeRepo: r, TokenRepo: tr}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code", CodeVerifier: testCodeVerifier})
+
+	resp.AssertExpectations(t)
+}
+
+type FakeAssertionVerifier struct {
+	mock.Mock
+}
+
+func (v *FakeAssertionVerifier) Verify(assertion string) (card *virgil.Card, err error) {
+	args := v.Called(assertion)
+	card, _ = args.Get(0).(*virgil.Card)
+	err = args.Error(1)
+	return
+}
+
+func TestAccessToken_JWTBearerWithoutVerifier_ReturnUnsupportedGrantType(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorUnsupportedGrantType).Once()
+
+	a := Auth{}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeJWTBearer, Assertion: "jwt"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_JWTBearerInvalid_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorAssertionInvalid).Once()
+
+	v := new(FakeAssertionVerifier)
+	v.On("Verify", "jwt").Return(nil, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeJWTBearer}}), Assertions: v}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeJWTBearer, Assertion: "jwt"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_JWTBearerCardNotFound_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCardNotFound).Once()
+
+	v := new(FakeAssertionVerifier)
+	v.On("Verify", "jwt").Return(nil, cardErr(core.CardNotFound))
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeJWTBearer}}), Assertions: v}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeJWTBearer, Assertion: "jwt"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_JWTBearer_ReturnToken(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Token{Token: "token", ExpiresIn: 60, Type: "bearer"}).Once()
+
+	v := new(FakeAssertionVerifier)
+	v.On("Verify", "jwt").Return(&virgil.Card{Id: "card"}, nil)
+
+	p := new(FakePolicy)
+	p.On("Evaluate", mock.MatchedBy(func(r core.PolicyRequest) bool {
+		return r.CardID == "card" && r.ClientID == "client" && r.Scope == "profile"
+	})).Return(core.PolicyDecision{Allowed: true, Scope: "profile"})
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil)
+
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeJWTBearer}})
+	a := Auth{Clients: clients, Assertions: v, Policy: p, TokenRepo: tr}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeJWTBearer, Assertion: "jwt", Scope: "profile"})
+
+	resp.AssertExpectations(t)
+	tr.AssertExpectations(t)
+}
+
+func TestAccessToken_ClientCredentialsPublicClient_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorClientAuthenticationFailed).Once()
+
+	c := authenticatedClient(db.Client{ID: "daemon", GrantTypes: []string{grantTypeClientCredentials}})
+
+	a := Auth{Clients: c}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeClientCredentials})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_ClientCredentialsScopeNotRegistered_ReturnErr(t *testing.T) {
+	table := []string{"", "admin"}
+	for _, scope := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorScopeNotAllowed).Once()
+
+		client := db.Client{
+			ID:         "daemon",
+			SecretHash: "hash",
+			Scopes:     []string{"cards", "keys"},
+			GrantTypes: []string{grantTypeClientCredentials},
+		}
+		if scope == "" {
+			// a client without registered scopes cannot use the grant
+			client.Scopes = nil
+		}
+
+		a := Auth{Clients: authenticatedClient(client)}
+		a.AccessToken(resp, core.AccessCode{GrantType: grantTypeClientCredentials, Scope: scope})
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func TestAccessToken_ClientCredentials_ReturnTokenWithoutRefresh(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Token{Token: "token", ExpiresIn: 60, Type: "bearer"}).Once()
+
+	var grant db.Grant
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil).Once()
+
+	client := db.Client{
+		ID:                "daemon",
+		SecretHash:        "hash",
+		Scopes:            []string{"cards", "keys"},
+		GrantTypes:        []string{grantTypeClientCredentials},
+		IssueRefreshToken: true,
+	}
+	a := Auth{Clients: authenticatedClient(client), TokenRepo: &grantRecorder{FakeTokenRepo: tr, grant: &grant}}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeClientCredentials})
+
+	resp.AssertExpectations(t)
+	tr.AssertExpectations(t)
+	// the token has no owner, the client id tells whose it is
+	assert.Empty(t, grant.OwnerID)
+	assert.Equal(t, "daemon", grant.ClientID)
+}
+
+func TestAccessToken_ClientCardNotFound_ReturnAuthenticationFailed(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorClientAuthenticationFailed).Once()
+
+	c := new(FakeClientAuthenticator)
+	c.On("Authenticate", mock.Anything).Return(nil, cardErr(core.CardNotFound))
+
+	a := Auth{Clients: c}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeClientCredentials})
+
+	resp.AssertExpectations(t)
+}
+
+func TestDeviceAuthorization_ReturnCodes(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.MatchedBy(func(d *core.DeviceAuthorization) bool {
+		return d.DeviceCode == "device" && d.UserCode == "BCDF-GHJK" &&
+			d.VerificationURI == "https://auth.example.com/v5/device" &&
+			d.VerificationURIComplete == "https://auth.example.com/v5/device?user_code=BCDF-GHJK" &&
+			d.ExpiresIn > 500 && d.Interval == 5
+	})).Once()
+
+	r := new(FakeDeviceCodeRepo)
+	r.On("Make", "tv", "cards").Return(&db.DeviceCode{
+		Grant:    db.Grant{ClientID: "tv", Scope: "cards", UserCode: "BCDFGHJK"},
+		Code:     "device",
+		Interval: 5,
+		Expired:  time.Now().Add(10 * time.Minute),
+	}, nil).Once()
+
+	clients := authenticatedClient(db.Client{ID: "tv", GrantTypes: []string{core.GrantTypeDeviceCode}})
+	a := Auth{Clients: clients, Devices: r, VerificationURI: "https://auth.example.com/v5/device"}
+	a.DeviceAuthorization(resp, core.DeviceAuthorizationRequest{Scope: "cards"})
+
+	resp.AssertExpectations(t)
+	r.AssertExpectations(t)
+}
+
+func TestDeviceAuthorization_WithoutRepo_ReturnUnsupportedGrantType(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorUnsupportedGrantType).Once()
+
+	a := Auth{}
+	a.DeviceAuthorization(resp, core.DeviceAuthorizationRequest{})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_DeviceCodePending_ReturnErr(t *testing.T) {
+	table := map[time.Time]core.ResponseStatus{
+		time.Time{}:                       core.StatusErrorAuthorizationPending,
+		time.Now().Add(-10 * time.Second): core.StatusErrorAuthorizationPending,
+		time.Now().Add(-time.Second):      core.StatusErrorSlowDown,
+	}
+	for lastPoll, status := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", status).Once()
+
+		r := new(FakeDeviceCodeRepo)
+		r.On("Poll", "device").Return(&db.DeviceCode{
+			Grant:    db.Grant{ClientID: "tv"},
+			Code:     "device",
+			Interval: 5,
+			LastPoll: lastPoll,
+			Expired:  time.Now().Add(time.Minute),
+		}, nil)
+		if status == core.StatusErrorSlowDown {
+			r.On("SlowDown", "device").Return(nil).Once()
+		}
+
+		a := Auth{Clients: authenticatedClient(db.Client{ID: "tv", GrantTypes: []string{core.GrantTypeDeviceCode}}), Devices: r}
+		a.AccessToken(resp, core.AccessCode{GrantType: core.GrantTypeDeviceCode, DeviceCode: "device"})
+
+		resp.AssertExpectations(t)
+		r.AssertExpectations(t)
+	}
+}
+
+func TestAccessToken_DeviceCodeOfAnotherClient_ReturnCodeNotFound(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCodeNotFound).Once()
+
+	r := new(FakeDeviceCodeRepo)
+	r.On("Poll", "device").Return(&db.DeviceCode{Grant: db.Grant{ClientID: "another"}, Expired: time.Now().Add(time.Minute)}, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "tv", GrantTypes: []string{core.GrantTypeDeviceCode}}), Devices: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: core.GrantTypeDeviceCode, DeviceCode: "device"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_DeviceCodeUsed_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCodeWasUsed).Once()
+
+	r := new(FakeDeviceCodeRepo)
+	r.On("Poll", "device").Return(&db.DeviceCode{
+		Grant:    db.Grant{ClientID: "tv", OwnerID: "card"},
+		Code:     "device",
+		Approved: true,
+		Expired:  time.Now().Add(time.Minute),
+	}, nil)
+	r.On("Use", "device").Return(false, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "tv", GrantTypes: []string{core.GrantTypeDeviceCode}}), Devices: r}
+	a.AccessToken(resp, core.AccessCode{GrantType: core.GrantTypeDeviceCode, DeviceCode: "device"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAccessToken_DeviceCodeApproved_ReturnToken(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Token{Token: "token", ExpiresIn: 60, Type: "bearer"}).Once()
+
+	r := new(FakeDeviceCodeRepo)
+	r.On("Poll", "device").Return(&db.DeviceCode{
+		Grant:    db.Grant{ClientID: "tv", OwnerID: "card", Scope: "cards", UserCode: "BCDFGHJK"},
+		Code:     "device",
+		Approved: true,
+		LastPoll: time.Now(),
+		Interval: 5,
+		Expired:  time.Now().Add(time.Minute),
+	}, nil)
+	r.On("Use", "device").Return(true, nil).Once()
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil).Once()
+
+	clients := authenticatedClient(db.Client{ID: "tv", GrantTypes: []string{core.GrantTypeDeviceCode}})
+	a := Auth{Clients: clients, Devices: r, TokenRepo: tr}
+	a.AccessToken(resp, core.AccessCode{GrantType: core.GrantTypeDeviceCode, DeviceCode: "device"})
+
+	resp.AssertExpectations(t)
+	r.AssertExpectations(t)
+	tr.AssertExpectations(t)
+}
+
+func TestAccessToken_TokenExchangeSubjectInvalid_ReturnErr(t *testing.T) {
+	table := []core.AccessCode{
+		{SubjectTokenType: "urn:ietf:params:oauth:token-type:id_token", SubjectToken: "valid"},
+		{SubjectTokenType: tokenTypeAccessToken, SubjectToken: "broken"},
+		{SubjectTokenType: tokenTypeAccessToken, SubjectToken: "expired"},
+	}
+	for _, req := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorSubjectTokenInvalid).Once()
+
+		tr := new(FakeTokenRepo)
+		tr.On("Get", "valid").Return(&db.AccessToken{OwnerID: "card", Expired: time.Now().Add(time.Minute)}, nil)
+		tr.On("Get", "broken").Return(nil, fmt.Errorf("signature is invalid"))
+		tr.On("Get", "expired").Return(&db.AccessToken{OwnerID: "card", Expired: time.Now().Add(-time.Minute)}, nil)
+
+		req.GrantType = grantTypeTokenExchange
+		client := db.Client{
+			ID:                "gateway",
+			SecretHash:        "hash",
+			GrantTypes:        []string{grantTypeTokenExchange},
+			ExchangeAudiences: []string{"mail-service"},
+		}
+		a := Auth{Clients: authenticatedClient(client), TokenRepo: tr}
+		a.AccessToken(resp, req)
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func TestAccessToken_TokenExchangeNotPermitted_ReturnErr(t *testing.T) {
+	table := []struct {
+		req    core.AccessCode
+		status core.ResponseStatus
+	}{
+		{core.AccessCode{Scope: "profile admin"}, core.StatusErrorScopeNotAllowed},
+		{core.AccessCode{Audience: "billing"}, core.StatusErrorAudienceNotAllowed},
+	}
+	for _, v := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", v.status).Once()
+
+		tr := new(FakeTokenRepo)
+		tr.On("Get", "subject").Return(&db.AccessToken{OwnerID: "card", Scope: "profile mail", Expired: time.Now().Add(time.Minute)}, nil)
+
+		v.req.GrantType, v.req.SubjectToken, v.req.SubjectTokenType = grantTypeTokenExchange, "subject", tokenTypeAccessToken
+		client := db.Client{
+			ID:                "gateway",
+			SecretHash:        "hash",
+			GrantTypes:        []string{grantTypeTokenExchange},
+			ExchangeAudiences: []string{"mail-service"},
+		}
+		a := Auth{Clients: authenticatedClient(client), TokenRepo: tr}
+		a.AccessToken(resp, v.req)
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func TestAccessToken_TokenExchange_ReturnDownscopedToken(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Token{Token: "exchanged", ExpiresIn: 60, Type: "bearer", IssuedTokenType: tokenTypeAccessToken}).Once()
+
+	var grant db.Grant
+	tr := new(FakeTokenRepo)
+	tr.On("Get", "subject").Return(&db.AccessToken{
+		OwnerID:  "card",
+		ClientID: "web",
+		Scope:    "profile mail",
+		Actors:   []string{"proxy"},
+		Expired:  time.Now().Add(time.Minute),
+
+		Authority: "partner",
+	}, nil)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "exchanged", ExpiresIn: 60}, nil).Once()
+
+	client := db.Client{
+		ID:                "gateway",
+		SecretHash:        "hash",
+		GrantTypes:        []string{grantTypeTokenExchange},
+		ExchangeAudiences: []string{"mail-service"},
+	}
+	a := Auth{Clients: authenticatedClient(client), TokenRepo: &grantRecorder{FakeTokenRepo: tr, grant: &grant}}
+	a.AccessToken(resp, core.AccessCode{
+		GrantType:        grantTypeTokenExchange,
+		SubjectToken:     "subject",
+		SubjectTokenType: tokenTypeAccessToken,
+		Scope:            "mail",
+		Audience:         "mail-service",
+	})
+
+	resp.AssertExpectations(t)
+	tr.AssertExpectations(t)
+	assert.Equal(t, "gateway", grant.ClientID)
+	assert.Equal(t, "mail", grant.Scope)
+	assert.Equal(t, "mail-service", grant.Audience)
+	assert.Equal(t, []string{"gateway", "proxy"}, grant.Actors)
+	assert.Equal(t, "partner", grant.Authority)
+	assert.True(t, grant.TokenTTL > 0 && grant.TokenTTL <= 60)
+}
+
+// grantRecorder remembers the grant of the made token
+type grantRecorder struct {
+	*FakeTokenRepo
+	grant *db.Grant
+}
+
+func (r *grantRecorder) Make(grant db.Grant) (*db.AccessToken, error) {
+	*r.grant = grant
+	return r.FakeTokenRepo.Make(grant)
+}
+
+func TestVerify_ExchangedToken_ReturnActor(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.OwnerCard{
+		ID:       "card",
+		ClientID: "gateway",
+		Audience: "mail-service",
+		Actor:    &core.Actor{ClientID: "gateway", Actor: &core.Actor{ClientID: "proxy"}},
+	}).Once()
+
+	tr := new(FakeTokenRepo)
+	tr.On("Get", "token").Return(&db.AccessToken{
+		OwnerID:  "card",
+		ClientID: "gateway",
+		Audience: "mail-service",
+		Actors:   []string{"gateway", "proxy"},
+		Expired:  time.Now().Add(time.Minute),
+	}, nil)
+
+	a := Auth{TokenRepo: tr}
+	a.Verify(resp, "token")
+
+	resp.AssertExpectations(t)
+}
+
+func TestGrantTypes_OnlyConfiguredGrants(t *testing.T) {
+	a := Auth{}
+	assert.Equal(t, []string{grantTypeAccessCode, grantTypeRefreshToken, grantTypeClientCredentials, grantTypeTokenExchange},
+		a.GrantTypes())
+
+	a = Auth{Assertions: new(FakeAssertionVerifier), Devices: new(FakeDeviceCodeRepo)}
+	assert.Equal(t, supportedGrantTypes, a.GrantTypes())
+}
+
+type FakeIDTokenMaker struct {
+	mock.Mock
+}
+
+func (m *FakeIDTokenMaker) Make(grant db.Grant, subject string) (string, error) {
+	args := m.Called(grant.Nonce, subject)
+	return args.String(0), args.Error(1)
+}
+
+func openIDCode(subjectType string) (*FakeCodeRepo, *FakeClientAuthenticator) {
+	cr := new(FakeCodeRepo)
+	cr.On("GetCode", "code").Return(&db.Code{
+		Grant: db.Grant{
+			OwnerID:  "card",
+			Scope:    "openid profile",
+			Identity: "alice@example.com",
+			AuthTime: time.Now().Unix(),
+			Nonce:    "nonce",
+		},
+		Expired: time.Now().Add(time.Minute),
+	}, nil)
+	cr.On("Use", mock.Anything).Return(true, nil)
+
+	c := authenticatedClient(db.Client{
+		ID:          "web",
+		GrantTypes:  []string{grantTypeAccessCode},
+		SubjectType: subjectType,
+	})
+	return cr, c
+}
+
+func TestAccessToken_OpenIDScope_ReturnIDToken(t *testing.T) {
+	table := map[string]string{
+		"":                       "card",
+		core.SubjectTypePublic:   "card",
+		core.SubjectTypePairwise: core.PairwiseSubject("secret", "web", "card"),
+	}
+	for subjectType, sub := range table {
+		resp := new(FakeResponse)
+		resp.On("Success", &core.Token{Token: "token", ExpiresIn: 60, Type: "bearer", IDToken: "id token"}).Once()
+
+		tr := new(FakeTokenRepo)
+		tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil)
+
+		it := new(FakeIDTokenMaker)
+		it.On("Make", "nonce", sub).Return("id token", nil).Once()
+
+		cr, c := openIDCode(subjectType)
+		a := Auth{Clients: c, CodeRepo: cr, TokenRepo: tr, IDTokens: it, PairwiseSecret: "secret"}
+		a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code"})
+
+		resp.AssertExpectations(t)
+		it.AssertExpectations(t)
+	}
+}
+
+func TestAccessToken_PairwiseWithoutSecret_ReturnInternalErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil)
+
+	l := new(FakeLogger)
+	l.On("Printf").Once()
+
+	cr, c := openIDCode(core.SubjectTypePairwise)
+	a := Auth{Logger: l, Clients: c, CodeRepo: cr, TokenRepo: tr, IDTokens: new(FakeIDTokenMaker)}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeAccessCode, Code: "code"})
+
+	resp.AssertExpectations(t)
+	l.AssertExpectations(t)
+}
+
+func TestAccessToken_ClientCredentialsOpenIDScope_IDTokenNotIssued(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Token{Token: "token", ExpiresIn: 60, Type: "bearer"}).Once()
+
+	c := authenticatedClient(db.Client{
+		ID:         "daemon",
+		SecretHash: "hash",
+		Scopes:     []string{core.ScopeOpenID},
+		GrantTypes: []string{grantTypeClientCredentials},
+	})
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil)
+
+	a := Auth{Clients: c, TokenRepo: tr, IDTokens: new(FakeIDTokenMaker)}
+	a.AccessToken(resp, core.AccessCode{GrantType: grantTypeClientCredentials, Scope: core.ScopeOpenID})
+
+	resp.AssertExpectations(t)
+}
+
+func TestClientGrant_ApplyClientRestrictions(t *testing.T) {
+	client := &db.Client{ID: "client", Scopes: []string{"profile", "mail"}, AccessTokenTTL: 60}
+
+	g, ok := clientGrant(db.Grant{OwnerID: "owner", Scope: "*", TokenTTL: 300, CodeChallenge: testCodeChallenge}, client)
+	assert.True(t, ok)
+	assert.Equal(t, db.Grant{OwnerID: "owner", Scope: "profile mail", ClientID: "client", TokenTTL: 60}, g)
+
+	_, ok = clientGrant(db.Grant{OwnerID: "owner", Scope: "admin"}, client)
+	assert.False(t, ok)
+}
+
 func TestRefresh_UnsupportedGrantType_ReturnErr(t *testing.T) {
 	resp := new(FakeResponse)
 	resp.On("Error", core.StatusErrorUnsupportedGrantType).Once()
 
 	a := Auth{}
-	a.Refresh(resp, "unsupported", "")
+	a.Refresh(resp, "unsupported", "", core.ClientCredentials{})
 
 	resp.AssertExpectations(t)
 }
@@ -228,8 +1039,9 @@ func TestRefresh_RefreshRepoReturnErr_ReturnErr(t *testing.T) {
 	rr := new(FakeRefreshRepo)
 	rr.On("Get", mock.Anything).Return(nil, fmt.Errorf("ERROR"))
 
-	a := Auth{RefreshRepo: rr, Logger: l}
-	a.Refresh(resp, grantTypeRefreshToken, "")
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, Logger: l}
+	a.Refresh(resp, grantTypeRefreshToken, "", core.ClientCredentials{})
 
 	resp.AssertExpectations(t)
 	l.AssertExpectations(t)
@@ -242,8 +1054,21 @@ func TestRefresh_RefreshRepoReturnResultNil_ReturnErr(t *testing.T) {
 	rr := new(FakeRefreshRepo)
 	rr.On("Get", mock.Anything).Return(nil, nil)
 
-	a := Auth{RefreshRepo: rr}
-	a.Refresh(resp, grantTypeRefreshToken, "")
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}}), RefreshRepo: rr}
+	a.Refresh(resp, grantTypeRefreshToken, "", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRefresh_RefreshTokenOfAnotherClient_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRefreshTokenNotFound).Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", mock.Anything).Return(&db.RefreshToken{Grant: db.Grant{OwnerID: "ownerId", ClientID: "another"}}, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}}), RefreshRepo: rr}
+	a.Refresh(resp, grantTypeRefreshToken, "", core.ClientCredentials{})
 
 	resp.AssertExpectations(t)
 }
@@ -256,13 +1081,14 @@ func TestRefresh_TokenRepoReturnErr_ReturnErr(t *testing.T) {
 	l.On("Printf").Once()
 
 	rr := new(FakeRefreshRepo)
-	rr.On("Get", mock.Anything).Return(&db.RefreshToken{OwnerID: "ownerId"}, nil)
+	rr.On("Get", mock.Anything).Return(&db.RefreshToken{Grant: db.Grant{OwnerID: "ownerId", ClientID: "client"}}, nil)
 
 	tr := new(FakeTokenRepo)
 	tr.On("Make", mock.Anything).Return(nil, fmt.Errorf("ERROR"))
 
-	a := Auth{RefreshRepo: rr, Logger: l, TokenRepo: tr}
-	a.Refresh(resp, grantTypeRefreshToken, "")
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, Logger: l, TokenRepo: tr}
+	a.Refresh(resp, grantTypeRefreshToken, "", core.ClientCredentials{})
 
 	resp.AssertExpectations(t)
 	l.AssertExpectations(t)
@@ -285,15 +1111,257 @@ func TestRefresh_ReturnVal(t *testing.T) {
 	l.On("Printf").Once()
 
 	rr := new(FakeRefreshRepo)
-	rr.On("Get", refreshToken).Return(&db.RefreshToken{OwnerID: ownerID}, nil)
+	rr.On("Get", refreshToken).Return(&db.RefreshToken{Grant: db.Grant{OwnerID: ownerID, ClientID: "client"}}, nil)
 
 	tr := new(FakeTokenRepo)
 	tr.On("Make", ownerID).Return(&db.AccessToken{Token: expected.Token, ExpiresIn: expected.ExpiresIn}, nil)
 
-	a := Auth{RefreshRepo: rr, Logger: l, TokenRepo: tr}
-	a.Refresh(resp, grantTypeRefreshToken, refreshToken)
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, Logger: l, TokenRepo: tr}
+	a.Refresh(resp, grantTypeRefreshToken, refreshToken, core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRefresh_ClientRestrictions_Applied(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.RefreshAccessToken{Token: "token", ExpiresIn: 60}).Once()
+
+	c := authenticatedClient(db.Client{
+		ID:             "client",
+		GrantTypes:     []string{grantTypeRefreshToken},
+		Scopes:         []string{"profile"},
+		AccessTokenTTL: 60,
+	})
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{
+		Token: "refresh token",
+		Grant: db.Grant{OwnerID: "card", ClientID: "client", Scope: core.ScopeWildcard, TokenTTL: 600},
+	}, nil)
+
+	var grant db.Grant
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 60}, nil)
+
+	a := Auth{Clients: c, RefreshRepo: rr, TokenRepo: &grantRecorder{FakeTokenRepo: tr, grant: &grant}}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+	assert.Equal(t, "profile", grant.Scope)
+	assert.Equal(t, 60, grant.TokenTTL)
+}
+
+func TestRefresh_ScopeNoLongerAllowed_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorScopeNotAllowed).Once()
+
+	c := authenticatedClient(db.Client{
+		ID:         "client",
+		GrantTypes: []string{grantTypeRefreshToken},
+		Scopes:     []string{"profile"},
+	})
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{
+		Token: "refresh token",
+		Grant: db.Grant{OwnerID: "card", ClientID: "client", Scope: "mail"},
+	}, nil)
+
+	tr := new(FakeTokenRepo)
+
+	a := Auth{Clients: c, RefreshRepo: rr, TokenRepo: tr}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+	tr.AssertNotCalled(t, "Make", mock.Anything)
+}
+
+func TestRefresh_TokenWithoutClient_BoundToClient(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.RefreshAccessToken{Token: "token", ExpiresIn: 600}).Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{Token: "refresh token", Grant: db.Grant{OwnerID: "card"}}, nil)
+	rr.On("Bind", "refresh token", "client").Return(true, nil).Once()
+
+	var grant db.Grant
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 600}, nil)
+
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, TokenRepo: &grantRecorder{FakeTokenRepo: tr, grant: &grant}}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+	rr.AssertExpectations(t)
+	assert.Equal(t, "client", grant.ClientID)
+}
+
+func TestRefresh_TokenWithoutClientBoundByAnother_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRefreshTokenNotFound).Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{Token: "refresh token", Grant: db.Grant{OwnerID: "card"}}, nil)
+	rr.On("Bind", "refresh token", "client").Return(false, nil).Once()
+
+	tr := new(FakeTokenRepo)
+
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, TokenRepo: tr}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+	tr.AssertNotCalled(t, "Make", mock.Anything)
+}
+
+func TestRefresh_BindReturnErr_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	l := new(FakeLogger)
+	l.On("Printf").Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{Token: "refresh token", Grant: db.Grant{OwnerID: "card"}}, nil)
+	rr.On("Bind", "refresh token", "client").Return(false, fmt.Errorf("ERROR"))
+
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, Logger: l}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+	l.AssertExpectations(t)
+}
+
+func TestRefresh_CardValid_CheckedOncePerInterval(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.RefreshAccessToken{Token: "token", ExpiresIn: 600}).Twice()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{Token: "refresh token", Grant: db.Grant{OwnerID: "card", ClientID: "client"}}, nil)
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 600}, nil)
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "card").Return(&virgil.Card{Id: "card"}, nil).Once()
+
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, TokenRepo: tr, Cards: c, CardRevalidation: time.Minute}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
 
 	resp.AssertExpectations(t)
+	c.AssertExpectations(t)
+}
+
+func TestRefresh_CardRevokedOrOutdated_RevokeRefreshToken(t *testing.T) {
+	table := map[string]func(c *FakeCardClient){
+		"revoked": func(c *FakeCardClient) {
+			c.On("GetCard", "card").Return(nil, cardErr(core.CardNotFound))
+		},
+		"outdated": func(c *FakeCardClient) {
+			c.On("GetCard", "card").Return(&virgil.Card{Id: "card", IsOutdated: true}, nil)
+		},
+		"signature missing": func(c *FakeCardClient) {
+			c.On("GetCard", "card").Return(nil, cardErr(core.CardSignatureMissing))
+		},
+		"signature invalid": func(c *FakeCardClient) {
+			c.On("GetCard", "card").Return(nil, cardErr(core.CardSignatureInvalid))
+		},
+	}
+	for name, setup := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorOwnerCardRevoked).Once()
+
+		rr := new(FakeRefreshRepo)
+		rr.On("Get", "refresh token").Return(&db.RefreshToken{Token: "refresh token", Grant: db.Grant{OwnerID: "card", ClientID: "client"}}, nil)
+		rr.On("Remove", "refresh token").Return(nil).Once()
+
+		c := new(FakeCardClient)
+		setup(c)
+
+		clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+		a := Auth{Clients: clients, RefreshRepo: rr, TokenRepo: new(FakeTokenRepo), Cards: c, CardRevalidation: time.Minute}
+		a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+		if !resp.AssertExpectations(t) || !rr.AssertExpectations(t) {
+			t.Log(name)
+		}
+	}
+}
+
+func TestRefresh_InlineCardNotOnCardsService_TokenKept(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.RefreshAccessToken{Token: "token", ExpiresIn: 600}).Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{
+		Token: "refresh token",
+		Grant: db.Grant{OwnerID: "card", ClientID: "client", InlineCard: true},
+	}, nil)
+
+	tr := new(FakeTokenRepo)
+	tr.On("Make", "card").Return(&db.AccessToken{Token: "token", ExpiresIn: 600}, nil)
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "card").Return(nil, cardErr(core.CardNotFound))
+
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, TokenRepo: tr, Cards: c, CardRevalidation: time.Minute}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+	rr.AssertNotCalled(t, "Remove", mock.Anything)
+}
+
+func TestRefresh_CardClientReturnErr_TokenKept(t *testing.T) {
+	table := []error{
+		fmt.Errorf("timeout"),
+		cardErr(core.CardForbidden),
+		cardErr(core.CardIDInvalid),
+	}
+	for _, v := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+		l := new(FakeLogger)
+		l.On("Printf").Once()
+
+		rr := new(FakeRefreshRepo)
+		rr.On("Get", "refresh token").Return(&db.RefreshToken{Token: "refresh token", Grant: db.Grant{OwnerID: "card", ClientID: "client"}}, nil)
+
+		c := new(FakeCardClient)
+		c.On("GetCard", "card").Return(nil, v)
+
+		clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+		a := Auth{Logger: l, Clients: clients, RefreshRepo: rr, Cards: c}
+		a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+		if !resp.AssertExpectations(t) || !rr.AssertNotCalled(t, "Remove", mock.Anything) {
+			t.Log(v)
+		}
+	}
+}
+
+func TestRefresh_CardsServiceUnavailable_TokenKept(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCardsServiceUnavailable).Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh token").Return(&db.RefreshToken{Token: "refresh token", Grant: db.Grant{OwnerID: "card", ClientID: "client"}}, nil)
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "card").Return(nil, cardErr(core.CardsUnavailable))
+
+	clients := authenticatedClient(db.Client{ID: "client", GrantTypes: []string{grantTypeRefreshToken}})
+	a := Auth{Clients: clients, RefreshRepo: rr, Cards: c}
+	a.Refresh(resp, grantTypeRefreshToken, "refresh token", core.ClientCredentials{})
+
+	resp.AssertExpectations(t)
+	rr.AssertNotCalled(t, "Remove", mock.Anything)
 }
 
 func TestVerify_TokenRepoReturnErr_ReturnInternalErr(t *testing.T) {
@@ -350,3 +1418,147 @@ func TestVerify_ReturnVal(t *testing.T) {
 
 	resp.AssertExpectations(t)
 }
+
+func TestIntrospect_AccessTokenOfAnyClient_ReturnActive(t *testing.T) {
+	expired := time.Now().Add(time.Minute)
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Introspection{
+		Active:    true,
+		Scope:     "cards",
+		ClientID:  "client",
+		Subject:   "card",
+		ExpiresAt: expired.Unix(),
+		TokenType: "bearer",
+	}).Once()
+
+	tr := new(FakeTokenRepo)
+	tr.On("Get", "access").Return(&db.AccessToken{OwnerID: "card", ClientID: "client", Scope: "cards", Expired: expired}, nil)
+
+	// the gateway isn't allowed to use the grant types of the token, it only checks the token
+	client := db.Client{
+		ID:                "gateway",
+		SecretHash:        "hash",
+		GrantTypes:        []string{grantTypeTokenExchange},
+		ExchangeAudiences: []string{"mail-service"},
+	}
+	a := Auth{Clients: authenticatedClient(client), TokenRepo: tr}
+	a.Introspect(resp, core.TokenRequest{Token: "access"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestIntrospect_RefreshToken_ActiveOnlyForItsClient(t *testing.T) {
+	table := map[string]struct {
+		token    *db.RefreshToken
+		expected *core.Introspection
+	}{
+		"own": {
+			&db.RefreshToken{Grant: db.Grant{OwnerID: "card", ClientID: "client", Scope: "cards"}, Expired: time.Unix(2000000000, 0)},
+			&core.Introspection{Active: true, Scope: "cards", ClientID: "client", Subject: "card", ExpiresAt: 2000000000},
+		},
+		"another client": {
+			&db.RefreshToken{Grant: db.Grant{OwnerID: "card", ClientID: "other"}, Expired: time.Unix(2000000000, 0)},
+			&core.Introspection{},
+		},
+		"not found": {nil, &core.Introspection{}},
+	}
+	for name, v := range table {
+		resp := new(FakeResponse)
+		resp.On("Success", v.expected).Once()
+
+		tr := new(FakeTokenRepo)
+		tr.On("Get", "refresh").Return(nil, fmt.Errorf("not a token"))
+		rr := new(FakeRefreshRepo)
+		rr.On("Get", "refresh").Return(v.token, nil)
+
+		a := Auth{Clients: authenticatedClient(db.Client{ID: "client"}), TokenRepo: tr, RefreshRepo: rr}
+		a.Introspect(resp, core.TokenRequest{Token: "refresh", TokenTypeHint: "refresh_token"})
+
+		if !resp.AssertExpectations(t) {
+			t.Log(name)
+		}
+	}
+}
+
+func TestIntrospect_ExpiredAccessToken_ReturnInactive(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.Introspection{}).Once()
+
+	tr := new(FakeTokenRepo)
+	tr.On("Get", "access").Return(&db.AccessToken{OwnerID: "card", Expired: time.Unix(0, 0)}, nil)
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "access").Return(nil, nil)
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client"}), TokenRepo: tr, RefreshRepo: rr}
+	a.Introspect(resp, core.TokenRequest{Token: "access"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestIntrospect_ClientNotAuthenticated_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorClientAuthenticationFailed).Once()
+
+	c := new(FakeClientAuthenticator)
+	c.On("Authenticate", mock.Anything).Return(nil, nil)
+
+	a := Auth{Clients: c}
+	a.Introspect(resp, core.TokenRequest{Token: "access"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRevoke_OwnRefreshToken_Removed(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", struct{}{}).Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh").Return(&db.RefreshToken{Token: "refresh", Grant: db.Grant{ClientID: "client"}}, nil)
+	rr.On("Remove", "refresh").Return(nil).Once()
+
+	a := Auth{Clients: authenticatedClient(db.Client{ID: "client"}), RefreshRepo: rr}
+	a.Revoke(resp, core.TokenRequest{Token: "refresh"})
+
+	resp.AssertExpectations(t)
+	rr.AssertExpectations(t)
+}
+
+func TestRevoke_UnknownOrForeignToken_LeftIntact(t *testing.T) {
+	table := map[string]*db.RefreshToken{
+		"another client": {Token: "refresh", Grant: db.Grant{ClientID: "other"}},
+		"not bound":      {Token: "refresh"},
+		"not found":      nil,
+	}
+	for name, token := range table {
+		resp := new(FakeResponse)
+		resp.On("Success", struct{}{}).Once()
+
+		rr := new(FakeRefreshRepo)
+		rr.On("Get", "refresh").Return(token, nil)
+
+		a := Auth{Clients: authenticatedClient(db.Client{ID: "client"}), RefreshRepo: rr}
+		a.Revoke(resp, core.TokenRequest{Token: "refresh"})
+
+		if !resp.AssertExpectations(t) {
+			t.Log(name)
+		}
+		rr.AssertNotCalled(t, "Remove", mock.Anything)
+	}
+}
+
+func TestRevoke_RefreshRepoReturnErr_ReturnInternalErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	l := new(FakeLogger)
+	l.On("Printf")
+
+	rr := new(FakeRefreshRepo)
+	rr.On("Get", "refresh").Return(&db.RefreshToken{Token: "refresh", Grant: db.Grant{ClientID: "client"}}, nil)
+	rr.On("Remove", "refresh").Return(fmt.Errorf("ERROR"))
+
+	a := Auth{Logger: l, Clients: authenticatedClient(db.Client{ID: "client"}), RefreshRepo: rr}
+	a.Revoke(resp, core.TokenRequest{Token: "refresh"})
+
+	resp.AssertExpectations(t)
+}
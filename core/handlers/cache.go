@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheSweepSize is the number of entries after which expired ones are removed on every write
+const cacheSweepSize = 1024
+
+// expiringCache keeps values until they expire. The zero value is ready to use.
+type expiringCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expired time.Time
+}
+
+// get returns the value of the key, it's nil if the value expired or wasn't put
+func (c *expiringCache) get(key string) interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expired) {
+		delete(c.entries, key)
+		return nil
+	}
+	return e.value
+}
+
+func (c *expiringCache) put(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.entries == nil {
+		c.entries = make(map[string]cacheEntry)
+	}
+	if len(c.entries) >= cacheSweepSize {
+		for k, e := range c.entries {
+			if now.After(e.expired) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expired: now.Add(ttl)}
+}
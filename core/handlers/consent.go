@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+// Consents lets owners see the clients they authorized and revoke them. The requests are authorized with an access
+// token of the owner that has the consents scope.
+type Consents struct {
+	TokenRepo   db.TokenRepo
+	Consents    db.ConsentRepo
+	RefreshRepo db.RefreshRepo
+	Logger      Logger
+}
+
+func (s *Consents) List(resp core.Response, token string) {
+	accessToken, ok := s.authorize(resp, token)
+	if !ok {
+		return
+	}
+	list, err := s.Consents.List(accessToken.OwnerID)
+	if err != nil {
+		s.Logger.Printf("Consents[List]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	result := make([]core.Consent, 0, len(list))
+	for _, c := range list {
+		result = append(result, core.Consent{
+			ClientID:  c.ClientID,
+			Scope:     c.Scope,
+			GrantedAt: c.GrantedAt.Unix(),
+		})
+	}
+	resp.Success(result)
+}
+
+// Revoke removes the consent and the refresh tokens of the client. Access tokens that were issued before stay valid
+// until they expire. The missing consent isn't an error, so the revocation can be repeated.
+func (s *Consents) Revoke(resp core.Response, token string, clientID string) {
+	accessToken, ok := s.authorize(resp, token)
+	if !ok {
+		return
+	}
+	if err := s.Consents.Remove(accessToken.OwnerID, clientID); err != nil {
+		s.Logger.Printf("Consents[Remove]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	if err := s.RefreshRepo.RemoveByClient(accessToken.OwnerID, clientID); err != nil {
+		s.Logger.Printf("Consents[Remove refresh tokens]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	resp.NoContent()
+}
+
+func (s *Consents) authorize(resp core.Response, token string) (*db.AccessToken, bool) {
+	accessToken, ok := ownerToken(resp, s.TokenRepo, token)
+	if !ok {
+		return nil, false
+	}
+	if !core.CoversScope(accessToken.Scope, core.ScopeConsents) {
+		resp.Error(core.StatusErrorScopeNotAllowed)
+		return nil, false
+	}
+	return accessToken, true
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+type FakeConsentRepo struct {
+	mock.Mock
+}
+
+func (r *FakeConsentRepo) Get(ownerID, clientID string) (c *db.Consent, err error) {
+	args := r.Called(ownerID, clientID)
+	c, _ = args.Get(0).(*db.Consent)
+	err = args.Error(1)
+	return
+}
+
+func (r *FakeConsentRepo) Grant(ownerID, clientID, scope string) error {
+	return r.Called(ownerID, clientID, scope).Error(0)
+}
+
+func (r *FakeConsentRepo) List(ownerID string) ([]db.Consent, error) {
+	args := r.Called(ownerID)
+	list, _ := args.Get(0).([]db.Consent)
+	return list, args.Error(1)
+}
+
+func (r *FakeConsentRepo) Remove(ownerID, clientID string) error {
+	return r.Called(ownerID, clientID).Error(0)
+}
+
+func consentToken(scope string) *FakeTokenRepo {
+	tr := new(FakeTokenRepo)
+	tr.On("Get", "token").Return(&db.AccessToken{OwnerID: "card", ClientID: "web", Scope: scope, Expired: time.Now().Add(time.Minute)}, nil)
+	return tr
+}
+
+func TestConsentsList_ReturnOwnerConsents(t *testing.T) {
+	granted := time.Unix(1500000000, 0)
+
+	resp := new(FakeResponse)
+	resp.On("Success", []core.Consent{{ClientID: "web", Scope: "profile", GrantedAt: granted.Unix()}}).Once()
+
+	cr := new(FakeConsentRepo)
+	cr.On("List", "card").Return([]db.Consent{{OwnerID: "card", ClientID: "web", Scope: "profile", GrantedAt: granted}}, nil)
+
+	s := Consents{TokenRepo: consentToken("profile consents"), Consents: cr}
+	s.List(resp, "token")
+
+	resp.AssertExpectations(t)
+}
+
+func TestConsentsList_TokenWithoutScope_ReturnScopeNotAllowed(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorScopeNotAllowed).Once()
+
+	s := Consents{TokenRepo: consentToken("profile"), Consents: new(FakeConsentRepo)}
+	s.List(resp, "token")
+
+	resp.AssertExpectations(t)
+}
+
+func TestConsentsRevoke_RemoveConsentAndRefreshTokens(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("NoContent").Once()
+
+	cr := new(FakeConsentRepo)
+	cr.On("Remove", "card", "tv").Return(nil).Once()
+
+	rr := new(FakeRefreshRepo)
+	rr.On("RemoveByClient", "card", "tv").Return(nil).Once()
+
+	s := Consents{TokenRepo: consentToken(core.ScopeWildcard), Consents: cr, RefreshRepo: rr}
+	s.Revoke(resp, "token", "tv")
+
+	resp.AssertExpectations(t)
+	cr.AssertExpectations(t)
+	rr.AssertExpectations(t)
+}
+
+func consentClients() *FakeClientStore {
+	s := new(FakeClientStore)
+	s.On("Get", "web").Return(&db.Client{ID: "web", RequireConsent: true}, nil)
+	return s
+}
+
+func TestHandshake_ConsentMissing_ReturnConsentRequired(t *testing.T) {
+	table := map[string]*db.Consent{
+		"no consent":    nil,
+		"smaller scope": {OwnerID: "id", ClientID: "web", Scope: "profile"},
+	}
+	for name, consent := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorConsentRequired).Once()
+
+		c := new(FakeCardClient)
+		c.On("GetCard", "id").Return(&virgil.Card{Id: "id"}, nil)
+
+		cr := new(FakeConsentRepo)
+		cr.On("Get", "id", "web").Return(consent, nil)
+
+		s := Grant{Client: c, Clients: consentClients(), Consents: cr}
+		s.Handshake(resp, core.OwnerCard{ID: "id", ClientID: "web", Scope: "profile mail"})
+
+		if !resp.AssertExpectations(t) {
+			t.Log(name)
+		}
+	}
+}
+
+func TestHandshake_ConsentGivenOrRecorded_AttemptMade(t *testing.T) {
+	table := map[bool]*db.Consent{
+		true:  nil,
+		false: {OwnerID: "id", ClientID: "web", Scope: "mail profile"},
+	}
+	for agreed, consent := range table {
+		resp := new(FakeResponse)
+		resp.On("Success", mock.Anything).Once()
+
+		c := new(FakeCardClient)
+		c.On("GetCard", "id").Return(&virgil.Card{Id: "id"}, nil)
+
+		cr := new(FakeConsentRepo)
+		cr.On("Get", "id", "web").Return(consent, nil)
+
+		a := new(FakeAttemptRepo)
+		a.On("Make", "id", "profile mail").Return(&db.Attempt{ID: "attempt", Message: "msg"}, nil).Once()
+
+		ch := new(FakeCipher)
+		ch.On("Encrypt", mock.Anything, mock.Anything).Return([]byte("encrypted"), nil)
+
+		s := Grant{Client: c, Clients: consentClients(), Consents: cr, AttemptRepo: a, Cipher: ch}
+		s.Handshake(resp, core.OwnerCard{ID: "id", ClientID: "web", Scope: "profile mail", Consent: agreed})
+
+		resp.AssertExpectations(t)
+		a.AssertExpectations(t)
+	}
+}
+
+func TestAcknowledge_ConsentGiven_ConsentRecorded(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.AuthorizationCode{Code: "code"}).Once()
+
+	a := new(FakeAttemptRepo)
+	a.On("Get", "attempt").Return(&db.Attempt{Expired: time.Now().Add(time.Minute), Grant: db.Grant{OwnerID: "id", ClientID: "web", Scope: "profile", Consent: true}, Message: "msg"}, nil)
+	a.On("Remove", "attempt").Return(nil)
+
+	mc := new(FakeMakeCode)
+	mc.On("Make", "id", "profile").Return(&db.Code{Code: "code"}, nil)
+
+	ch := new(FakeCipher)
+	ch.On("Validate", []byte("encrypted"), []byte("msg")).Return(true)
+
+	cr := new(FakeConsentRepo)
+	cr.On("Grant", "id", "web", "profile").Return(nil).Once()
+
+	s := Grant{AttemptRepo: a, MakeCode: mc, Cipher: ch, Consents: cr}
+	s.Acknowledge(resp, core.EncryptedMessage{AttemptId: "attempt", Message: []byte("encrypted")})
+
+	resp.AssertExpectations(t)
+	cr.AssertExpectations(t)
+}
+
+func TestAcknowledge_ConsentNotGiven_ConsentNotRecorded(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.AuthorizationCode{Code: "code"}).Once()
+
+	a := new(FakeAttemptRepo)
+	a.On("Get", "attempt").Return(&db.Attempt{Expired: time.Now().Add(time.Minute), Grant: db.Grant{OwnerID: "id", ClientID: "web", Scope: "profile"}, Message: "msg"}, nil)
+	a.On("Remove", "attempt").Return(nil)
+
+	mc := new(FakeMakeCode)
+	mc.On("Make", "id", "profile").Return(&db.Code{Code: "code"}, nil)
+
+	ch := new(FakeCipher)
+	ch.On("Validate", []byte("encrypted"), []byte("msg")).Return(true)
+
+	cr := new(FakeConsentRepo)
+
+	s := Grant{AttemptRepo: a, MakeCode: mc, Cipher: ch, Consents: cr}
+	s.Acknowledge(resp, core.EncryptedMessage{AttemptId: "attempt", Message: []byte("encrypted")})
+
+	resp.AssertExpectations(t)
+	cr.AssertNotCalled(t, "Grant", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestAcknowledge_MakeCodeReturnErr_ConsentNotRecorded(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	a := new(FakeAttemptRepo)
+	a.On("Get", "attempt").Return(&db.Attempt{Expired: time.Now().Add(time.Minute), Grant: db.Grant{OwnerID: "id", ClientID: "web", Scope: "profile", Consent: true}, Message: "msg"}, nil)
+
+	mc := new(FakeMakeCode)
+	mc.On("Make", "id", "profile").Return(nil, fmt.Errorf("ERROR"))
+
+	ch := new(FakeCipher)
+	ch.On("Validate", []byte("encrypted"), []byte("msg")).Return(true)
+
+	l := new(FakeLogger)
+	l.On("Printf").Once()
+
+	cr := new(FakeConsentRepo)
+
+	s := Grant{AttemptRepo: a, MakeCode: mc, Cipher: ch, Consents: cr, Logger: l}
+	s.Acknowledge(resp, core.EncryptedMessage{AttemptId: "attempt", Message: []byte("encrypted")})
+
+	resp.AssertExpectations(t)
+	cr.AssertNotCalled(t, "Grant", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -1,12 +1,10 @@
 package handlers
 
 import (
-	"net/http"
-	"strings"
+	"net/url"
 	"time"
 
 	"gopkg.in/virgil.v5/cryptoapi"
-	verrors "gopkg.in/virgil.v5/errors"
 	virgil "gopkg.in/virgil.v5/sdk"
 
 	"github.com/VirgilSecurity/virgil-services-auth/core"
@@ -15,6 +13,11 @@ import (
 
 type CardClient interface {
 	GetCard(id string) (card *virgil.Card, err error)
+	SearchCards(identity string) (cards []*virgil.Card, err error)
+}
+
+type CardParser interface {
+	ParseCard(raw string) (*virgil.Card, error)
 }
 
 type Logger interface {
@@ -22,8 +25,14 @@ type Logger interface {
 }
 
 type Cipher interface {
-	Encrypt(data []byte, recipient cryptoapi.PublicKey) ([]byte, error)
-	Validate(CipherData, plainData []byte) bool
+	Encrypt(data []byte, recipients ...cryptoapi.PublicKey) ([]byte, error)
+	Validate(CipherData, plainData []byte, clientID string) bool
+	ValidateSigned(CipherData, plainData []byte, signer cryptoapi.PublicKey, clientID string) bool
+	VerifySignature(data, signature []byte, signer cryptoapi.PublicKey) bool
+}
+
+type Policy interface {
+	Evaluate(req core.PolicyRequest) core.PolicyDecision
 }
 
 type Grant struct {
@@ -32,45 +41,112 @@ type Grant struct {
 	AttemptRepo db.AttemptRepo
 	MakeCode    db.CodeMaker
 	Cipher      Cipher
+	Policy      Policy            // optional, all scopes are allowed to any verified card without it
+	Clients     db.ClientRepo     // client registry to check redirect URIs and default challenge methods
+	ServiceID   string            // identifier of the service in signature challenges
+	Devices     db.DeviceCodeRepo // optional, handshakes with user_code are rejected without it
+	Consents    db.ConsentRepo    // optional, consents are neither recorded nor required without it
+	InlineCards CardParser        // optional, the raw cards sent with handshakes are ignored without it
+}
+
+// Authorize validates the request of the browser flow before the page that runs the handshake is shown
+func (s *Grant) Authorize(resp core.Response, req core.AuthorizationRequest) {
+	if req.ResponseType != "code" {
+		resp.Error(core.StatusErrorUnsupportedGrantType)
+		return
+	}
+	if req.RedirectURI == "" {
+		resp.Error(core.StatusErrorRedirectURIInvalid)
+		return
+	}
+	if !s.checkRedirectURI(resp, req.ClientID, req.RedirectURI) {
+		return
+	}
+	if (req.CodeChallenge != "" || req.CodeChallengeMethod != "") &&
+		!core.ValidCodeChallenge(req.CodeChallenge, req.CodeChallengeMethod) {
+		resp.Error(core.StatusErrorCodeChallengeInvalid)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = core.ScopeWildcard
+	}
+	resp.Success(&req)
 }
 
 func (s *Grant) Handshake(resp core.Response, ownerCard core.OwnerCard) {
-	card, err := s.Client.GetCard(ownerCard.ID)
-	if err != nil {
-		if verr, ok := verrors.ToSdkError(err); ok && verr.IsHTTPError() {
-			if verr.IsHTTPError() {
-				// Card not found
-				if verr.HTTPErrorCode() == http.StatusNotFound {
-					resp.Error(core.StatusErrorCardNotFound)
-					return
-				}
-				// Card found but permission denied
-				if verr.HTTPErrorCode() == http.StatusUnauthorized || verr.HTTPErrorCode() == http.StatusForbidden {
-					resp.Error(core.StatusErrorCardProtected)
-					return
-				}
-			}
-			// if verr.Message == virgil.CardValidationExpectedSignerWasNotFoundErr
-		}
-		if strings.Contains(err.Error(), "invalid card id") {
-			resp.Error(core.StatusErrorCardInvalid)
+	if ownerCard.UserCode != "" {
+		if !s.deviceHandshake(resp, &ownerCard) {
 			return
 		}
-		if strings.Contains(err.Error(), "does not have signature for verifier ID") || strings.Contains(err.Error(), "signature validation failed") {
-			resp.Error(core.StatusErrorCardInvalid)
+	}
+	if (ownerCard.CodeChallenge != "" || ownerCard.CodeChallengeMethod != "") &&
+		!core.ValidCodeChallenge(ownerCard.CodeChallenge, ownerCard.CodeChallengeMethod) {
+		resp.Error(core.StatusErrorCodeChallengeInvalid)
+		return
+	}
+	if ownerCard.RedirectURI != "" && !s.checkRedirectURI(resp, ownerCard.ClientID, ownerCard.RedirectURI) {
+		return
+	}
+	method, ok := s.challengeMethod(resp, ownerCard)
+	if !ok {
+		return
+	}
+	cards, ok := s.ownerCards(resp, &ownerCard)
+	if !ok {
+		return
+	}
+	card := cards[0]
+	grant := db.Grant{
+		OwnerID:             ownerCard.ID,
+		Scope:               ownerCard.Scope,
+		ClientID:            ownerCard.ClientID,
+		CodeChallenge:       ownerCard.CodeChallenge,
+		CodeChallengeMethod: ownerCard.CodeChallengeMethod,
+		RedirectURI:         ownerCard.RedirectURI,
+		State:               ownerCard.State,
+		ChallengeMethod:     method,
+		UserCode:            ownerCard.UserCode,
+		Consent:             ownerCard.Consent && ownerCard.ClientID != "",
+		RawCard:             ownerCard.RawCard,
+		Identity:            card.Identity,
+		IdentityType:        cardIdentityType(card),
+		Nonce:               ownerCard.Nonce,
+		ACR:                 core.ACRCardEncryption,
+	}
+	if method == core.ChallengeMethodSignature {
+		grant.ACR = core.ACRCardSignature
+	}
+	if len(cards) > 1 {
+		// the owner is known at the acknowledge, so the policy is applied there
+		grant.IdentityType = ""
+		grant.Candidates = cardIDs(cards)
+	} else {
+		if grant.OwnerID == "" {
+			grant.OwnerID = card.Id
+		}
+		if !applyPolicy(s.Policy, s.Logger, card, ownerCard.ClientID, &grant) {
+			resp.Error(core.StatusErrorScopeNotAllowed)
 			return
 		}
-		s.Logger.Printf("Handshake[GetCard]: %+v", err)
-		resp.Error(core.StatusErrorInternalApplicationError)
+	}
+	if !s.checkConsent(resp, grant, ownerCard.Consent) {
 		return
 	}
-	a, err := s.AttemptRepo.Make(ownerCard.ID, ownerCard.Scope)
+	a, err := s.AttemptRepo.Make(grant)
 	if err != nil {
 		s.Logger.Printf("Handshake[make attempt]: %+v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
 		return
 	}
-	m, err := s.Cipher.Encrypt([]byte(a.Message), card.PublicKey)
+	if method == core.ChallengeMethodSignature {
+		resp.Success(&core.SignatureChallenge{
+			AttemptId: a.ID,
+			Challenge: a.Message,
+			ServiceID: s.ServiceID,
+		})
+		return
+	}
+	m, err := s.Cipher.Encrypt([]byte(a.Message), publicKeys(cards)...)
 	if err != nil {
 		s.Logger.Printf("Handshake[encrypt msg]: %+v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
@@ -97,22 +173,453 @@ func (s *Grant) Acknowledge(resp core.Response, msg core.EncryptedMessage) {
 		resp.Error(core.StatusErrorAttemptNotFound)
 		return
 	}
-	ok := s.Cipher.Validate([]byte(msg.Message), []byte(a.Message))
-	if !ok {
-		resp.Error(core.StatusErrorEncryptedMessageValidationFailed)
+	if !s.verifyAnswer(resp, a, msg) {
 		return
 	}
-	code, err := s.MakeCode.Make(a.OwnerID, a.Scope)
+	a.AuthTime = time.Now().Unix()
+	a.InlineCard, a.RawCard = a.RawCard != "", ""
+	consent := a.Consent
+	a.Consent = false
+	if a.UserCode != "" {
+		s.approveDevice(resp, msg.AttemptId, a, consent)
+		return
+	}
+	code, err := s.MakeCode.Make(a.Grant)
 	if err != nil {
 		s.Logger.Printf("Acknowledge[Make code]: %v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
 		return
 	}
+	if consent && !s.recordConsent(resp, a.Grant) {
+		return
+	}
 	err = s.AttemptRepo.Remove(msg.AttemptId)
 	if err != nil {
 		s.Logger.Printf("Acknowledge[Remove attempt]: %v", err)
 		resp.Error(core.StatusErrorInternalApplicationError)
 		return
 	}
-	resp.Success(map[string]string{"code": code.Code})
+	result := &core.AuthorizationCode{Code: code.Code}
+	if a.RedirectURI != "" {
+		result.RedirectURI = redirectWithCode(a.RedirectURI, code.Code, a.State)
+	}
+	resp.Success(result)
+}
+
+// ownerCards returns the card of the handshake or all active cards of the identity
+func (s *Grant) ownerCards(resp core.Response, ownerCard *core.OwnerCard) ([]*virgil.Card, bool) {
+	if ownerCard.ID != "" {
+		card, ok := s.inlineCard(resp, *ownerCard)
+		if !ok {
+			return nil, false
+		}
+		if card != nil {
+			return []*virgil.Card{card}, true
+		}
+		// the raw card isn't kept for the acknowledge if the card is got from the Cards service
+		ownerCard.RawCard = ""
+
+		card, err := s.Client.GetCard(ownerCard.ID)
+		if err != nil {
+			if status, ok := cardErrorStatus(err); ok {
+				resp.Error(status)
+				return nil, false
+			}
+			cardServiceError(resp, s.Logger, "Handshake[GetCard]", err)
+			return nil, false
+		}
+		return []*virgil.Card{card}, true
+	}
+	found, err := s.Client.SearchCards(ownerCard.Identity)
+	if err != nil {
+		if status, ok := cardErrorStatus(err); ok {
+			resp.Error(status)
+			return nil, false
+		}
+		cardServiceError(resp, s.Logger, "Handshake[SearchCards]", err)
+		return nil, false
+	}
+	cards := make([]*virgil.Card, 0, len(found))
+	for _, c := range found {
+		if !c.IsOutdated {
+			cards = append(cards, c)
+		}
+	}
+	if len(cards) == 0 {
+		resp.Error(core.StatusErrorCardNotFound)
+		return nil, false
+	}
+	return cards, true
+}
+
+// inlineCard verifies the card sent with the handshake. It returns nil if the card must be got from the Cards service:
+// no card was sent, the inline cards are disabled or no enforced policy rule allows them for the card.
+func (s *Grant) inlineCard(resp core.Response, ownerCard core.OwnerCard) (*virgil.Card, bool) {
+	if ownerCard.RawCard == "" || s.InlineCards == nil || s.Policy == nil {
+		return nil, true
+	}
+	card, err := s.InlineCards.ParseCard(ownerCard.RawCard)
+	if err != nil {
+		if status, ok := cardErrorStatus(err); ok {
+			resp.Error(status)
+			return nil, false
+		}
+		s.Logger.Printf("Handshake[ParseCard]: %+v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return nil, false
+	}
+	if card.Id != ownerCard.ID {
+		resp.Error(core.StatusErrorCardInvalid)
+		return nil, false
+	}
+	d := s.Policy.Evaluate(core.PolicyRequest{
+		CardID:   card.Id,
+		Identity: card.Identity,
+		Signers:  cardSigners(card),
+		ClientID: ownerCard.ClientID,
+		Scope:    ownerCard.Scope,
+	})
+	// the rules of the shadow mode aren't enforced, so they don't relax the card lookup either
+	if !d.Allowed || d.Shadow || !d.InlineCards {
+		return nil, true
+	}
+	return card, true
+}
+
+// attemptOwnerCard returns the card of the attempt owner. The card sent with the handshake is used if it was accepted
+// there.
+func (s *Grant) attemptOwnerCard(a *db.Attempt) (*virgil.Card, error) {
+	if a.RawCard != "" && s.InlineCards != nil {
+		return s.InlineCards.ParseCard(a.RawCard)
+	}
+	return s.Client.GetCard(a.OwnerID)
+}
+
+// verifyAnswer checks the proof of possession of the card key. The handshake by identity is answered by one of the
+// cards, the proof tells which one: the signature challenge is signed by the card key, the message of the encryption
+// challenge is signed by the card key and then encrypted for the service. The answering card becomes the owner of the
+// grant.
+func (s *Grant) verifyAnswer(resp core.Response, a *db.Attempt, msg core.EncryptedMessage) bool {
+	if len(a.Candidates) != 0 {
+		if a.ChallengeMethod == core.ChallengeMethodSignature {
+			data := core.SignatureChallengeData(a.ID, s.ServiceID, a.Message)
+			return s.answeringCard(resp, a, len(msg.Signature) != 0, func(card *virgil.Card) bool {
+				return s.Cipher.VerifySignature(data, msg.Signature, card.PublicKey)
+			})
+		}
+		return s.answeringCard(resp, a, len(msg.Message) != 0, func(card *virgil.Card) bool {
+			return s.Cipher.ValidateSigned([]byte(msg.Message), []byte(a.Message), card.PublicKey, a.ClientID)
+		})
+	}
+	if a.ChallengeMethod == core.ChallengeMethodSignature {
+		return s.verifySignature(resp, a, msg.Signature)
+	}
+	if !s.Cipher.Validate([]byte(msg.Message), []byte(a.Message), a.ClientID) {
+		resp.Error(core.StatusErrorEncryptedMessageValidationFailed)
+		return false
+	}
+	return true
+}
+
+// answeringCard finds the candidate card that made the proof and makes it the owner of the grant
+func (s *Grant) answeringCard(resp core.Response, a *db.Attempt, answered bool, proves func(card *virgil.Card) bool) bool {
+	if !answered {
+		resp.Error(core.StatusErrorEncryptedMessageValidationFailed)
+		return false
+	}
+	for _, id := range a.Candidates {
+		card, err := s.Client.GetCard(id)
+		if err != nil {
+			// the card was revoked after the handshake
+			if _, ok := cardErrorStatus(err); ok {
+				continue
+			}
+			cardServiceError(resp, s.Logger, "Acknowledge[GetCard]", err)
+			return false
+		}
+		if !proves(card) {
+			continue
+		}
+		a.OwnerID, a.IdentityType, a.Candidates = card.Id, cardIdentityType(card), nil
+		if !applyPolicy(s.Policy, s.Logger, card, a.ClientID, &a.Grant) {
+			resp.Error(core.StatusErrorScopeNotAllowed)
+			return false
+		}
+		return true
+	}
+	resp.Error(core.StatusErrorEncryptedMessageValidationFailed)
+	return false
+}
+
+// deviceHandshake replaces the client and the scope of the handshake with ones of the device authorization
+func (s *Grant) deviceHandshake(resp core.Response, ownerCard *core.OwnerCard) bool {
+	if s.Devices == nil {
+		resp.Error(core.StatusErrorUserCodeInvalid)
+		return false
+	}
+	userCode := core.NormalizeUserCode(ownerCard.UserCode)
+	d, err := s.Devices.GetByUserCode(userCode)
+	if err != nil {
+		s.Logger.Printf("Handshake[Get device code]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return false
+	}
+	if d == nil || d.Approved || time.Now().After(d.Expired) {
+		resp.Error(core.StatusErrorUserCodeInvalid)
+		return false
+	}
+	ownerCard.UserCode = userCode
+	ownerCard.ClientID = d.ClientID
+	ownerCard.Scope = d.Scope
+	// the device polls for tokens, nothing is sent to the front channel
+	ownerCard.RedirectURI, ownerCard.State = "", ""
+	ownerCard.CodeChallenge, ownerCard.CodeChallengeMethod = "", ""
+	return true
+}
+
+// approveDevice passes the grant of the acknowledged handshake to the device authorization
+func (s *Grant) approveDevice(resp core.Response, attemptID string, a *db.Attempt, consent bool) {
+	ok, err := s.Devices.Approve(a.UserCode, a.Grant)
+	if err != nil {
+		s.Logger.Printf("Acknowledge[Approve device code]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	if !ok {
+		resp.Error(core.StatusErrorUserCodeInvalid)
+		return
+	}
+	if consent && !s.recordConsent(resp, a.Grant) {
+		return
+	}
+	if err = s.AttemptRepo.Remove(attemptID); err != nil {
+		s.Logger.Printf("Acknowledge[Remove attempt]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	resp.NoContent()
+}
+
+// recordConsent records the consent that the owner gave with the handshake. It's called once the code or the device
+// approval is stored, so a failed flow leaves no consent behind.
+func (s *Grant) recordConsent(resp core.Response, grant db.Grant) bool {
+	if s.Consents == nil {
+		return true
+	}
+	if err := s.Consents.Grant(grant.OwnerID, grant.ClientID, grant.Scope); err != nil {
+		s.Logger.Printf("Acknowledge[Grant consent]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return false
+	}
+	return true
+}
+
+// checkConsent requires the owner's consent to the scope if the client is registered with require_consent. The consent
+// is either recorded before or given with the handshake and recorded at the acknowledge. The consent of any card of the
+// handshake by identity is enough.
+func (s *Grant) checkConsent(resp core.Response, grant db.Grant, agreed bool) bool {
+	if agreed || grant.ClientID == "" || s.Consents == nil || s.Clients == nil {
+		return true
+	}
+	c, err := s.Clients.Get(grant.ClientID)
+	if err != nil {
+		s.Logger.Printf("Get client %v: %v", grant.ClientID, err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return false
+	}
+	if c == nil || !c.RequireConsent {
+		return true
+	}
+	owners := grant.Candidates
+	if len(owners) == 0 {
+		owners = []string{grant.OwnerID}
+	}
+	for _, owner := range owners {
+		consent, err := s.Consents.Get(owner, grant.ClientID)
+		if err != nil {
+			s.Logger.Printf("Handshake[Get consent]: %v", err)
+			resp.Error(core.StatusErrorInternalApplicationError)
+			return false
+		}
+		if consent != nil && core.CoversScope(consent.Scope, grant.Scope) {
+			return true
+		}
+	}
+	resp.Error(core.StatusErrorConsentRequired)
+	return false
+}
+
+// challengeMethod returns the challenge method of the request or the default one of the client
+func (s *Grant) challengeMethod(resp core.Response, ownerCard core.OwnerCard) (string, bool) {
+	method := ownerCard.ChallengeMethod
+	if method == "" && ownerCard.ClientID != "" && s.Clients != nil {
+		c, err := s.Clients.Get(ownerCard.ClientID)
+		if err != nil {
+			s.Logger.Printf("Get client %v: %v", ownerCard.ClientID, err)
+			resp.Error(core.StatusErrorInternalApplicationError)
+			return "", false
+		}
+		if c != nil {
+			method = c.ChallengeMethod
+		}
+	}
+	if !core.ValidChallengeMethod(method) {
+		resp.Error(core.StatusErrorChallengeMethodUnsupported)
+		return "", false
+	}
+	if method == core.ChallengeMethodEncryption {
+		method = ""
+	}
+	return method, true
+}
+
+// verifySignature checks the signature of the challenge with the key of the owner's card
+func (s *Grant) verifySignature(resp core.Response, a *db.Attempt, signature []byte) bool {
+	if len(signature) == 0 {
+		resp.Error(core.StatusErrorEncryptedMessageValidationFailed)
+		return false
+	}
+	card, err := s.attemptOwnerCard(a)
+	if err != nil {
+		if status, ok := cardErrorStatus(err); ok {
+			resp.Error(status)
+			return false
+		}
+		cardServiceError(resp, s.Logger, "Acknowledge[GetCard]", err)
+		return false
+	}
+	if !s.Cipher.VerifySignature(core.SignatureChallengeData(a.ID, s.ServiceID, a.Message), signature, card.PublicKey) {
+		resp.Error(core.StatusErrorEncryptedMessageValidationFailed)
+		return false
+	}
+	return true
+}
+
+// checkRedirectURI checks that the redirect URI is registered for the client
+func (s *Grant) checkRedirectURI(resp core.Response, clientID, redirectURI string) bool {
+	if clientID == "" || s.Clients == nil {
+		resp.Error(core.StatusErrorRedirectURIInvalid)
+		return false
+	}
+	c, err := s.Clients.Get(clientID)
+	if err != nil {
+		s.Logger.Printf("Get client %v: %v", clientID, err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return false
+	}
+	if c == nil || !c.AllowsRedirectURI(redirectURI) {
+		resp.Error(core.StatusErrorRedirectURIInvalid)
+		return false
+	}
+	return true
+}
+
+// redirectWithCode adds the code and the state to the query of the redirect URI (RFC 6749 section 4.1.2)
+func redirectWithCode(redirectURI, code, state string) string {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		// registered URIs are validated at the registration
+		return redirectURI
+	}
+	q := u.Query()
+	q.Set("code", code)
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// cardErrorStatus maps errors of the Cards service about the requested card to the response status
+func cardErrorStatus(err error) (core.ResponseStatus, bool) {
+	switch core.CardErrorKindOf(err) {
+	case core.CardNotFound:
+		return core.StatusErrorCardNotFound, true
+	case core.CardForbidden:
+		return core.StatusErrorCardProtected, true
+	case core.CardIDInvalid, core.CardSignatureMissing, core.CardSignatureInvalid, core.CardMalformed:
+		return core.StatusErrorCardInvalid, true
+	}
+	return 0, false
+}
+
+// cardServiceError responds to the error of the Cards service that isn't about the requested card
+func cardServiceError(resp core.Response, logger Logger, op string, err error) {
+	if core.CardErrorKindOf(err) == core.CardsUnavailable {
+		resp.Error(core.StatusErrorCardsServiceUnavailable)
+		return
+	}
+	logger.Printf("%v: %+v", op, err)
+	resp.Error(core.StatusErrorInternalApplicationError)
+}
+
+// applyPolicy narrows the grant by the policy. It returns false if the policy denies the grant.
+func applyPolicy(policy Policy, logger Logger, card *virgil.Card, clientID string, grant *db.Grant) bool {
+	if policy == nil {
+		return true
+	}
+	d := policy.Evaluate(core.PolicyRequest{
+		CardID:   grant.OwnerID,
+		Identity: card.Identity,
+		Signers:  cardSigners(card),
+		ClientID: clientID,
+		Scope:    grant.Scope,
+	})
+	if d.Shadow {
+		if d.Allowed {
+			logger.Printf("Policy: shadow mode, card %v (identity %q, client %q) requested scope %q, rule %v would grant scope %q",
+				grant.OwnerID, card.Identity, clientID, grant.Scope, d.Rule, d.Scope)
+		} else {
+			logger.Printf("Policy: shadow mode, card %v (identity %q, client %q) would be denied scope %q",
+				grant.OwnerID, card.Identity, clientID, grant.Scope)
+		}
+		// the grant is left as requested, only the authority restrictions are applied
+		if d.Authority != "" {
+			grant.Scope, grant.Authority = d.AuthorityScope, d.Authority
+			if d.AuthorityTokenTTL > 0 && (grant.TokenTTL == 0 || d.AuthorityTokenTTL < grant.TokenTTL) {
+				grant.TokenTTL = d.AuthorityTokenTTL
+			}
+		}
+		return true
+	}
+	if !d.Allowed {
+		return false
+	}
+	grant.Scope, grant.TokenTTL, grant.Authority = d.Scope, d.TokenTTL, d.Authority
+	return true
+}
+
+// cardIdentityType returns the identity type of the card. Cards keep it in the extra fields of the self signature,
+// it's empty if the card doesn't have it.
+func cardIdentityType(card *virgil.Card) string {
+	for _, sign := range card.Signatures {
+		if sign.Signer == "self" {
+			return sign.ExtraFields["identity_type"]
+		}
+	}
+	return ""
+}
+
+func cardIDs(cards []*virgil.Card) []string {
+	ids := make([]string, 0, len(cards))
+	for _, c := range cards {
+		ids = append(ids, c.Id)
+	}
+	return ids
+}
+
+func publicKeys(cards []*virgil.Card) []cryptoapi.PublicKey {
+	keys := make([]cryptoapi.PublicKey, 0, len(cards))
+	for _, c := range cards {
+		keys = append(keys, c.PublicKey)
+	}
+	return keys
+}
+
+func cardSigners(card *virgil.Card) []string {
+	signers := make([]string, 0, len(card.Signatures))
+	for _, sign := range card.Signatures {
+		signers = append(signers, sign.Signer)
+	}
+	return signers
 }
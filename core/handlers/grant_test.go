@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"fmt"
-	"net/http"
 	"testing"
 	"time"
 
@@ -10,11 +9,11 @@ import (
 
 	"gopkg.in/virgil.v5/cryptoapi"
 	"gopkg.in/virgil.v5/cryptoimpl"
-	"gopkg.in/virgil.v5/errors"
 	virgil "gopkg.in/virgil.v5/sdk"
 
 	"github.com/VirgilSecurity/virgil-services-auth/core"
 	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -30,8 +29,8 @@ type FakeAttemptRepo struct {
 	mock.Mock
 }
 
-func (s *FakeAttemptRepo) Make(ownerID string, scope string) (a *db.Attempt, err error) {
-	args := s.Called(ownerID, scope)
+func (s *FakeAttemptRepo) Make(grant db.Grant) (a *db.Attempt, err error) {
+	args := s.Called(grant.OwnerID, grant.Scope)
 	a, _ = args.Get(0).(*db.Attempt)
 	err = args.Error(1)
 	return
@@ -60,12 +59,19 @@ func (c *FakeCardClient) GetCard(id string) (card *virgil.Card, err error) {
 	return
 }
 
+func (c *FakeCardClient) SearchCards(identity string) (cards []*virgil.Card, err error) {
+	args := c.Called(identity)
+	cards, _ = args.Get(0).([]*virgil.Card)
+	err = args.Error(1)
+	return
+}
+
 type FakeMakeCode struct {
 	mock.Mock
 }
 
-func (m *FakeMakeCode) Make(ownerID string, scope string) (c *db.Code, err error) {
-	args := m.Called(ownerID, scope)
+func (m *FakeMakeCode) Make(grant db.Grant) (c *db.Code, err error) {
+	args := m.Called(grant.OwnerID, grant.Scope)
 	c, _ = args.Get(0).(*db.Code)
 	err = args.Error(1)
 	return
@@ -75,16 +81,149 @@ type FakeCipher struct {
 	mock.Mock
 }
 
-func (c *FakeCipher) Encrypt(data []byte, recipient cryptoapi.PublicKey) (m []byte, err error) {
-	args := c.Called(data, recipient)
+func (c *FakeCipher) Encrypt(data []byte, recipients ...cryptoapi.PublicKey) (m []byte, err error) {
+	args := c.Called(data, recipients)
 	m, _ = args.Get(0).([]byte)
 	err = args.Error(1)
 	return
 }
-func (c *FakeCipher) Validate(CipherData, plainData []byte) bool {
+func (c *FakeCipher) Validate(CipherData, plainData []byte, clientID string) bool {
 	args := c.Called(CipherData, plainData)
 	return args.Bool(0)
 }
+func (c *FakeCipher) ValidateSigned(CipherData, plainData []byte, signer cryptoapi.PublicKey, clientID string) bool {
+	args := c.Called(CipherData, plainData)
+	return args.Bool(0)
+}
+func (c *FakeCipher) VerifySignature(data, signature []byte, signer cryptoapi.PublicKey) bool {
+	args := c.Called(data, signature)
+	return args.Bool(0)
+}
+
+type FakePolicy struct {
+	mock.Mock
+}
+
+func (p *FakePolicy) Evaluate(req core.PolicyRequest) core.PolicyDecision {
+	args := p.Called(req)
+	return args.Get(0).(core.PolicyDecision)
+}
+
+func webClients() *FakeClientStore {
+	s := new(FakeClientStore)
+	s.On("Get", "web").Return(&db.Client{ID: "web", RedirectURIs: []string{"https://app.example.com/cb"}}, nil)
+	s.On("Get", mock.Anything).Return(nil, nil)
+	return s
+}
+
+func TestAuthorize_UnsupportedResponseType_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorUnsupportedGrantType).Once()
+
+	g := Grant{Clients: webClients()}
+	g.Authorize(resp, core.AuthorizationRequest{ResponseType: "token", ClientID: "web", RedirectURI: "https://app.example.com/cb"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAuthorize_RedirectURINotRegistered_ReturnErr(t *testing.T) {
+	table := []core.AuthorizationRequest{
+		{ResponseType: "code", ClientID: "web"},
+		{ResponseType: "code", ClientID: "web", RedirectURI: "https://evil.example.com/cb"},
+		{ResponseType: "code", ClientID: "unknown", RedirectURI: "https://app.example.com/cb"},
+		{ResponseType: "code", RedirectURI: "https://app.example.com/cb"},
+	}
+	for _, v := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorRedirectURIInvalid).Once()
+
+		g := Grant{Clients: webClients()}
+		g.Authorize(resp, v)
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func TestAuthorize_ReturnRequestWithDefaultScope(t *testing.T) {
+	req := core.AuthorizationRequest{ResponseType: "code", ClientID: "web", RedirectURI: "https://app.example.com/cb", State: "xyz"}
+	expected := req
+	expected.Scope = core.ScopeWildcard
+
+	resp := new(FakeResponse)
+	resp.On("Success", &expected).Once()
+
+	g := Grant{Clients: webClients()}
+	g.Authorize(resp, req)
+
+	resp.AssertExpectations(t)
+}
+
+func TestHandshake_RedirectURINotRegistered_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRedirectURIInvalid).Once()
+
+	g := Grant{Clients: webClients()}
+	g.Handshake(resp, core.OwnerCard{ID: "id", ClientID: "web", RedirectURI: "https://evil.example.com/cb"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestHandshake_CodeChallengeInvalid_ReturnErr(t *testing.T) {
+	table := []core.OwnerCard{
+		{ID: "id", CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", CodeChallengeMethod: "plain"},
+		{ID: "id", CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		{ID: "id", CodeChallenge: "short", CodeChallengeMethod: core.CodeChallengeMethodS256},
+	}
+	for _, v := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorCodeChallengeInvalid).Once()
+
+		g := Grant{}
+		g.Handshake(resp, v)
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func cardErr(kind core.CardErrorKind) error {
+	return &core.CardError{Kind: kind, Err: fmt.Errorf("%v", kind)}
+}
+
+func TestCardErrorStatus_CardErrorKindsMapped(t *testing.T) {
+	table := map[core.CardErrorKind]core.ResponseStatus{
+		core.CardNotFound:         core.StatusErrorCardNotFound,
+		core.CardForbidden:        core.StatusErrorCardProtected,
+		core.CardIDInvalid:        core.StatusErrorCardInvalid,
+		core.CardSignatureMissing: core.StatusErrorCardInvalid,
+		core.CardSignatureInvalid: core.StatusErrorCardInvalid,
+		core.CardMalformed:        core.StatusErrorCardInvalid,
+	}
+	for kind, status := range table {
+		got, ok := cardErrorStatus(cardErr(kind))
+		assert.True(t, ok, kind.String())
+		assert.Equal(t, status, got, kind.String())
+	}
+
+	_, ok := cardErrorStatus(cardErr(core.CardsUnavailable))
+	assert.False(t, ok)
+	_, ok = cardErrorStatus(fmt.Errorf("invalid card id"))
+	assert.False(t, ok)
+}
+
+func TestHandshake_CardsServiceUnavailable_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCardsServiceUnavailable).Twice()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "id").Return(nil, cardErr(core.CardsUnavailable))
+	c.On("SearchCards", "alice").Return(nil, cardErr(core.CardsUnavailable))
+
+	s := Grant{Client: c}
+	s.Handshake(resp, core.OwnerCard{ID: "id"})
+	s.Handshake(resp, core.OwnerCard{Identity: "alice"})
+
+	resp.AssertExpectations(t)
+}
 
 func TestHandshake_CardClientReturnErr_LogAndReturnInternalError(t *testing.T) {
 	resp := new(FakeResponse)
@@ -108,7 +247,7 @@ func TestHandshake_CardNotFound_ReturnCardNotFoundStat(t *testing.T) {
 	resp.On("Error", core.StatusErrorCardNotFound).Once()
 
 	c := new(FakeCardClient)
-	c.On("GetCard", mock.Anything).Return(nil, errors.NewServiceError(0, http.StatusNotFound, "Entity was not found"))
+	c.On("GetCard", mock.Anything).Return(nil, cardErr(core.CardNotFound))
 
 	s := Grant{Client: c}
 	s.Handshake(resp, core.OwnerCard{ID: "id"})
@@ -121,7 +260,7 @@ func TestHandshake_CardProtected401_ReturnCardNotFoundStat(t *testing.T) {
 	resp.On("Error", core.StatusErrorCardProtected).Once()
 
 	c := new(FakeCardClient)
-	c.On("GetCard", mock.Anything).Return(nil, errors.NewServiceError(20300, 401, ""))
+	c.On("GetCard", mock.Anything).Return(nil, cardErr(core.CardForbidden))
 
 	s := Grant{Client: c}
 	s.Handshake(resp, core.OwnerCard{ID: "id"})
@@ -134,7 +273,7 @@ func TestHandshake_CardProtected403_ReturnCardNotFoundStat(t *testing.T) {
 	resp.On("Error", core.StatusErrorCardProtected).Once()
 
 	c := new(FakeCardClient)
-	c.On("GetCard", mock.Anything).Return(nil, errors.NewServiceError(20500, 403, ""))
+	c.On("GetCard", mock.Anything).Return(nil, cardErr(core.CardForbidden))
 
 	s := Grant{Client: c}
 	s.Handshake(resp, core.OwnerCard{ID: "id"})
@@ -147,7 +286,7 @@ func TestHandshake_CardNotVerified_ReturnCardInvalid(t *testing.T) {
 	resp.On("Error", core.StatusErrorCardInvalid).Once()
 
 	c := new(FakeCardClient)
-	c.On("GetCard", mock.Anything).Return(nil, fmt.Errorf("Card 1234 does not have signature for verifier ID 123432"))
+	c.On("GetCard", mock.Anything).Return(nil, cardErr(core.CardSignatureMissing))
 
 	s := Grant{Client: c}
 	s.Handshake(resp, core.OwnerCard{ID: "id"})
@@ -224,7 +363,7 @@ func TestHandshake_ReturnResp(t *testing.T) {
 	a.On("Make", "id", "test_scope").Return(&db.Attempt{ID: expected.AttemptId, Message: msg}, nil)
 
 	ch := new(FakeCipher)
-	ch.On("Encrypt", []byte(msg), pk).Return(expected.Message, nil)
+	ch.On("Encrypt", []byte(msg), []cryptoapi.PublicKey{pk}).Return(expected.Message, nil)
 
 	s := Grant{Client: c, AttemptRepo: a, Cipher: ch}
 	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "test_scope"})
@@ -232,6 +371,178 @@ func TestHandshake_ReturnResp(t *testing.T) {
 	resp.AssertExpectations(t)
 }
 
+func TestHandshake_UserCodeInvalid_ReturnErr(t *testing.T) {
+	table := []*db.DeviceCode{
+		nil,
+		{Approved: true, Expired: time.Now().Add(time.Minute)},
+		{Expired: time.Now().Add(-time.Minute)},
+	}
+	for _, d := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorUserCodeInvalid).Once()
+
+		r := new(FakeDeviceCodeRepo)
+		r.On("GetByUserCode", "BCDFGHJK").Return(d, nil)
+
+		s := Grant{Devices: r}
+		s.Handshake(resp, core.OwnerCard{ID: "id", UserCode: "bcdf-ghjk"})
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func TestHandshake_UserCode_GrantOfDeviceAuthorization(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.Anything).Once()
+
+	r := new(FakeDeviceCodeRepo)
+	r.On("GetByUserCode", "BCDFGHJK").Return(&db.DeviceCode{
+		Grant:   db.Grant{ClientID: "tv", Scope: "cards", UserCode: "BCDFGHJK"},
+		Expired: time.Now().Add(time.Minute),
+	}, nil)
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "id").Return(&virgil.Card{}, nil)
+
+	a := new(FakeAttemptRepo)
+	a.On("Make", "id", "cards").Return(&db.Attempt{ID: "attempt", Message: "msg"}, nil).Once()
+
+	ch := new(FakeCipher)
+	ch.On("Encrypt", mock.Anything, mock.Anything).Return([]byte("encrypted"), nil)
+
+	s := Grant{Client: c, AttemptRepo: a, Cipher: ch, Devices: r}
+	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "*", UserCode: "bcdf-ghjk"})
+
+	resp.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestHandshake_CardIdentityKeptForIDToken(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.Anything).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "id").Return(&virgil.Card{
+		Identity:   "alice@example.com",
+		Signatures: []*virgil.CardSignature{{Signer: "self", ExtraFields: map[string]string{"identity_type": "email"}}},
+	}, nil)
+
+	var grant db.Grant
+	a := new(FakeAttemptRepo)
+	a.On("Make", "id", "openid").Return(&db.Attempt{ID: "attempt", Message: "msg"}, nil)
+
+	ch := new(FakeCipher)
+	ch.On("Encrypt", mock.Anything, mock.Anything).Return([]byte("encrypted"), nil)
+
+	s := Grant{Client: c, AttemptRepo: &attemptRecorder{FakeAttemptRepo: a, grant: &grant}, Cipher: ch}
+	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "openid", Nonce: "nonce"})
+
+	resp.AssertExpectations(t)
+	assert.Equal(t, "alice@example.com", grant.Identity)
+	assert.Equal(t, "email", grant.IdentityType)
+	assert.Equal(t, "nonce", grant.Nonce)
+	assert.Equal(t, core.ACRCardEncryption, grant.ACR)
+}
+
+// attemptRecorder remembers the grant of the made attempt
+type attemptRecorder struct {
+	*FakeAttemptRepo
+	grant *db.Grant
+}
+
+func (r *attemptRecorder) Make(grant db.Grant) (*db.Attempt, error) {
+	*r.grant = grant
+	return r.FakeAttemptRepo.Make(grant)
+}
+
+func TestHandshake_PolicyDenied_ReturnScopeNotAllowed(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorScopeNotAllowed).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "id").Return(&virgil.Card{Identity: "alice", Signatures: []*virgil.CardSignature{{Signer: "self"}}}, nil)
+
+	p := new(FakePolicy)
+	p.On("Evaluate", core.PolicyRequest{CardID: "id", Identity: "alice", Signers: []string{"self"}, ClientID: "client", Scope: "mail"}).Return(core.PolicyDecision{})
+
+	s := Grant{Client: c, Policy: p}
+	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "mail", ClientID: "client"})
+
+	resp.AssertExpectations(t)
+	p.AssertExpectations(t)
+}
+
+func TestHandshake_PolicyDeniedInShadowMode_LogAndMakeAttempt(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", mock.Anything).Return(&virgil.Card{}, nil)
+
+	p := new(FakePolicy)
+	p.On("Evaluate", mock.Anything).Return(core.PolicyDecision{Shadow: true})
+
+	l := new(FakeLogger)
+	l.On("Printf").Twice()
+
+	a := new(FakeAttemptRepo)
+	a.On("Make", "id", "mail").Return(nil, fmt.Errorf("ERROR")).Once()
+
+	s := Grant{Client: c, Logger: l, AttemptRepo: a, Policy: p}
+	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "mail"})
+
+	resp.AssertExpectations(t)
+	l.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestHandshake_PolicyAllowedInShadowMode_MakeAttemptWithRequestedScope(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", mock.Anything).Return(&virgil.Card{}, nil)
+
+	p := new(FakePolicy)
+	p.On("Evaluate", mock.Anything).Return(core.PolicyDecision{Allowed: true, Shadow: true, Scope: "profile", TokenTTL: 60})
+
+	l := new(FakeLogger)
+	l.On("Printf").Twice()
+
+	a := new(FakeAttemptRepo)
+	a.On("Make", "id", "*").Return(nil, fmt.Errorf("ERROR")).Once()
+
+	s := Grant{Client: c, Logger: l, AttemptRepo: a, Policy: p}
+	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "*"})
+
+	resp.AssertExpectations(t)
+	l.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestHandshake_PolicyAllowed_MakeAttemptWithGrantedScope(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", mock.Anything).Return(&virgil.Card{}, nil)
+
+	p := new(FakePolicy)
+	p.On("Evaluate", mock.Anything).Return(core.PolicyDecision{Allowed: true, Scope: "profile", TokenTTL: 60})
+
+	l := new(FakeLogger)
+	l.On("Printf").Once()
+
+	a := new(FakeAttemptRepo)
+	a.On("Make", "id", "profile").Return(nil, fmt.Errorf("ERROR")).Once()
+
+	s := Grant{Client: c, Logger: l, AttemptRepo: a, Policy: p}
+	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "*"})
+
+	resp.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
 func TestAcknowledge_AttemptRepoReturnErr_LogAndReturnInternalError(t *testing.T) {
 	resp := new(FakeResponse)
 	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
@@ -326,7 +637,7 @@ func TestAcknowledge_RemoveAttemptReturnErr_ReturnErr(t *testing.T) {
 	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
 
 	a := new(FakeAttemptRepo)
-	a.On("Get", attemptID).Return(&db.Attempt{Expired: time.Now().Add(10 * time.Minute), OwnerID: ownerID, Message: plainMsg, Scope: scope}, nil)
+	a.On("Get", attemptID).Return(&db.Attempt{Expired: time.Now().Add(10 * time.Minute), Grant: db.Grant{OwnerID: ownerID, Scope: scope}, Message: plainMsg}, nil)
 	a.On("Remove", attemptID).Return(fmt.Errorf("Error"))
 
 	l := new(FakeLogger)
@@ -356,10 +667,10 @@ func TestAcknowledge_ReturnVal(t *testing.T) {
 	)
 
 	resp := new(FakeResponse)
-	resp.On("Success", map[string]string{"code": code}).Once()
+	resp.On("Success", &core.AuthorizationCode{Code: code}).Once()
 
 	a := new(FakeAttemptRepo)
-	a.On("Get", attemptID).Return(&db.Attempt{Expired: time.Now().Add(10 * time.Minute), OwnerID: ownerID, Message: plainMsg, Scope: scope}, nil)
+	a.On("Get", attemptID).Return(&db.Attempt{Expired: time.Now().Add(10 * time.Minute), Grant: db.Grant{OwnerID: ownerID, Scope: scope}, Message: plainMsg}, nil)
 	a.On("Remove", attemptID).Return(nil)
 
 	c := new(FakeMakeCode)
@@ -373,3 +684,414 @@ func TestAcknowledge_ReturnVal(t *testing.T) {
 
 	resp.AssertExpectations(t)
 }
+
+func TestAcknowledge_UserCode_DeviceApproved(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("NoContent").Once()
+
+	a := new(FakeAttemptRepo)
+	a.On("Get", "attempt").Return(&db.Attempt{
+		Expired: time.Now().Add(time.Minute),
+		Grant:   db.Grant{OwnerID: "owner", Scope: "cards", ClientID: "tv", UserCode: "BCDFGHJK"},
+		Message: "plain",
+	}, nil)
+	a.On("Remove", "attempt").Return(nil).Once()
+
+	r := new(FakeDeviceCodeRepo)
+	r.On("Approve", "BCDFGHJK", "owner", "cards").Return(true, nil).Once()
+
+	ch := new(FakeCipher)
+	ch.On("Validate", []byte("cipher"), []byte("plain")).Return(true)
+
+	s := Grant{AttemptRepo: a, Cipher: ch, Devices: r}
+	s.Acknowledge(resp, core.EncryptedMessage{AttemptId: "attempt", Message: []byte("cipher")})
+
+	resp.AssertExpectations(t)
+	a.AssertExpectations(t)
+	r.AssertExpectations(t)
+}
+
+func TestAcknowledge_BrowserFlow_ReturnRedirectURI(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.AuthorizationCode{
+		Code:        "code",
+		RedirectURI: "https://app.example.com/cb?code=code&lang=en&state=a+b",
+	}).Once()
+
+	a := new(FakeAttemptRepo)
+	a.On("Get", "attempt id").Return(&db.Attempt{
+		Expired: time.Now().Add(10 * time.Minute),
+		Grant:   db.Grant{OwnerID: "owner id", Scope: "*", RedirectURI: "https://app.example.com/cb?lang=en", State: "a b"},
+		Message: "plain msg",
+	}, nil)
+	a.On("Remove", "attempt id").Return(nil)
+
+	c := new(FakeMakeCode)
+	c.On("Make", "owner id", "*").Return(&db.Code{Code: "code"}, nil)
+
+	ch := new(FakeCipher)
+	ch.On("Validate", mock.Anything, mock.Anything).Return(true)
+
+	s := Grant{AttemptRepo: a, MakeCode: c, Cipher: ch}
+	s.Acknowledge(resp, core.EncryptedMessage{AttemptId: "attempt id", Message: []byte("cipher msg")})
+
+	resp.AssertExpectations(t)
+}
+
+func TestHandshake_ChallengeMethodUnsupported_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorChallengeMethodUnsupported).Once()
+
+	g := Grant{}
+	g.Handshake(resp, core.OwnerCard{ID: "id", ChallengeMethod: "unknown"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestHandshake_SignatureMethod_ReturnPlainChallenge(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.SignatureChallenge{AttemptId: "attempt id", Challenge: "nonce", ServiceID: "auth"}).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "id").Return(&virgil.Card{}, nil)
+
+	a := new(FakeAttemptRepo)
+	a.On("Make", "id", "*").Return(&db.Attempt{ID: "attempt id", Message: "nonce"}, nil)
+
+	// the method is taken from the client
+	cl := new(FakeClientStore)
+	cl.On("Get", "app").Return(&db.Client{ID: "app", ChallengeMethod: core.ChallengeMethodSignature}, nil)
+
+	ch := new(FakeCipher)
+
+	s := Grant{Client: c, AttemptRepo: a, Cipher: ch, Clients: cl, ServiceID: "auth"}
+	s.Handshake(resp, core.OwnerCard{ID: "id", Scope: "*", ClientID: "app"})
+
+	resp.AssertExpectations(t)
+	ch.AssertNotCalled(t, "Encrypt", mock.Anything, mock.Anything)
+}
+
+func TestAcknowledge_SignatureMethod_VerifySignature(t *testing.T) {
+	attempt := &db.Attempt{
+		ID:      "attempt id",
+		Expired: time.Now().Add(10 * time.Minute),
+		Grant:   db.Grant{OwnerID: "owner id", Scope: "*", ChallengeMethod: core.ChallengeMethodSignature},
+		Message: "nonce",
+	}
+	table := []struct {
+		name      string
+		signature []byte
+		valid     bool
+		status    core.ResponseStatus
+	}{
+		{"valid", []byte("signature"), true, 0},
+		{"invalid", []byte("signature"), false, core.StatusErrorEncryptedMessageValidationFailed},
+		{"missed", nil, false, core.StatusErrorEncryptedMessageValidationFailed},
+	}
+	for _, v := range table {
+		resp := new(FakeResponse)
+		if v.status == 0 {
+			resp.On("Success", &core.AuthorizationCode{Code: "code"}).Once()
+		} else {
+			resp.On("Error", v.status).Once()
+		}
+
+		a := new(FakeAttemptRepo)
+		a.On("Get", "attempt id").Return(attempt, nil)
+		a.On("Remove", "attempt id").Return(nil)
+
+		c := new(FakeCardClient)
+		c.On("GetCard", "owner id").Return(&virgil.Card{}, nil)
+
+		mc := new(FakeMakeCode)
+		mc.On("Make", "owner id", "*").Return(&db.Code{Code: "code"}, nil)
+
+		ch := new(FakeCipher)
+		ch.On("VerifySignature", core.SignatureChallengeData("attempt id", "auth", "nonce"), v.signature).Return(v.valid)
+
+		s := Grant{Client: c, AttemptRepo: a, MakeCode: mc, Cipher: ch, ServiceID: "auth"}
+		s.Acknowledge(resp, core.EncryptedMessage{AttemptId: "attempt id", Signature: v.signature})
+
+		resp.AssertExpectations(t)
+		ch.AssertNotCalled(t, "Validate", mock.Anything, mock.Anything)
+	}
+}
+
+// signerCipher accepts signatures of one key only
+type signerCipher struct {
+	*FakeCipher
+	signer cryptoapi.PublicKey
+}
+
+func (c *signerCipher) VerifySignature(data, signature []byte, signer cryptoapi.PublicKey) bool {
+	return signer == c.signer && c.FakeCipher.VerifySignature(data, signature, signer)
+}
+
+func (c *signerCipher) ValidateSigned(CipherData, plainData []byte, signer cryptoapi.PublicKey, clientID string) bool {
+	return signer == c.signer && c.FakeCipher.ValidateSigned(CipherData, plainData, signer, clientID)
+}
+
+func identityCards(t *testing.T) []*virgil.Card {
+	var cards []*virgil.Card
+	for _, id := range []string{"phone", "laptop"} {
+		kp, err := cryptoimpl.NewVirgilCrypto().GenerateKeypair()
+		require.NoError(t, err)
+		cards = append(cards, &virgil.Card{Id: id, Identity: "alice", PublicKey: kp.PublicKey()})
+	}
+	return cards
+}
+
+func TestHandshake_Identity_EncryptForAllCards(t *testing.T) {
+	cards := identityCards(t)
+	outdated := &virgil.Card{Id: "old", Identity: "alice", IsOutdated: true}
+
+	resp := new(FakeResponse)
+	resp.On("Success", &core.EncryptedMessage{AttemptId: "attempt", Message: []byte("encrypted")}).Once()
+
+	c := new(FakeCardClient)
+	c.On("SearchCards", "alice").Return(append(cards, outdated), nil)
+
+	var grant db.Grant
+	a := new(FakeAttemptRepo)
+	a.On("Make", "", "profile").Return(&db.Attempt{ID: "attempt", Message: "msg"}, nil)
+
+	ch := new(FakeCipher)
+	ch.On("Encrypt", []byte("msg"), []cryptoapi.PublicKey{cards[0].PublicKey, cards[1].PublicKey}).Return([]byte("encrypted"), nil).Once()
+
+	s := Grant{Client: c, AttemptRepo: &attemptRecorder{FakeAttemptRepo: a, grant: &grant}, Cipher: ch}
+	s.Handshake(resp, core.OwnerCard{Identity: "alice", Scope: "profile"})
+
+	resp.AssertExpectations(t)
+	ch.AssertExpectations(t)
+	assert.Equal(t, []string{"phone", "laptop"}, grant.Candidates)
+	assert.Equal(t, "alice", grant.Identity)
+	assert.Equal(t, core.ACRCardEncryption, grant.ACR)
+}
+
+func TestHandshake_IdentitySignatureChallenge_ReturnChallenge(t *testing.T) {
+	cards := identityCards(t)
+
+	resp := new(FakeResponse)
+	resp.On("Success", &core.SignatureChallenge{AttemptId: "attempt", Challenge: "msg", ServiceID: "auth"}).Once()
+
+	c := new(FakeCardClient)
+	c.On("SearchCards", "alice").Return(cards, nil)
+
+	var grant db.Grant
+	a := new(FakeAttemptRepo)
+	a.On("Make", "", "profile").Return(&db.Attempt{ID: "attempt", Message: "msg"}, nil)
+
+	s := Grant{Client: c, AttemptRepo: &attemptRecorder{FakeAttemptRepo: a, grant: &grant}, Cipher: new(FakeCipher), ServiceID: "auth"}
+	s.Handshake(resp, core.OwnerCard{Identity: "alice", Scope: "profile", ChallengeMethod: core.ChallengeMethodSignature})
+
+	resp.AssertExpectations(t)
+	assert.Equal(t, []string{"phone", "laptop"}, grant.Candidates)
+	assert.Equal(t, core.ACRCardSignature, grant.ACR)
+}
+
+func TestHandshake_IdentityWithoutCards_ReturnCardNotFound(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCardNotFound).Once()
+
+	c := new(FakeCardClient)
+	c.On("SearchCards", "alice").Return([]*virgil.Card{{Id: "old", IsOutdated: true}}, nil)
+
+	s := Grant{Client: c}
+	s.Handshake(resp, core.OwnerCard{Identity: "alice", Scope: "profile"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestAcknowledge_Identity_AnsweringCardBecomesOwner(t *testing.T) {
+	cards := identityCards(t)
+	attempt := func(method string) *db.Attempt {
+		return &db.Attempt{
+			ID:      "attempt",
+			Expired: time.Now().Add(time.Minute),
+			Grant:   db.Grant{Scope: "profile", Candidates: []string{"phone", "laptop"}, ChallengeMethod: method},
+			Message: "msg",
+		}
+	}
+	table := []struct {
+		name   string
+		method string
+		answer core.EncryptedMessage
+		status core.ResponseStatus
+	}{
+		{"signed", core.ChallengeMethodSignature, core.EncryptedMessage{Signature: []byte("signature")}, 0},
+		{"not signed", core.ChallengeMethodSignature, core.EncryptedMessage{}, core.StatusErrorEncryptedMessageValidationFailed},
+		{"encrypted", core.ChallengeMethodEncryption, core.EncryptedMessage{Message: []byte("encrypted")}, 0},
+		{"not encrypted", core.ChallengeMethodEncryption, core.EncryptedMessage{}, core.StatusErrorEncryptedMessageValidationFailed},
+	}
+	for _, v := range table {
+		resp := new(FakeResponse)
+		if v.status == 0 {
+			resp.On("Success", &core.AuthorizationCode{Code: "code"}).Once()
+		} else {
+			resp.On("Error", v.status).Once()
+		}
+
+		a := new(FakeAttemptRepo)
+		a.On("Get", "attempt").Return(attempt(v.method), nil)
+		a.On("Remove", "attempt").Return(nil)
+
+		c := new(FakeCardClient)
+		c.On("GetCard", "phone").Return(cards[0], nil)
+		c.On("GetCard", "laptop").Return(cards[1], nil)
+
+		mc := new(FakeMakeCode)
+		mc.On("Make", "laptop", "profile").Return(&db.Code{Code: "code"}, nil)
+
+		ch := new(FakeCipher)
+		ch.On("VerifySignature", core.SignatureChallengeData("attempt", "auth", "msg"), v.answer.Signature).Return(true)
+		ch.On("ValidateSigned", v.answer.Message, []byte("msg")).Return(true)
+
+		v.answer.AttemptId = "attempt"
+		s := Grant{Client: c, AttemptRepo: a, MakeCode: mc, Cipher: &signerCipher{FakeCipher: ch, signer: cards[1].PublicKey}, ServiceID: "auth"}
+		s.Acknowledge(resp, v.answer)
+
+		if !resp.AssertExpectations(t) {
+			t.Log(v.name)
+		}
+	}
+}
+
+type FakeCardParser struct {
+	mock.Mock
+}
+
+func (p *FakeCardParser) ParseCard(raw string) (card *virgil.Card, err error) {
+	args := p.Called(raw)
+	card, _ = args.Get(0).(*virgil.Card)
+	err = args.Error(1)
+	return
+}
+
+func TestHandshake_InlineCard_CardsServiceNotCalled(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", &core.SignatureChallenge{AttemptId: "attempt id", Challenge: "nonce", ServiceID: "auth"}).Once()
+
+	c := new(FakeCardClient)
+
+	ip := new(FakeCardParser)
+	ip.On("ParseCard", "raw card").Return(&virgil.Card{Id: "id"}, nil)
+
+	p := new(FakePolicy)
+	p.On("Evaluate", mock.Anything).Return(core.PolicyDecision{Allowed: true, Scope: "*", InlineCards: true})
+
+	a := new(FakeAttemptRepo)
+	a.On("Make", "id", "*").Return(&db.Attempt{ID: "attempt id", Message: "nonce"}, nil)
+
+	s := Grant{Client: c, AttemptRepo: a, InlineCards: ip, Policy: p, ServiceID: "auth"}
+	s.Handshake(resp, core.OwnerCard{ID: "id", RawCard: "raw card", Scope: "*", ChallengeMethod: core.ChallengeMethodSignature})
+
+	resp.AssertExpectations(t)
+	c.AssertNotCalled(t, "GetCard", mock.Anything)
+}
+
+func TestHandshake_InlineCardInvalid_ReturnCardInvalid(t *testing.T) {
+	table := map[string]struct {
+		card *virgil.Card
+		err  error
+	}{
+		"malformed":   {nil, cardErr(core.CardMalformed)},
+		"bad sign":    {nil, cardErr(core.CardSignatureInvalid)},
+		"id mismatch": {&virgil.Card{Id: "other id"}, nil},
+	}
+	for name, v := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorCardInvalid).Once()
+
+		c := new(FakeCardClient)
+
+		ip := new(FakeCardParser)
+		ip.On("ParseCard", "raw card").Return(v.card, v.err)
+
+		s := Grant{Client: c, InlineCards: ip, Policy: new(FakePolicy)}
+		s.Handshake(resp, core.OwnerCard{ID: "id", RawCard: "raw card", Scope: "*"})
+
+		assert.True(t, resp.AssertExpectations(t), name)
+		c.AssertNotCalled(t, "GetCard", mock.Anything)
+	}
+}
+
+func TestHandshake_InlineCardsDisabled_GetCard(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCardNotFound).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "id").Return(nil, cardErr(core.CardNotFound)).Once()
+
+	s := Grant{Client: c}
+	s.Handshake(resp, core.OwnerCard{ID: "id", RawCard: "raw card", Scope: "*"})
+
+	resp.AssertExpectations(t)
+	c.AssertExpectations(t)
+}
+
+func TestHandshake_PolicyDeniesInlineCards_GetCard(t *testing.T) {
+	table := map[string]Policy{
+		"no policy":   nil,
+		"not allowed": fixedDecision(core.PolicyDecision{Allowed: true, Scope: "*"}),
+		"shadow mode": fixedDecision(core.PolicyDecision{Allowed: true, Shadow: true, Scope: "*", InlineCards: true}),
+	}
+	for name, p := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorCardNotFound).Once()
+
+		c := new(FakeCardClient)
+		c.On("GetCard", "id").Return(nil, cardErr(core.CardNotFound)).Once()
+
+		ip := new(FakeCardParser)
+		ip.On("ParseCard", "raw card").Return(&virgil.Card{Id: "id"}, nil)
+
+		s := Grant{Client: c, InlineCards: ip, Policy: p}
+		s.Handshake(resp, core.OwnerCard{ID: "id", RawCard: "raw card", Scope: "*"})
+
+		if !resp.AssertExpectations(t) || !c.AssertExpectations(t) {
+			t.Log(name)
+		}
+	}
+}
+
+func fixedDecision(d core.PolicyDecision) Policy {
+	p := new(FakePolicy)
+	p.On("Evaluate", mock.Anything).Return(d)
+	return p
+}
+
+func TestAcknowledge_InlineCard_SignatureVerifiedWithRawCard(t *testing.T) {
+	attempt := &db.Attempt{
+		ID:      "attempt id",
+		Expired: time.Now().Add(10 * time.Minute),
+		Grant:   db.Grant{OwnerID: "owner id", Scope: "*", ChallengeMethod: core.ChallengeMethodSignature, RawCard: "raw card"},
+		Message: "nonce",
+	}
+
+	resp := new(FakeResponse)
+	resp.On("Success", &core.AuthorizationCode{Code: "code"}).Once()
+
+	a := new(FakeAttemptRepo)
+	a.On("Get", "attempt id").Return(attempt, nil)
+	a.On("Remove", "attempt id").Return(nil)
+
+	c := new(FakeCardClient)
+
+	ip := new(FakeCardParser)
+	ip.On("ParseCard", "raw card").Return(&virgil.Card{Id: "owner id"}, nil).Once()
+
+	mc := new(FakeMakeCode)
+	mc.On("Make", "owner id", "*").Return(&db.Code{Code: "code"}, nil)
+
+	ch := new(FakeCipher)
+	ch.On("VerifySignature", core.SignatureChallengeData("attempt id", "auth", "nonce"), []byte("signature")).Return(true)
+
+	s := Grant{Client: c, AttemptRepo: a, MakeCode: mc, Cipher: ch, InlineCards: ip, ServiceID: "auth"}
+	s.Acknowledge(resp, core.EncryptedMessage{AttemptId: "attempt id", Signature: []byte("signature")})
+
+	resp.AssertExpectations(t)
+	ip.AssertExpectations(t)
+	c.AssertNotCalled(t, "GetCard", mock.Anything)
+	assert.Empty(t, attempt.RawCard)
+	assert.True(t, attempt.InlineCard)
+}
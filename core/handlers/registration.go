@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+const (
+	authMethodNone              = "none"
+	authMethodClientSecretBasic = "client_secret_basic"
+	authMethodClientSecretPost  = "client_secret_post"
+	authMethodPrivateKeyJWT     = "private_key_jwt"
+
+	auditActionRegister = "client.register"
+	auditActionUpdate   = "client.update"
+	auditActionDelete   = "client.delete"
+
+	auditActorInitialToken      = "initial_access_token"
+	auditActorRegistrationToken = "registration_access_token"
+)
+
+// supportedGrantTypes are the grant types that a client may be registered with
+var supportedGrantTypes = []string{
+	grantTypeAccessCode,
+	grantTypeRefreshToken,
+	grantTypeJWTBearer,
+	grantTypeClientCredentials,
+	core.GrantTypeDeviceCode,
+	grantTypeTokenExchange,
+}
+
+type SecretHasher interface {
+	Hash(secret string) (string, error)
+	Compare(hash, secret string) bool
+}
+
+// Registration implements the dynamic client registration (RFC 7591) and the client management (RFC 7592).
+type Registration struct {
+	Logger             Logger
+	Clients            db.ClientStore
+	Audit              db.AuditRepo
+	Hasher             SecretHasher
+	InitialAccessToken string
+	BaseURL            string // base of registration_client_uri
+}
+
+func (s *Registration) Register(resp core.Response, req core.ClientRegistrationRequest) {
+	if !s.initialToken(req.Token) {
+		resp.Error(core.StatusErrorRegistrationTokenInvalid)
+		return
+	}
+	if !validClientMetadata(&req.Metadata) {
+		resp.Error(core.StatusErrorInvalidClientMetadata)
+		return
+	}
+	id, err := randomString(16)
+	if err != nil {
+		s.Logger.Printf("Register[client id]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	token, err := randomString(32)
+	if err != nil {
+		s.Logger.Printf("Register[registration token]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	tokenHash, err := s.Hasher.Hash(token)
+	if err != nil {
+		s.Logger.Printf("Register[hash registration token]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	c := &db.Client{
+		ID:                    id,
+		RegistrationTokenHash: tokenHash,
+		CreatedAt:             time.Now().UTC(),
+	}
+	secret, ok := s.applyMetadata(resp, c, req.Metadata)
+	if !ok {
+		return
+	}
+	if err = s.Clients.Create(c); err != nil {
+		s.Logger.Printf("Register[create client]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	s.audit(auditActionRegister, auditActorInitialToken, c.ID, req.RemoteAddr)
+
+	info := s.clientInformation(c)
+	info.ClientSecret = secret
+	info.RegistrationAccessToken = token
+	resp.Created(info)
+}
+
+func (s *Registration) Read(resp core.Response, req core.ClientRegistrationRequest) {
+	c, ok := s.authorize(resp, req)
+	if !ok {
+		return
+	}
+	resp.Success(s.clientInformation(c))
+}
+
+// Update replaces the client metadata. The secret is kept unless the client starts using it, then a new one is issued.
+// The registration access token may only keep or narrow the privileges of the client, the initial access token may
+// change them in any way.
+func (s *Registration) Update(resp core.Response, req core.ClientRegistrationRequest) {
+	var (
+		c     *db.Client
+		ok    bool
+		actor = auditActorRegistrationToken
+	)
+	if s.initialToken(req.Token) {
+		actor = auditActorInitialToken
+		c, ok = s.client(resp, req.ClientID)
+	} else {
+		c, ok = s.authorize(resp, req)
+	}
+	if !ok {
+		return
+	}
+	if !validClientMetadata(&req.Metadata) {
+		resp.Error(core.StatusErrorInvalidClientMetadata)
+		return
+	}
+	if actor == auditActorRegistrationToken && !narrowsPrivileges(c, req.Metadata) {
+		resp.Error(core.StatusErrorInvalidClientMetadata)
+		return
+	}
+	secret, ok := s.applyMetadata(resp, c, req.Metadata)
+	if !ok {
+		return
+	}
+	if err := s.Clients.Update(c); err != nil {
+		s.Logger.Printf("Update[update client]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	s.audit(auditActionUpdate, actor, c.ID, req.RemoteAddr)
+
+	info := s.clientInformation(c)
+	info.ClientSecret = secret
+	resp.Success(info)
+}
+
+func (s *Registration) Delete(resp core.Response, req core.ClientRegistrationRequest) {
+	c, ok := s.authorize(resp, req)
+	if !ok {
+		return
+	}
+	removed, err := s.Clients.Remove(c.ID)
+	if err != nil {
+		s.Logger.Printf("Delete[remove client]: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return
+	}
+	// the client was removed by a concurrent request
+	if !removed {
+		resp.Error(core.StatusErrorRegistrationTokenInvalid)
+		return
+	}
+	s.audit(auditActionDelete, auditActorRegistrationToken, c.ID, req.RemoteAddr)
+	resp.NoContent()
+}
+
+func (s *Registration) initialToken(token string) bool {
+	return s.InitialAccessToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.InitialAccessToken)) == 1
+}
+
+// authorize returns the client if the registration access token belongs to it
+func (s *Registration) authorize(resp core.Response, req core.ClientRegistrationRequest) (*db.Client, bool) {
+	if req.Token == "" {
+		resp.Error(core.StatusErrorRegistrationTokenInvalid)
+		return nil, false
+	}
+	c, ok := s.client(resp, req.ClientID)
+	if !ok {
+		return nil, false
+	}
+	if c.RegistrationTokenHash == "" || !s.Hasher.Compare(c.RegistrationTokenHash, req.Token) {
+		resp.Error(core.StatusErrorRegistrationTokenInvalid)
+		return nil, false
+	}
+	return c, true
+}
+
+// client returns the registered client. RFC 7592 section 2: the unknown client is reported as the invalid token.
+func (s *Registration) client(resp core.Response, id string) (*db.Client, bool) {
+	if id == "" {
+		resp.Error(core.StatusErrorRegistrationTokenInvalid)
+		return nil, false
+	}
+	c, err := s.Clients.Get(id)
+	if err != nil {
+		s.Logger.Printf("Get client %v: %v", id, err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return nil, false
+	}
+	if c == nil {
+		resp.Error(core.StatusErrorRegistrationTokenInvalid)
+		return nil, false
+	}
+	return c, true
+}
+
+// applyMetadata sets the metadata to the client and returns the client secret if a new one was issued
+func (s *Registration) applyMetadata(resp core.Response, c *db.Client, m core.ClientMetadata) (string, bool) {
+	c.Name = m.ClientName
+	c.GrantTypes = m.GrantTypes
+	c.Scopes = strings.Fields(m.Scope)
+	c.AccessTokenTTL = m.AccessTokenTTL
+	c.RequirePKCE = m.RequirePKCE
+	c.RedirectURIs = m.RedirectURIs
+	c.AppCardID = m.AppCardID
+	c.RequireRequestSign = m.RequireRequestSign
+	c.ChallengeMethod = m.ChallengeMethod
+	c.ExchangeAudiences = m.ExchangeAudiences
+	c.SubjectType = m.SubjectType
+	c.RequireConsent = m.RequireConsent
+	c.IssueRefreshToken = c.AllowsGrantType(grantTypeRefreshToken)
+	c.AuthMethod = m.TokenEndpointAuthMethod
+	c.PublicKey = m.PublicKey
+
+	if m.TokenEndpointAuthMethod != authMethodClientSecretBasic && m.TokenEndpointAuthMethod != authMethodClientSecretPost {
+		c.SecretHash = ""
+		return "", true
+	}
+	if c.SecretHash != "" {
+		return "", true
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		s.Logger.Printf("Client secret: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return "", false
+	}
+	c.SecretHash, err = s.Hasher.Hash(secret)
+	if err != nil {
+		s.Logger.Printf("Hash client secret: %v", err)
+		resp.Error(core.StatusErrorInternalApplicationError)
+		return "", false
+	}
+	return secret, true
+}
+
+func (s *Registration) clientInformation(c *db.Client) *core.ClientInformation {
+	return &core.ClientInformation{
+		ClientMetadata: core.ClientMetadata{
+			ClientName:              c.Name,
+			GrantTypes:              c.GrantTypes,
+			Scope:                   strings.Join(c.Scopes, " "),
+			TokenEndpointAuthMethod: c.AuthMethod,
+			PublicKey:               c.PublicKey,
+			AccessTokenTTL:          c.AccessTokenTTL,
+			RequirePKCE:             c.RequirePKCE,
+			RedirectURIs:            c.RedirectURIs,
+			AppCardID:               c.AppCardID,
+			RequireRequestSign:      c.RequireRequestSign,
+			ChallengeMethod:         c.ChallengeMethod,
+			ExchangeAudiences:       c.ExchangeAudiences,
+			SubjectType:             c.SubjectType,
+			RequireConsent:          c.RequireConsent,
+		},
+		ClientID:              c.ID,
+		ClientIDIssuedAt:      c.CreatedAt.Unix(),
+		RegistrationClientURI: s.BaseURL + "/v5/clients/" + c.ID,
+	}
+}
+
+func (s *Registration) audit(action, actor, clientID, remoteAddr string) {
+	s.Logger.Printf("Audit: %v %v by %v from %v", action, clientID, actor, remoteAddr)
+	err := s.Audit.Log(&db.AuditRecord{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		ClientID:   clientID,
+		Actor:      actor,
+		RemoteAddr: remoteAddr,
+	})
+	if err != nil {
+		s.Logger.Printf("Audit[%v %v]: %v", action, clientID, err)
+	}
+}
+
+// validClientMetadata checks the metadata and fills the defaults of RFC 7591 section 2
+func validClientMetadata(m *core.ClientMetadata) bool {
+	if len(m.GrantTypes) == 0 {
+		m.GrantTypes = []string{grantTypeAccessCode}
+	}
+	for _, g := range m.GrantTypes {
+		if !hasString(supportedGrantTypes, g) {
+			return false
+		}
+	}
+	if m.TokenEndpointAuthMethod == "" {
+		m.TokenEndpointAuthMethod = authMethodClientSecretBasic
+	}
+	switch m.TokenEndpointAuthMethod {
+	case authMethodNone, authMethodClientSecretBasic, authMethodClientSecretPost:
+		if len(m.PublicKey) != 0 {
+			return false
+		}
+	case authMethodPrivateKeyJWT:
+		// the assertions are signed with the registered key or the key of the application card
+		if len(m.PublicKey) == 0 && m.AppCardID == "" {
+			return false
+		}
+	default:
+		return false
+	}
+	for _, v := range m.RedirectURIs {
+		// RFC 6749 section 3.1.2: an absolute URI without a fragment
+		u, err := url.Parse(v)
+		if err != nil || !u.IsAbs() || u.Host == "" || u.Fragment != "" {
+			return false
+		}
+	}
+	if m.RequireRequestSign && m.AppCardID == "" {
+		return false
+	}
+	if !core.ValidChallengeMethod(m.ChallengeMethod) || !core.ValidSubjectType(m.SubjectType) {
+		return false
+	}
+	return m.AccessTokenTTL >= 0
+}
+
+// narrowsPrivileges reports whether the metadata keeps or narrows the privileges of the client: the scopes, the grant
+// types, the exchange audiences, the application card and the requirements of PKCE, consents and signed handshakes
+func narrowsPrivileges(c *db.Client, m core.ClientMetadata) bool {
+	// the client without scopes or with the wildcard may obtain any scope
+	if len(c.Scopes) != 0 && !hasString(c.Scopes, core.ScopeWildcard) {
+		scopes := strings.Fields(m.Scope)
+		if len(scopes) == 0 || !subset(scopes, c.Scopes) {
+			return false
+		}
+	}
+	switch {
+	case !subset(m.GrantTypes, c.GrantTypes), !subset(m.ExchangeAudiences, c.ExchangeAudiences):
+		return false
+	case m.AppCardID != c.AppCardID:
+		return false
+	case c.RequirePKCE && !m.RequirePKCE, c.RequireConsent && !m.RequireConsent,
+		c.RequireRequestSign && !m.RequireRequestSign:
+		return false
+	}
+	return true
+}
+
+func subset(list, of []string) bool {
+	for _, v := range list {
+		if !hasString(of, v) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasString(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,444 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type FakeClientStore struct {
+	mock.Mock
+}
+
+func (r *FakeClientStore) Get(id string) (c *db.Client, err error) {
+	args := r.Called(id)
+	c, _ = args.Get(0).(*db.Client)
+	err = args.Error(1)
+	return
+}
+
+func (r *FakeClientStore) Create(c *db.Client) error {
+	return r.Called(c).Error(0)
+}
+
+func (r *FakeClientStore) Update(c *db.Client) error {
+	return r.Called(c).Error(0)
+}
+
+func (r *FakeClientStore) Remove(id string) (bool, error) {
+	args := r.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+type FakeAuditRepo struct {
+	mock.Mock
+}
+
+func (r *FakeAuditRepo) Log(rec *db.AuditRecord) error {
+	return r.Called(rec.Action, rec.ClientID).Error(0)
+}
+
+type FakeHasher struct{}
+
+func (FakeHasher) Hash(secret string) (string, error) {
+	return "hash:" + secret, nil
+}
+
+func (FakeHasher) Compare(hash, secret string) bool {
+	return hash == "hash:"+secret
+}
+
+func newRegistration(store db.ClientStore, audit db.AuditRepo) *Registration {
+	l := new(FakeLogger)
+	l.On("Printf")
+	return &Registration{
+		Logger:             l,
+		Clients:            store,
+		Audit:              audit,
+		Hasher:             FakeHasher{},
+		InitialAccessToken: "initial",
+		BaseURL:            "https://auth.example.com",
+	}
+}
+
+func TestRegister_InitialTokenInvalid_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRegistrationTokenInvalid).Once()
+
+	r := newRegistration(nil, nil)
+	r.Register(resp, core.ClientRegistrationRequest{Token: "wrong"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRegister_RegistrationDisabled_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRegistrationTokenInvalid).Once()
+
+	r := newRegistration(nil, nil)
+	r.InitialAccessToken = ""
+	r.Register(resp, core.ClientRegistrationRequest{Token: ""})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRegister_MetadataInvalid_ReturnErr(t *testing.T) {
+	table := []core.ClientMetadata{
+		{GrantTypes: []string{"password"}},
+		{TokenEndpointAuthMethod: "tls_client_auth"},
+		{TokenEndpointAuthMethod: authMethodPrivateKeyJWT},
+		{TokenEndpointAuthMethod: authMethodNone, PublicKey: []byte("key")},
+		{AccessTokenTTL: -1},
+		{RedirectURIs: []string{"/cb"}},
+		{RedirectURIs: []string{"https://app.example.com/cb#fragment"}},
+	}
+	for _, m := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorInvalidClientMetadata).Once()
+
+		r := newRegistration(nil, nil)
+		r.Register(resp, core.ClientRegistrationRequest{Token: "initial", Metadata: m})
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func TestRegister_StoreReturnErr_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	s := new(FakeClientStore)
+	s.On("Create", mock.Anything).Return(fmt.Errorf("ERROR"))
+
+	r := newRegistration(s, nil)
+	r.Register(resp, core.ClientRegistrationRequest{Token: "initial"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRegister_ReturnClientInformation(t *testing.T) {
+	var info *core.ClientInformation
+	resp := new(FakeResponse)
+	resp.On("Created", mock.Anything).Run(func(args mock.Arguments) {
+		info = args.Get(0).(*core.ClientInformation)
+	}).Once()
+
+	var c *db.Client
+	s := new(FakeClientStore)
+	s.On("Create", mock.Anything).Run(func(args mock.Arguments) {
+		c = args.Get(0).(*db.Client)
+	}).Return(nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionRegister, mock.Anything).Return(nil).Once()
+
+	r := newRegistration(s, a)
+	r.Register(resp, core.ClientRegistrationRequest{Token: "initial", Metadata: core.ClientMetadata{
+		ClientName: "app",
+		GrantTypes: []string{grantTypeAccessCode, grantTypeRefreshToken},
+		Scope:      "profile mail",
+	}})
+
+	resp.AssertExpectations(t)
+	s.AssertExpectations(t)
+	a.AssertExpectations(t)
+
+	assert.Equal(t, c.ID, info.ClientID)
+	assert.Equal(t, "app", c.Name)
+	assert.Equal(t, []string{"profile", "mail"}, c.Scopes)
+	assert.True(t, c.IssueRefreshToken)
+	assert.Equal(t, authMethodClientSecretBasic, info.TokenEndpointAuthMethod)
+	assert.NotEmpty(t, info.ClientSecret)
+	assert.Equal(t, "hash:"+info.ClientSecret, c.SecretHash)
+	assert.Equal(t, "hash:"+info.RegistrationAccessToken, c.RegistrationTokenHash)
+	assert.Equal(t, "https://auth.example.com/v5/clients/"+c.ID, info.RegistrationClientURI)
+}
+
+func TestRegister_PublicClient_SecretNotIssued(t *testing.T) {
+	var info *core.ClientInformation
+	resp := new(FakeResponse)
+	resp.On("Created", mock.Anything).Run(func(args mock.Arguments) {
+		info = args.Get(0).(*core.ClientInformation)
+	}).Once()
+
+	s := new(FakeClientStore)
+	s.On("Create", mock.Anything).Return(nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionRegister, mock.Anything).Return(nil).Once()
+
+	r := newRegistration(s, a)
+	r.Register(resp, core.ClientRegistrationRequest{Token: "initial", Metadata: core.ClientMetadata{
+		TokenEndpointAuthMethod: authMethodNone,
+	}})
+
+	resp.AssertExpectations(t)
+	assert.Empty(t, info.ClientSecret)
+	assert.Equal(t, []string{grantTypeAccessCode}, info.GrantTypes)
+}
+
+func TestRead_ClientNotFound_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRegistrationTokenInvalid).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(nil, nil)
+
+	r := newRegistration(s, nil)
+	r.Read(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRead_TokenInvalid_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRegistrationTokenInvalid).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(&db.Client{ID: "id", RegistrationTokenHash: "hash:token"}, nil)
+
+	r := newRegistration(s, nil)
+	r.Read(resp, core.ClientRegistrationRequest{Token: "wrong", ClientID: "id"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestRead_ReturnClientInformation(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.MatchedBy(func(info *core.ClientInformation) bool {
+		return info.ClientID == "id" && info.Scope == "profile" && info.ClientSecret == "" && info.RegistrationAccessToken == ""
+	})).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(&db.Client{
+		ID:                    "id",
+		SecretHash:            "hash:secret",
+		Scopes:                []string{"profile"},
+		RegistrationTokenHash: "hash:token",
+	}, nil)
+
+	r := newRegistration(s, nil)
+	r.Read(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id"})
+
+	resp.AssertExpectations(t)
+}
+
+func TestUpdate_SecretClient_SecretKept(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.MatchedBy(func(info *core.ClientInformation) bool {
+		return info.ClientSecret == ""
+	})).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(&db.Client{
+		ID:                    "id",
+		GrantTypes:            []string{grantTypeAccessCode},
+		SecretHash:            "hash:secret",
+		RegistrationTokenHash: "hash:token",
+	}, nil)
+	s.On("Update", mock.MatchedBy(func(c *db.Client) bool {
+		return c.SecretHash == "hash:secret" && c.AccessTokenTTL == 60
+	})).Return(nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionUpdate, "id").Return(nil).Once()
+
+	r := newRegistration(s, a)
+	r.Update(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id", Metadata: core.ClientMetadata{
+		TokenEndpointAuthMethod: authMethodClientSecretPost,
+		AccessTokenTTL:          60,
+	}})
+
+	resp.AssertExpectations(t)
+	s.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestUpdate_BecomesPublic_SecretRemoved(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.Anything).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(&db.Client{
+		ID:                    "id",
+		GrantTypes:            []string{grantTypeAccessCode},
+		SecretHash:            "hash:secret",
+		RegistrationTokenHash: "hash:token",
+	}, nil)
+	s.On("Update", mock.MatchedBy(func(c *db.Client) bool {
+		return c.Public()
+	})).Return(nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionUpdate, "id").Return(nil).Once()
+
+	r := newRegistration(s, a)
+	r.Update(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id", Metadata: core.ClientMetadata{
+		TokenEndpointAuthMethod: authMethodNone,
+	}})
+
+	resp.AssertExpectations(t)
+	s.AssertExpectations(t)
+}
+
+// privilegedClient is the client with the privileges set by the admin
+func privilegedClient() *db.Client {
+	return &db.Client{
+		ID:                    "id",
+		RegistrationTokenHash: "hash:token",
+		GrantTypes:            []string{grantTypeAccessCode, grantTypeTokenExchange},
+		Scopes:                []string{"profile", "mail"},
+		ExchangeAudiences:     []string{"api"},
+		AppCardID:             "app card",
+		RequirePKCE:           true,
+		RequireConsent:        true,
+		RequireRequestSign:    true,
+		AuthMethod:            authMethodNone,
+	}
+}
+
+// privilegedMetadata is the metadata that keeps the privileges of privilegedClient
+func privilegedMetadata() core.ClientMetadata {
+	return core.ClientMetadata{
+		GrantTypes:              []string{grantTypeAccessCode, grantTypeTokenExchange},
+		Scope:                   "profile mail",
+		ExchangeAudiences:       []string{"api"},
+		AppCardID:               "app card",
+		RequirePKCE:             true,
+		RequireConsent:          true,
+		RequireRequestSign:      true,
+		TokenEndpointAuthMethod: authMethodNone,
+	}
+}
+
+func TestUpdate_RegistrationTokenWidensPrivileges_ReturnErr(t *testing.T) {
+	table := map[string]func(m *core.ClientMetadata){
+		"scope":             func(m *core.ClientMetadata) { m.Scope = "profile mail keys" },
+		"wildcard scope":    func(m *core.ClientMetadata) { m.Scope = core.ScopeWildcard },
+		"no scope":          func(m *core.ClientMetadata) { m.Scope = "" },
+		"grant type":        func(m *core.ClientMetadata) { m.GrantTypes = append(m.GrantTypes, grantTypeClientCredentials) },
+		"exchange audience": func(m *core.ClientMetadata) { m.ExchangeAudiences = append(m.ExchangeAudiences, "admin") },
+		"app card":          func(m *core.ClientMetadata) { m.AppCardID = "another card" },
+		"pkce":              func(m *core.ClientMetadata) { m.RequirePKCE = false },
+		"consent":           func(m *core.ClientMetadata) { m.RequireConsent = false },
+		"request sign":      func(m *core.ClientMetadata) { m.RequireRequestSign = false },
+	}
+	for name, widen := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", core.StatusErrorInvalidClientMetadata).Once()
+
+		s := new(FakeClientStore)
+		s.On("Get", "id").Return(privilegedClient(), nil)
+
+		m := privilegedMetadata()
+		widen(&m)
+		r := newRegistration(s, new(FakeAuditRepo))
+		r.Update(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id", Metadata: m})
+
+		if !resp.AssertExpectations(t) || !s.AssertNotCalled(t, "Update", mock.Anything) {
+			t.Log(name)
+		}
+	}
+}
+
+func TestUpdate_RegistrationTokenNarrowsPrivileges_Updated(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.Anything).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(privilegedClient(), nil)
+	s.On("Update", mock.MatchedBy(func(c *db.Client) bool {
+		return len(c.Scopes) == 1 && len(c.GrantTypes) == 1 && len(c.ExchangeAudiences) == 0
+	})).Return(nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionUpdate, "id").Return(nil).Once()
+
+	m := privilegedMetadata()
+	m.Scope, m.GrantTypes, m.ExchangeAudiences = "profile", []string{grantTypeAccessCode}, nil
+	r := newRegistration(s, a)
+	r.Update(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id", Metadata: m})
+
+	resp.AssertExpectations(t)
+	s.AssertExpectations(t)
+}
+
+func TestUpdate_InitialToken_PrivilegesWidened(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Success", mock.Anything).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(privilegedClient(), nil)
+	s.On("Update", mock.MatchedBy(func(c *db.Client) bool {
+		return hasString(c.Scopes, "keys") && !c.RequirePKCE
+	})).Return(nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionUpdate, "id").Return(nil).Once()
+
+	m := privilegedMetadata()
+	m.Scope, m.RequirePKCE = "profile mail keys", false
+	r := newRegistration(s, a)
+	r.Update(resp, core.ClientRegistrationRequest{Token: "initial", ClientID: "id", Metadata: m})
+
+	resp.AssertExpectations(t)
+	s.AssertExpectations(t)
+}
+
+func TestDelete_ClientRemoved(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("NoContent").Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(&db.Client{ID: "id", RegistrationTokenHash: "hash:token"}, nil)
+	s.On("Remove", "id").Return(true, nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionDelete, "id").Return(nil).Once()
+
+	r := newRegistration(s, a)
+	r.Delete(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id"})
+
+	resp.AssertExpectations(t)
+	s.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestDelete_ClientAlreadyRemoved_ReturnErr(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorRegistrationTokenInvalid).Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(&db.Client{ID: "id", RegistrationTokenHash: "hash:token"}, nil)
+	s.On("Remove", "id").Return(false, nil).Once()
+
+	a := new(FakeAuditRepo)
+
+	r := newRegistration(s, a)
+	r.Delete(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id"})
+
+	resp.AssertExpectations(t)
+	a.AssertNotCalled(t, "Log", mock.Anything, mock.Anything)
+}
+
+func TestDelete_AuditReturnErr_ClientRemoved(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("NoContent").Once()
+
+	s := new(FakeClientStore)
+	s.On("Get", "id").Return(&db.Client{ID: "id", RegistrationTokenHash: "hash:token"}, nil)
+	s.On("Remove", "id").Return(true, nil).Once()
+
+	a := new(FakeAuditRepo)
+	a.On("Log", auditActionDelete, "id").Return(fmt.Errorf("ERROR")).Once()
+
+	r := newRegistration(s, a)
+	r.Delete(resp, core.ClientRegistrationRequest{Token: "token", ClientID: "id"})
+
+	resp.AssertExpectations(t)
+	s.AssertExpectations(t)
+}
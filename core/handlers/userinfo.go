@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"time"
+
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+// UserInfo resolves the card of the access token owner, so resource servers don't call the Cards service themselves
+type UserInfo struct {
+	TokenRepo db.TokenRepo
+	Client    CardClient
+	Logger    Logger
+	CacheTTL  time.Duration // how long the card information is kept, the cache is disabled if it's 0
+
+	cache expiringCache
+}
+
+func (s *UserInfo) UserInfo(resp core.Response, token string) {
+	accessToken, ok := ownerToken(resp, s.TokenRepo, token)
+	if !ok {
+		return
+	}
+
+	if info, ok := s.cache.get(accessToken.OwnerID).(*core.UserInfo); ok {
+		resp.Success(info)
+		return
+	}
+	card, err := s.Client.GetCard(accessToken.OwnerID)
+	if err != nil {
+		// the card was revoked or became inaccessible after the token was issued
+		if status, ok := cardErrorStatus(err); ok {
+			resp.Error(status)
+			return
+		}
+		cardServiceError(resp, s.Logger, "UserInfo[GetCard]", err)
+		return
+	}
+	info := makeUserInfo(card)
+	s.cache.put(accessToken.OwnerID, info, s.CacheTTL)
+	resp.Success(info)
+}
+
+// ownerToken returns the valid access token that was issued on behalf of a card owner
+func ownerToken(resp core.Response, repo db.TokenRepo, token string) (*db.AccessToken, bool) {
+	accessToken, err := repo.Get(token)
+	if err != nil {
+		resp.Error(core.StatusErrorAccessTokenBroken)
+		return nil, false
+	}
+	if time.Now().After(accessToken.Expired) {
+		resp.Error(core.StatusErrorAccessTokenExpired)
+		return nil, false
+	}
+	// tokens of the client credentials grant belong to the client, there is no card behind them
+	if accessToken.OwnerID == "" {
+		resp.Error(core.StatusErrorAccessTokenBroken)
+		return nil, false
+	}
+	return accessToken, true
+}
+
+func makeUserInfo(card *virgil.Card) *core.UserInfo {
+	info := &core.UserInfo{
+		ID:             card.Id,
+		Identity:       card.Identity,
+		IdentityType:   cardIdentityType(card),
+		CreatedAt:      card.CreatedAt.Unix(),
+		PreviousCardID: card.PreviousCardId,
+	}
+	if card.PublicKey != nil {
+		info.Fingerprint = hex.EncodeToString(card.PublicKey.Identifier())
+	}
+	return info
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/virgil.v5/cryptoapi"
+	"gopkg.in/virgil.v5/cryptoimpl"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+func ownerTokenRepo(ownerID string) *FakeTokenRepo {
+	tr := new(FakeTokenRepo)
+	tr.On("Get", "token").Return(&db.AccessToken{
+		OwnerID:  ownerID,
+		ClientID: "web",
+		Expired:  time.Now().Add(time.Minute),
+	}, nil)
+	return tr
+}
+
+func TestUserInfo_CardFound_ReturnCachedInfo(t *testing.T) {
+	pk, err := cryptoimpl.NewVirgilCrypto().ImportPublicKey([]byte(`MCowBQYDK2VwAyEA9C2xSdT5c+0Y1K87vH0c17gOrAZhXNGxW6sgjotoDOs=`))
+	require.NoError(t, err)
+
+	created := time.Unix(1500000000, 0)
+	expected := &core.UserInfo{
+		ID:             "card",
+		Identity:       "alice@example.com",
+		IdentityType:   "email",
+		CreatedAt:      created.Unix(),
+		PreviousCardID: "previous",
+		Fingerprint:    hex.EncodeToString(pk.(cryptoapi.PublicKey).Identifier()),
+	}
+	resp := new(FakeResponse)
+	resp.On("Success", expected).Twice()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "card").Return(&virgil.Card{
+		Id:             "card",
+		Identity:       "alice@example.com",
+		PublicKey:      pk.(cryptoapi.PublicKey),
+		CreatedAt:      created,
+		PreviousCardId: "previous",
+		Signatures:     []*virgil.CardSignature{{Signer: "self", ExtraFields: map[string]string{"identity_type": "email"}}},
+	}, nil).Once()
+
+	s := UserInfo{TokenRepo: ownerTokenRepo("card"), Client: c, CacheTTL: time.Minute}
+	s.UserInfo(resp, "token")
+	s.UserInfo(resp, "token")
+
+	resp.AssertExpectations(t)
+	c.AssertExpectations(t)
+}
+
+func TestUserInfo_CardGone_ReturnCardNotFound(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorCardNotFound).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "card").Return(nil, cardErr(core.CardNotFound))
+
+	s := UserInfo{TokenRepo: ownerTokenRepo("card"), Client: c, CacheTTL: time.Minute}
+	s.UserInfo(resp, "token")
+
+	resp.AssertExpectations(t)
+}
+
+func TestUserInfo_TokenInvalid_ReturnErr(t *testing.T) {
+	table := map[core.ResponseStatus]*db.AccessToken{
+		core.StatusErrorAccessTokenExpired: {OwnerID: "card", Expired: time.Now().Add(-time.Minute)},
+		// client credentials token
+		core.StatusErrorAccessTokenBroken: {ClientID: "daemon", Expired: time.Now().Add(time.Minute)},
+	}
+	for status, token := range table {
+		resp := new(FakeResponse)
+		resp.On("Error", status).Once()
+
+		tr := new(FakeTokenRepo)
+		tr.On("Get", "token").Return(token, nil)
+
+		s := UserInfo{TokenRepo: tr, Client: new(FakeCardClient)}
+		s.UserInfo(resp, "token")
+
+		resp.AssertExpectations(t)
+	}
+}
+
+func TestUserInfo_CardClientReturnErr_LogAndReturnInternalError(t *testing.T) {
+	resp := new(FakeResponse)
+	resp.On("Error", core.StatusErrorInternalApplicationError).Once()
+
+	c := new(FakeCardClient)
+	c.On("GetCard", "card").Return(nil, fmt.Errorf("format"))
+
+	l := new(FakeLogger)
+	l.On("Printf").Once()
+
+	s := UserInfo{TokenRepo: ownerTokenRepo("card"), Client: c, Logger: l}
+	s.UserInfo(resp, "token")
+
+	resp.AssertExpectations(t)
+	l.AssertExpectations(t)
+}
@@ -3,15 +3,42 @@ package core
 type Response interface {
 	Error(code ResponseStatus)
 	Success(model interface{})
+	Created(model interface{})
+	NoContent()
 }
 
 type AuthHandler interface {
 	AccessToken(resp Response, code AccessCode)
-	Refresh(resp Response, grantType string, token string)
+	Refresh(resp Response, grantType string, token string, client ClientCredentials)
 	Verify(resp Response, token string)
+	DeviceAuthorization(resp Response, req DeviceAuthorizationRequest)
+	Introspect(resp Response, req TokenRequest)
+	Revoke(resp Response, req TokenRequest)
+}
+
+type UserInfoHandler interface {
+	UserInfo(resp Response, token string)
+}
+
+type ConsentHandler interface {
+	List(resp Response, token string)
+	Revoke(resp Response, token string, clientID string)
+}
+
+type AdminHandler interface {
+	PurgeCard(resp Response, token string, cardID string)
+	Fingerprints(resp Response, token string)
 }
 
 type GrantHandler interface {
+	Authorize(resp Response, req AuthorizationRequest)
 	Handshake(resp Response, card OwnerCard)
 	Acknowledge(resp Response, msg EncryptedMessage)
 }
+
+type RegistrationHandler interface {
+	Register(resp Response, req ClientRegistrationRequest)
+	Read(resp Response, req ClientRegistrationRequest)
+	Update(resp Response, req ClientRegistrationRequest)
+	Delete(resp Response, req ClientRegistrationRequest)
+}
@@ -1,15 +1,75 @@
 package core
 
+// ClientCredentials authenticate a registered client. The client uses either the secret or the assertion signed
+// by its private key (private_key_jwt). Public clients have neither and are identified by the id only.
+type ClientCredentials struct {
+	ID            string `json:"client_id,omitempty"`
+	Secret        string `json:"client_secret,omitempty"`
+	AssertionType string `json:"client_assertion_type,omitempty"`
+	Assertion     string `json:"client_assertion,omitempty"`
+}
+
 type AccessCode struct {
-	GrantType string `json:"grant_type,omitted"`
-	Code      string `json:"code"`
+	ClientCredentials
+
+	GrantType    string `json:"grant_type,omitted"`
+	Code         string `json:"code"`
+	CodeVerifier string `json:"code_verifier,omitempty"`
+	RedirectURI  string `json:"redirect_uri,omitempty"`
+	Assertion    string `json:"assertion,omitempty"` // JWT bearer grant
+	Scope        string `json:"scope,omitempty"`
+	DeviceCode   string `json:"device_code,omitempty"` // device authorization grant
+
+	// token exchange
+	SubjectToken     string `json:"subject_token,omitempty"`
+	SubjectTokenType string `json:"subject_token_type,omitempty"`
+	Audience         string `json:"audience,omitempty"`
+}
+
+// DeviceAuthorizationRequest starts the device authorization grant (RFC 8628 section 3.1)
+type DeviceAuthorizationRequest struct {
+	ClientCredentials
+
+	Scope string `json:"scope,omitempty"`
+}
+
+// TokenRequest is the introspection (RFC 7662 section 2.1) or revocation (RFC 7009 section 2.1) request
+type TokenRequest struct {
+	ClientCredentials
+
+	Token         string `json:"token,omitempty"`
+	TokenTypeHint string `json:"token_type_hint,omitempty"`
+}
+
+// Introspection is the state of the token (RFC 7662 section 2.2). Only Active is set for the inactive token
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Subject   string `json:"sub,omitempty"`
+	Audience  string `json:"aud,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+}
+
+// DeviceAuthorization is the device authorization response (RFC 8628 section 3.2)
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
 }
 
 type Token struct {
 	Token     string `json:"access_token,omitted"`
-	Refresh   string `json:"refresh_token,omitted"`
+	Refresh   string `json:"refresh_token,omitempty"`
 	ExpiresIn int    `json:"expires_in,omitted"`
 	Type      string `json:"token_type,omitted"`
+
+	IssuedTokenType string `json:"issued_token_type,omitempty"` // token exchange
+	IDToken         string `json:"id_token,omitempty"`          // openid scope
 }
 
 type RefreshAccessToken struct {
@@ -17,12 +77,156 @@ type RefreshAccessToken struct {
 	ExpiresIn int    `json:"expires_in,omitted"`
 }
 
+// Consent is the permission that the owner gave to the client
+type Consent struct {
+	ClientID  string `json:"client_id"`
+	Scope     string `json:"scope"`
+	GrantedAt int64  `json:"granted_at"`
+}
+
+// UserInfo describes the card of the token owner
+type UserInfo struct {
+	ID             string `json:"resource_owner_virgil_card_id"`
+	Identity       string `json:"identity"`
+	IdentityType   string `json:"identity_type,omitempty"`
+	CreatedAt      int64  `json:"created_at"`
+	PreviousCardID string `json:"previous_card_id,omitempty"`
+	Fingerprint    string `json:"public_key_fingerprint"`
+}
+
 type OwnerCard struct {
-	ID    string `json:"resource_owner_virgil_card_id"`
-	Scope string `json:"-"`
+	ID       string `json:"resource_owner_virgil_card_id"`
+	Identity string `json:"identity,omitempty"` // the handshake by identity is answered by any of the owner's cards
+	RawCard  string `json:"raw_card,omitempty"` // the exported card, it's used if the Cards service doesn't have it yet
+	Scope    string `json:"scope,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+
+	CodeChallenge       string `json:"code_challenge,omitempty"`
+	CodeChallengeMethod string `json:"code_challenge_method,omitempty"`
+
+	RedirectURI string `json:"redirect_uri,omitempty"`
+	State       string `json:"state,omitempty"`
+
+	ChallengeMethod string `json:"challenge_method,omitempty"`
+
+	UserCode string `json:"user_code,omitempty"` // the handshake approves the device authorization
+	Nonce    string `json:"nonce,omitempty"`     // OpenID Connect nonce that is put into the id_token
+	Consent  bool   `json:"consent,omitempty"`   // the owner agreed to grant the scope to the client
+
+	// verified exchanged tokens
+	Audience string `json:"aud,omitempty"`
+	Actor    *Actor `json:"act,omitempty"`
+
+	Authority string `json:"authority,omitempty"` // the authority that signed the owner card of the verified token
+}
+
+// Actor is the client that acts on behalf of the owner with an exchanged token (RFC 8693 section 4.1)
+type Actor struct {
+	ClientID string `json:"sub"`
+	Actor    *Actor `json:"act,omitempty"`
+}
+
+// NewActor makes the act claim of the clients, the current one first
+func NewActor(clients []string) *Actor {
+	if len(clients) == 0 {
+		return nil
+	}
+	return &Actor{ClientID: clients[0], Actor: NewActor(clients[1:])}
+}
+
+// SignatureChallenge is the handshake response of the signature challenge method. The owner signs
+// SignatureChallengeData with the card key.
+type SignatureChallenge struct {
+	AttemptId string `json:"authorization_grant_id"`
+	Challenge string `json:"challenge"`
+	ServiceID string `json:"service_id"`
+}
+
+// AuthorizationRequest is the request of the browser authorization code flow (RFC 6749 section 4.1.1)
+type AuthorizationRequest struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Nonce               string
+
+	Device   bool   // the device verification page (RFC 8628 section 3.3)
+	UserCode string // it's entered by the owner if empty
+}
+
+// AuthorizationCode is the result of the acknowledge. RedirectURI is the client's redirect_uri with the code and
+// the state if the handshake was started by the browser flow.
+type AuthorizationCode struct {
+	Code        string `json:"code"`
+	RedirectURI string `json:"redirect_uri,omitempty"`
 }
 
 type EncryptedMessage struct {
 	AttemptId string `json:"authorization_grant_id"`
 	Message   []byte `json:"encrypted_message"`
+	Signature []byte `json:"signature,omitempty"` // signature challenge method
+}
+
+// ClientMetadata is the client metadata of the dynamic client registration (RFC 7591 section 2)
+type ClientMetadata struct {
+	ClientName              string   `json:"client_name,omitempty"`
+	GrantTypes              []string `json:"grant_types,omitempty"`
+	Scope                   string   `json:"scope,omitempty"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method,omitempty"`
+	PublicKey               []byte   `json:"public_key,omitempty"`       // key for private_key_jwt client assertions
+	AccessTokenTTL          int      `json:"access_token_ttl,omitempty"` // seconds
+	RequirePKCE             bool     `json:"require_pkce,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris,omitempty"`
+	AppCardID               string   `json:"app_card_id,omitempty"`
+	RequireRequestSign      bool     `json:"require_request_sign,omitempty"`
+	ChallengeMethod         string   `json:"challenge_method,omitempty"`
+	ExchangeAudiences       []string `json:"exchange_audiences,omitempty"`
+	SubjectType             string   `json:"subject_type,omitempty"`
+	RequireConsent          bool     `json:"require_consent,omitempty"`
+}
+
+// ClientInformation is the response of the registration and the client configuration endpoints
+// (RFC 7591 section 3.2.1, RFC 7592 section 3)
+type ClientInformation struct {
+	ClientMetadata
+
+	ClientID                string `json:"client_id"`
+	ClientSecret            string `json:"client_secret,omitempty"`
+	ClientIDIssuedAt        int64  `json:"client_id_issued_at"`
+	ClientSecretExpiresAt   int64  `json:"client_secret_expires_at"`
+	RegistrationAccessToken string `json:"registration_access_token,omitempty"`
+	RegistrationClientURI   string `json:"registration_client_uri"`
+}
+
+// ClientRegistrationRequest is a request to the registration or the client configuration endpoint.
+// Token is the initial access token for the registration and the registration access token otherwise.
+type ClientRegistrationRequest struct {
+	Token      string
+	ClientID   string
+	Metadata   ClientMetadata
+	RemoteAddr string
+}
+
+// ServerMetadata is the authorization server metadata (RFC 8414 section 2). Endpoints that the service doesn't serve
+// are omitted.
+type ServerMetadata struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint,omitempty"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	RegistrationEndpoint                       string   `json:"registration_endpoint,omitempty"`
+	DeviceAuthorizationEndpoint                string   `json:"device_authorization_endpoint,omitempty"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint,omitempty"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint,omitempty"`
+	UserInfoEndpoint                           string   `json:"userinfo_endpoint,omitempty"`
+	ScopesSupported                            []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	TokenEndpointAuthSigningAlgValuesSupported []string `json:"token_endpoint_auth_signing_alg_values_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	SubjectTypesSupported                      []string `json:"subject_types_supported,omitempty"`
+	IDTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported,omitempty"`
 }
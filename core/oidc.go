@@ -0,0 +1,41 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// ScopeOpenID requests the id_token (OpenID Connect Core 1.0 section 3.1.2.1)
+const ScopeOpenID = "openid"
+
+// Authentication context class references of the id_token: how the owner proved the possession of the card key
+const (
+	ACRCardEncryption = "urn:virgil:acr:card:encryption"
+	ACRCardSignature  = "urn:virgil:acr:card:signature"
+	ACRCardAssertion  = "urn:virgil:acr:card:assertion"
+)
+
+const (
+	SubjectTypePublic   = "public"
+	SubjectTypePairwise = "pairwise"
+)
+
+// HasScope reports whether the space-separated scope contains the value
+func HasScope(scope, value string) bool {
+	return hasScope(strings.Fields(scope), value)
+}
+
+// ValidSubjectType reports whether the subject type is supported. The empty one is the public one.
+func ValidSubjectType(t string) bool {
+	return t == "" || t == SubjectTypePublic || t == SubjectTypePairwise
+}
+
+// PairwiseSubject is the subject identifier of the card that is different for every client, so clients cannot
+// correlate owners by it (OpenID Connect Core 1.0 section 8.1)
+func PairwiseSubject(secret, clientID, cardID string) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(clientID + "\n" + cardID))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"regexp"
+)
+
+const CodeChallengeMethodS256 = "S256"
+
+// pkceValue is the syntax of the code verifier and the code challenge (RFC 7636 section 4.1)
+var pkceValue = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// ValidCodeChallenge checks the code challenge of the handshake. Only the S256 method is supported.
+func ValidCodeChallenge(challenge, method string) bool {
+	return method == CodeChallengeMethodS256 && pkceValue.MatchString(challenge)
+}
+
+// VerifyCodeChallenge checks the code verifier against the code challenge (RFC 7636 section 4.6)
+func VerifyCodeChallenge(challenge, method, verifier string) bool {
+	if method != CodeChallengeMethodS256 || !pkceValue.MatchString(verifier) {
+		return false
+	}
+	h := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(h[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,26 @@
+package core
+
+// PolicyRequest describes who asks for an authorization grant and what is asked.
+type PolicyRequest struct {
+	CardID   string
+	Identity string
+	Signers  []string // ids of the signers of the resource owner's card
+	ClientID string
+	Scope    string
+}
+
+// PolicyDecision is the result of a policy evaluation.
+type PolicyDecision struct {
+	Allowed  bool
+	Shadow   bool   // the policy works in the shadow mode: the decision is only logged, the grant stays as requested
+	Rule     string // name of the rule that allowed the request
+	Scope    string // scope that was granted
+	TokenTTL int    // access token lifetime in seconds, 0 means the default one
+
+	Authority string // name of the authority that signed the card, it's put into the access token
+	// restrictions of the authority alone, they're enforced in the shadow mode as well
+	AuthorityScope    string
+	AuthorityTokenTTL int
+
+	InlineCards bool // the card sent with the handshake may be used instead of the one of the Cards service
+}
@@ -0,0 +1,66 @@
+package core
+
+import "strings"
+
+const ScopeWildcard = "*"
+
+// ScopeConsents allows the owner's token to list and revoke the owner's consents
+const ScopeConsents = "consents"
+
+// NarrowScope checks the requested space-separated scope against the allowed scopes. A wildcard request is narrowed
+// to the allowed scopes, the wildcard among the allowed scopes permits anything.
+func NarrowScope(requested string, allowed []string) (string, bool) {
+	if hasScope(allowed, ScopeWildcard) {
+		return requested, true
+	}
+	if requested == ScopeWildcard || requested == "" {
+		if len(allowed) == 0 {
+			return "", false
+		}
+		return strings.Join(allowed, " "), true
+	}
+	for _, s := range strings.Fields(requested) {
+		if !hasScope(allowed, s) {
+			return "", false
+		}
+	}
+	return requested, true
+}
+
+// CoversScope reports whether the granted scope includes every scope of the requested one. The wildcard request is
+// covered only by the wildcard.
+func CoversScope(granted, requested string) bool {
+	g := strings.Fields(granted)
+	if hasScope(g, ScopeWildcard) {
+		return true
+	}
+	for _, s := range strings.Fields(requested) {
+		if !hasScope(g, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeScope returns the union of the space-separated scopes
+func MergeScope(a, b string) string {
+	merged := strings.Fields(a)
+	for _, s := range strings.Fields(b) {
+		if !hasScope(merged, s) {
+			merged = append(merged, s)
+		}
+	}
+	if hasScope(merged, ScopeWildcard) {
+		return ScopeWildcard
+	}
+	return strings.Join(merged, " ")
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
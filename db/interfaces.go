@@ -1,24 +1,87 @@
 package db
 
+import "time"
+
 type CodeRepo interface {
 	GetCode(string) (*Code, error)
+	// Use marks the code as used, it returns false if the code was used before or doesn't exist
+	Use(code string) (bool, error)
 }
 
 type CodeMaker interface {
-	Make(ownerID string, scope string) (*Code, error)
+	Make(grant Grant) (*Code, error)
 }
 
 type TokenRepo interface {
-	Make(ownerId string, scope string) (*AccessToken, error)
+	Make(grant Grant) (*AccessToken, error)
 	Get(string) (*AccessToken, error)
 }
+type IDTokenMaker interface {
+	// Make signs the id_token of the grant for the subject identifier
+	Make(grant Grant, subject string) (string, error)
+}
+
 type RefreshRepo interface {
-	Make(ownerId string, scope string) (*RefreshToken, error)
+	Make(grant Grant) (*RefreshToken, error)
 	Get(token string) (*RefreshToken, error)
+	Remove(token string) error
+	// Bind binds the refresh token issued without a client to the client, false is returned if the token is already
+	// bound or doesn't exist
+	Bind(token, clientID string) (bool, error)
+	// RemoveByClient removes the refresh tokens that the client obtained on behalf of the owner
+	RemoveByClient(ownerID, clientID string) error
 }
 
 type AttemptRepo interface {
-	Make(ownerID string, scope string) (*Attempt, error)
+	Make(grant Grant) (*Attempt, error)
 	Get(id string) (*Attempt, error)
 	Remove(id string) error
 }
+
+type DeviceCodeRepo interface {
+	Make(grant Grant) (*DeviceCode, error)
+	GetByUserCode(userCode string) (*DeviceCode, error)
+	// Approve sets the owner of the pending device authorization, it returns false if there is no such one
+	Approve(userCode string, grant Grant) (bool, error)
+	// Poll returns the device authorization as it was before the poll and remembers the poll time
+	Poll(code string) (*DeviceCode, error)
+	SlowDown(code string) error
+	// Use removes the device authorization, it returns false if it was used before
+	Use(code string) (bool, error)
+}
+
+type ClientRepo interface {
+	Get(id string) (*Client, error)
+}
+
+type ClientStore interface {
+	ClientRepo
+	Create(c *Client) error
+	Update(c *Client) error
+	// Remove removes the client, false is returned if the client doesn't exist
+	Remove(id string) (bool, error)
+}
+
+type ConsentRepo interface {
+	Get(ownerID, clientID string) (*Consent, error)
+	// Grant records the consent, the scope is added to the one that the owner granted to the client before
+	Grant(ownerID, clientID, scope string) error
+	List(ownerID string) ([]Consent, error)
+	Remove(ownerID, clientID string) error
+}
+
+type CardStore interface {
+	Get(id string) (*Card, error)
+	// Search returns the cards of the identity including the outdated ones
+	Search(identity string) ([]Card, error)
+	Add(c *Card) error
+	Remove(id string) error
+}
+
+type JTIRepo interface {
+	Use(id string, expired time.Time) (bool, error)
+}
+
+type AuditRepo interface {
+	Log(r *AuditRecord) error
+}
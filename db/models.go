@@ -2,10 +2,59 @@ package db
 
 import "time"
 
+// Grant is what the resource owner was granted by the handshake. It is carried from the attempt to the
+// authorization code and then to the issued tokens.
+type Grant struct {
+	OwnerID  string `bson:"owner_id"`
+	Scope    string `bson:"scope"`
+	ClientID string `bson:"client_id,omitempty"`
+	TokenTTL int    `bson:"token_ttl,omitempty"` // access token lifetime in seconds, 0 means the default one
+
+	// the authority that signed the owner card, it's put into the access tokens
+	Authority string `bson:"authority,omitempty"`
+
+	// PKCE (RFC 7636) binding of the authorization code, it isn't carried to the tokens
+	CodeChallenge       string `bson:"code_challenge,omitempty"`
+	CodeChallengeMethod string `bson:"code_challenge_method,omitempty"`
+
+	// front-channel flow parameters, they aren't carried to the tokens either
+	RedirectURI string `bson:"redirect_uri,omitempty"`
+	State       string `bson:"state,omitempty"`
+
+	// proof of possession of the card key that is expected at the acknowledge
+	ChallengeMethod string `bson:"challenge_method,omitempty"`
+
+	// user code of the device authorization that the handshake approves
+	UserCode string `bson:"user_code,omitempty"`
+
+	// the owner gave the consent to the client with the handshake, it's recorded once the flow completes
+	Consent bool `bson:"consent,omitempty"`
+
+	// cards of the handshake by identity, the one that answers the challenge becomes the owner
+	Candidates []string `bson:"candidates,omitempty"`
+
+	// the card sent with the handshake, it's used to verify the signature at the acknowledge
+	RawCard string `bson:"raw_card,omitempty"`
+	// the owner card was sent with the handshake, it may be missing on the Cards service yet
+	InlineCard bool `bson:"inline_card,omitempty"`
+
+	// token exchange (RFC 8693): the audience of the token and the clients that act on behalf of the owner, the
+	// current one first
+	Audience string   `bson:"audience,omitempty"`
+	Actors   []string `bson:"actors,omitempty"`
+
+	// OpenID Connect: the owner's card identity and how the owner was authenticated, they're put into the id_token
+	Identity     string `bson:"identity,omitempty"`
+	IdentityType string `bson:"identity_type,omitempty"`
+	AuthTime     int64  `bson:"auth_time,omitempty"` // unix time of the acknowledge, 0 if no owner was authenticated
+	Nonce        string `bson:"nonce,omitempty"`
+	ACR          string `bson:"acr,omitempty"`
+}
+
 type Code struct {
+	Grant `bson:",inline"`
+
 	Code    string    `bson:"_id"`
-	OwnerID string    `bson:"owner_id"`
-	Scope   string    `bson:"scope"`
 	Used    bool      `bson:"used"`
 	Expired time.Time `bson:"expired"`
 }
@@ -13,22 +62,119 @@ type Code struct {
 type AccessToken struct {
 	Token     string
 	OwnerID   string
+	ClientID  string
 	Scope     string `bson:"scope"`
+	Audience  string
+	Actors    []string
+	Authority string
 	ExpiresIn int
 	Expired   time.Time
 }
 
 type RefreshToken struct {
+	Grant `bson:",inline"`
+
 	Token   string    `bson:"_id"`
 	Expired time.Time `bson:"expired"`
-	OwnerID string    `bson:"owner_id"`
-	Scope   string    `bson:"scope"`
+}
+
+// DeviceCode is a device authorization (RFC 8628). The owner and the granted scope are set when the owner approves
+// the user code by the handshake.
+type DeviceCode struct {
+	Grant `bson:",inline"`
+
+	Code     string    `bson:"_id"`
+	Approved bool      `bson:"approved"`
+	Interval int       `bson:"interval"` // minimum seconds between polls
+	LastPoll time.Time `bson:"last_poll,omitempty"`
+	Expired  time.Time `bson:"expired"`
 }
 
 type Attempt struct {
+	Grant `bson:",inline"`
+
 	ID      string    `bson:"_id"`
-	OwnerID string    `bson:"owner_id"`
-	Scope   string    `bson:"scope"`
 	Message string    `bson:"msg"`
 	Expired time.Time `bson:"expired"`
 }
+
+// Consent is the permission that the owner gave to the client. There is one consent per owner and client.
+type Consent struct {
+	ID        string    `bson:"_id"`
+	OwnerID   string    `bson:"owner_id"`
+	ClientID  string    `bson:"client_id"`
+	Scope     string    `bson:"scope"`
+	GrantedAt time.Time `bson:"granted_at"`
+}
+
+// Card is a signed raw card kept by the service instead of the Cards service. Raw is the card exported as a base64
+// string or JSON.
+type Card struct {
+	ID             string `bson:"_id"`
+	Identity       string `bson:"identity"`
+	PreviousCardID string `bson:"previous_card_id,omitempty"`
+	Raw            string `bson:"raw"`
+}
+
+// Client is an application registered with the service.
+type Client struct {
+	ID                    string    `bson:"_id"`
+	Name                  string    `bson:"name,omitempty"`
+	SecretHash            string    `bson:"secret_hash,omitempty"` // bcrypt hash of the client secret
+	PublicKey             []byte    `bson:"public_key,omitempty"`  // key for private_key_jwt client assertions
+	Scopes                []string  `bson:"scopes"`
+	GrantTypes            []string  `bson:"grant_types"`
+	AccessTokenTTL        int       `bson:"access_token_ttl,omitempty"` // seconds, 0 means the default one
+	IssueRefreshToken     bool      `bson:"issue_refresh_token"`
+	RequirePKCE           bool      `bson:"require_pkce,omitempty"` // the code exchange requires code_verifier
+	RedirectURIs          []string  `bson:"redirect_uris,omitempty"`
+	ExchangeAudiences     []string  `bson:"exchange_audiences,omitempty"`      // audiences of the token exchange
+	SubjectType           string    `bson:"subject_type,omitempty"`            // sub of id_tokens, public or pairwise
+	RequireConsent        bool      `bson:"require_consent,omitempty"`         // handshakes need the owner's consent
+	AppCardID             string    `bson:"app_card_id,omitempty"`             // Virgil card that signs handshake requests
+	RequireRequestSign    bool      `bson:"require_request_sign,omitempty"`    // handshakes must be signed by the app card
+	ChallengeMethod       string    `bson:"challenge_method,omitempty"`        // default challenge method of handshakes
+	AuthMethod            string    `bson:"auth_method,omitempty"`             // token_endpoint_auth_method of registered clients
+	RegistrationTokenHash string    `bson:"registration_token_hash,omitempty"` // bcrypt hash of the RFC 7592 token
+	CreatedAt             time.Time `bson:"created_at,omitempty"`
+}
+
+// Public reports whether the client has no credentials and is identified by the id only.
+func (c *Client) Public() bool {
+	return c.SecretHash == "" && len(c.PublicKey) == 0 && !c.SignsWithCard()
+}
+
+// SignsWithCard reports whether the client signs its private_key_jwt assertions with the key of the application card.
+func (c *Client) SignsWithCard() bool {
+	return len(c.PublicKey) == 0 && c.AppCardID != "" && c.AuthMethod == "private_key_jwt"
+}
+
+// AllowsRedirectURI reports whether the URI is registered for the client. URIs are compared as strings.
+func (c *Client) AllowsRedirectURI(uri string) bool {
+	for _, v := range c.RedirectURIs {
+		if v == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsGrantType reports whether the client is allowed to use the grant type.
+func (c *Client) AllowsGrantType(grantType string) bool {
+	for _, v := range c.GrantTypes {
+		if v == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditRecord is a record of a change made through the management API
+type AuditRecord struct {
+	ID         string    `bson:"_id"`
+	Time       time.Time `bson:"time"`
+	Action     string    `bson:"action"`
+	ClientID   string    `bson:"client_id"`
+	Actor      string    `bson:"actor"`
+	RemoteAddr string    `bson:"remote_addr,omitempty"`
+}
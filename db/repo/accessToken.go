@@ -9,7 +9,10 @@ import (
 	"gopkg.in/virgil.v5/cryptoapi"
 )
 
-const accessTokenExpiresIn time.Duration = 10 * time.Minute
+const (
+	accessTokenExpiresIn time.Duration = 10 * time.Minute
+	defaultIssuer                      = "Virgil Security, Inc"
+)
 
 type myClaims struct {
 	OwnerID   string `json:"own"`
@@ -21,6 +24,31 @@ type myClaims struct {
 	NotBefore int64  `json:"nbf,omitempty"`
 	Subject   string `json:"sub,omitempty"`
 	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	Actor     *actor `json:"act,omitempty"`
+	Authority string `json:"authority,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+}
+
+// actor is the act claim of exchanged tokens (RFC 8693 section 4.1). The prior actors are nested.
+type actor struct {
+	Subject string `json:"sub"`
+	Actor   *actor `json:"act,omitempty"`
+}
+
+func makeActor(actors []string) *actor {
+	if len(actors) == 0 {
+		return nil
+	}
+	return &actor{Subject: actors[0], Actor: makeActor(actors[1:])}
+}
+
+func (a *actor) list() []string {
+	var l []string
+	for ; a != nil; a = a.Actor {
+		l = append(l, a.Subject)
+	}
+	return l
 }
 
 func (c *myClaims) Valid() error {
@@ -38,16 +66,33 @@ type AccessToken struct {
 	PrivateKey interface{}
 	PublicKey  interface{}
 	Crypto     Crypto
+	Issuer     string
+	Tenant     string // the tokens of other tenants are refused
 }
 
-func (r *AccessToken) Make(ownerId string, scope string) (*db.AccessToken, error) {
+func (r *AccessToken) Make(grant db.Grant) (*db.AccessToken, error) {
+	expiresIn := accessTokenExpiresIn
+	if grant.TokenTTL > 0 {
+		expiresIn = time.Duration(grant.TokenTTL) * time.Second
+	}
+
+	issuer := r.Issuer
+	if issuer == "" {
+		issuer = defaultIssuer
+	}
+
 	iat := time.Now().UTC().Truncate(time.Second)
 	t := jwt.NewWithClaims(SigningMethodVirgilCrypt, &myClaims{
-		OwnerID:   ownerId,
-		Scope:     scope,
-		ExpiresAt: iat.Add(accessTokenExpiresIn).Unix(),
+		OwnerID:   grant.OwnerID,
+		Scope:     grant.Scope,
+		ClientID:  grant.ClientID,
+		Audience:  grant.Audience,
+		Actor:     makeActor(grant.Actors),
+		Authority: grant.Authority,
+		Tenant:    r.Tenant,
+		ExpiresAt: iat.Add(expiresIn).Unix(),
 		IssuedAt:  iat.Unix(),
-		Issuer:    "Virgil Security, Inc",
+		Issuer:    issuer,
 	})
 	tstr, err := t.SignedString(KeyCryptoPair{Crypto: r.Crypto, Key: r.PrivateKey})
 	if err != nil {
@@ -56,10 +101,14 @@ func (r *AccessToken) Make(ownerId string, scope string) (*db.AccessToken, error
 
 	return &db.AccessToken{
 		Token:     tstr,
-		Expired:   iat.Add(accessTokenExpiresIn),
-		ExpiresIn: int(accessTokenExpiresIn.Seconds()),
-		OwnerID:   ownerId,
-		Scope:     scope,
+		Expired:   iat.Add(expiresIn),
+		ExpiresIn: int(expiresIn.Seconds()),
+		OwnerID:   grant.OwnerID,
+		ClientID:  grant.ClientID,
+		Scope:     grant.Scope,
+		Audience:  grant.Audience,
+		Actors:    grant.Actors,
+		Authority: grant.Authority,
 	}, nil
 }
 
@@ -76,6 +125,9 @@ func (r *AccessToken) Get(token string) (*db.AccessToken, error) {
 	if err != nil {
 		return nil, err
 	}
+	if c.Tenant != r.Tenant {
+		return nil, fmt.Errorf("token of tenant %q is refused by tenant %q", c.Tenant, r.Tenant)
+	}
 
 	iat, eat := time.Unix(c.IssuedAt, 0), time.Unix(c.ExpiresAt, 0)
 	return &db.AccessToken{
@@ -83,7 +135,11 @@ func (r *AccessToken) Get(token string) (*db.AccessToken, error) {
 		ExpiresIn: int(eat.Sub(iat).Seconds()),
 		Expired:   eat.UTC(),
 		OwnerID:   c.OwnerID,
+		ClientID:  c.ClientID,
 		Scope:     c.Scope,
+		Audience:  c.Audience,
+		Actors:    c.Actor.list(),
+		Authority: c.Authority,
 	}, nil
 }
 
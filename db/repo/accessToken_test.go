@@ -3,8 +3,10 @@ package repo
 import (
 	"testing"
 
+	"github.com/VirgilSecurity/virgil-services-auth/db"
 	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/stretchr/testify/require"
+	jwtv3 "gopkg.in/dgrijalva/jwt-go.v3"
 
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/virgil.v5/cryptoimpl"
@@ -20,7 +22,7 @@ func TestReversibility(t *testing.T) {
 	kpub, _ := crypto.ExtractPublicKey(kpriv)
 	a := AccessToken{PrivateKey: kpriv, PublicKey: kpub, Crypto: crypto}
 
-	t1, err := a.Make("ownerId", "test_scope")
+	t1, err := a.Make(db.Grant{OwnerID: "ownerId", Scope: "test_scope", ClientID: "client"})
 	require.NoError(t, err)
 	t2, err := a.Get(t1.Token)
 
@@ -28,6 +30,55 @@ func TestReversibility(t *testing.T) {
 	assert.Equal(t, t1, t2)
 }
 
+func TestReversibility_ExchangedToken(t *testing.T) {
+	crypto := cryptoimpl.NewVirgilCrypto()
+	kpriv, _ := crypto.ImportPrivateKey(appPrivateKey, "")
+	kpub, _ := crypto.ExtractPublicKey(kpriv)
+	a := AccessToken{PrivateKey: kpriv, PublicKey: kpub, Crypto: crypto}
+
+	t1, err := a.Make(db.Grant{OwnerID: "ownerId", Scope: "test_scope", ClientID: "gateway", Audience: "mail",
+		Actors: []string{"gateway", "web"}})
+	require.NoError(t, err)
+	t2, err := a.Get(t1.Token)
+
+	require.NoError(t, err)
+	assert.Equal(t, t1, t2)
+}
+
+func TestReversibility_AuthorityClaim(t *testing.T) {
+	crypto := cryptoimpl.NewVirgilCrypto()
+	kpriv, _ := crypto.ImportPrivateKey(appPrivateKey, "")
+	kpub, _ := crypto.ExtractPublicKey(kpriv)
+	a := AccessToken{PrivateKey: kpriv, PublicKey: kpub, Crypto: crypto}
+
+	t1, err := a.Make(db.Grant{OwnerID: "ownerId", Scope: "test_scope", Authority: "partner"})
+	require.NoError(t, err)
+	t2, err := a.Get(t1.Token)
+
+	require.NoError(t, err)
+	assert.Equal(t, "partner", t2.Authority)
+	assert.Equal(t, t1, t2)
+}
+
+func TestGet_TokenOfOtherTenant_ReturnErr(t *testing.T) {
+	crypto := cryptoimpl.NewVirgilCrypto()
+	kpriv, _ := crypto.ImportPrivateKey(appPrivateKey, "")
+	kpub, _ := crypto.ExtractPublicKey(kpriv)
+	acme := AccessToken{PrivateKey: kpriv, PublicKey: kpub, Crypto: crypto, Tenant: "acme"}
+	other := AccessToken{PrivateKey: kpriv, PublicKey: kpub, Crypto: crypto, Tenant: "other"}
+	single := AccessToken{PrivateKey: kpriv, PublicKey: kpub, Crypto: crypto}
+
+	token, err := acme.Make(db.Grant{OwnerID: "ownerId", Scope: "test_scope"})
+	require.NoError(t, err)
+
+	_, err = acme.Get(token.Token)
+	assert.NoError(t, err)
+	_, err = other.Get(token.Token)
+	assert.Error(t, err)
+	_, err = single.Get(token.Token)
+	assert.Error(t, err)
+}
+
 func TestGet_ParsReturnErr_ReturnErr(t *testing.T) {
 	a := AccessToken{}
 	_, err := a.Get("")
@@ -45,3 +96,45 @@ func TestSign_UnsuportedKey_Err(t *testing.T) {
 	_, err := s.Sign("signingString", 12)
 	assert.Equal(t, jwt.ErrInvalidKeyType, err)
 }
+
+func TestMake_GrantTokenTTL_ExpiresInGrantTTL(t *testing.T) {
+	crypto := cryptoimpl.NewVirgilCrypto()
+	kpriv, _ := crypto.ImportPrivateKey(appPrivateKey, "")
+	a := AccessToken{PrivateKey: kpriv, Crypto: crypto}
+
+	token, err := a.Make(db.Grant{OwnerID: "ownerId", Scope: "test_scope", TokenTTL: 60})
+	require.NoError(t, err)
+
+	assert.Equal(t, 60, token.ExpiresIn)
+}
+
+func TestIDTokenMake_ClaimsSigned(t *testing.T) {
+	crypto := cryptoimpl.NewVirgilCrypto()
+	kpriv, _ := crypto.ImportPrivateKey(appPrivateKey, "")
+	kpub, _ := crypto.ExtractPublicKey(kpriv)
+	r := IDToken{PrivateKey: kpriv, Crypto: crypto, Issuer: "auth"}
+
+	token, err := r.Make(db.Grant{
+		OwnerID:      "card",
+		ClientID:     "web",
+		AuthTime:     1500000000,
+		Nonce:        "n-0S6_WzA2Mj",
+		ACR:          "urn:virgil:acr:card:signature",
+		Identity:     "alice@example.com",
+		IdentityType: "email",
+	}, "subject")
+	require.NoError(t, err)
+
+	c := new(idClaims)
+	_, err = jwtv3.ParseWithClaims(token, c, func(*jwtv3.Token) (interface{}, error) {
+		return KeyCryptoPair{Crypto: crypto, Key: kpub}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "auth", c.Issuer)
+	assert.Equal(t, "subject", c.Subject)
+	assert.Equal(t, "web", c.Audience)
+	assert.Equal(t, int64(1500000000), c.AuthTime)
+	assert.Equal(t, "n-0S6_WzA2Mj", c.Nonce)
+	assert.Equal(t, "alice@example.com", c.Identity)
+	assert.Equal(t, "email", c.IdentityType)
+}
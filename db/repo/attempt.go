@@ -17,13 +17,12 @@ type Attempt struct {
 	C *mgo.Collection
 }
 
-func (r *Attempt) Make(ownerID string, scope string) (*db.Attempt, error) {
+func (r *Attempt) Make(grant db.Grant) (*db.Attempt, error) {
 	b := make([]byte, 32)
 	rand.Read(b)
 
 	a := &db.Attempt{
-		OwnerID: ownerID,
-		Scope:   scope,
+		Grant:   grant,
 		Expired: time.Now().Add(AttemptExpiresIn),
 		Message: base64.RawURLEncoding.EncodeToString(b),
 		ID:      bson.NewObjectId().Hex(),
@@ -0,0 +1,18 @@
+package repo
+
+import (
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type Audit struct {
+	C *mgo.Collection
+}
+
+func (r *Audit) Log(rec *db.AuditRecord) error {
+	if rec.ID == "" {
+		rec.ID = bson.NewObjectId().Hex()
+	}
+	return r.C.Insert(rec)
+}
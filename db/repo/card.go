@@ -0,0 +1,159 @@
+package repo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/virgil.v5/cryptoapi"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+const cardFileExt = ".card"
+
+// ParseCard parses the card exported as a base64 string or JSON. The card isn't verified.
+func ParseCard(crypto cryptoapi.CardCrypto, raw string, outdated bool) (*virgil.Card, error) {
+	raw = strings.TrimSpace(raw)
+
+	var (
+		model *virgil.RawSignedModel
+		err   error
+	)
+	if strings.HasPrefix(raw, "{") {
+		model, err = virgil.GenerateRawSignedModelFromJson(raw)
+	} else {
+		model, err = virgil.GenerateRawSignedModelFromString(raw)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse raw card: %v", err)
+	}
+	return virgil.ParseRawCard(crypto, model, outdated)
+}
+
+// Card keeps the raw cards in the collection
+type Card struct {
+	C *mgo.Collection
+}
+
+func (r *Card) Get(id string) (*db.Card, error) {
+	c := new(db.Card)
+	err := r.C.FindId(id).One(c)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *Card) Search(identity string) ([]db.Card, error) {
+	var list []db.Card
+	err := r.C.Find(bson.M{"identity": identity}).All(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Add adds the card, the card imported before is replaced
+func (r *Card) Add(c *db.Card) error {
+	_, err := r.C.UpsertId(c.ID, c)
+	return err
+}
+
+// Remove removes the card, the missing one isn't an error
+func (r *Card) Remove(id string) error {
+	err := r.C.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// CardDir keeps the raw cards in the files {card id}.card of the directory, e.g. the cards exported by the Virgil
+// CLI can be copied there. A card file that cannot be read is logged and skipped by the search.
+type CardDir struct {
+	Path   string
+	Crypto cryptoapi.CardCrypto
+	Logger Logger
+}
+
+func (r *CardDir) Get(id string) (*db.Card, error) {
+	if id == "" || filepath.Base(id) != id {
+		return nil, nil
+	}
+	c, err := r.read(id + cardFileExt)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return c, err
+}
+
+func (r *CardDir) Search(identity string) ([]db.Card, error) {
+	files, err := ioutil.ReadDir(r.Path)
+	if err != nil {
+		return nil, err
+	}
+	var list []db.Card
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != cardFileExt {
+			continue
+		}
+		c, err := r.read(f.Name())
+		if err != nil {
+			if r.Logger != nil {
+				r.Logger.Printf("Card dir[Search %v]: %v", identity, err)
+			}
+			continue
+		}
+		if c.Identity == identity {
+			list = append(list, *c)
+		}
+	}
+	return list, nil
+}
+
+// Add writes the card file, the file of the card imported before is replaced
+func (r *CardDir) Add(c *db.Card) error {
+	return ioutil.WriteFile(filepath.Join(r.Path, c.ID+cardFileExt), []byte(c.Raw), 0644)
+}
+
+// Remove removes the card file, the missing one isn't an error
+func (r *CardDir) Remove(id string) error {
+	if id == "" || filepath.Base(id) != id {
+		return nil
+	}
+	err := os.Remove(filepath.Join(r.Path, id+cardFileExt))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *CardDir) read(name string) (*db.Card, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(r.Path, name))
+	if err != nil {
+		return nil, err
+	}
+	card, err := ParseCard(r.Crypto, string(raw), false)
+	if err != nil {
+		return nil, fmt.Errorf("card file %v: %v", name, err)
+	}
+	return &db.Card{
+		ID:             card.Id,
+		Identity:       card.Identity,
+		PreviousCardID: card.PreviousCardId,
+		Raw:            string(raw),
+	}, nil
+}
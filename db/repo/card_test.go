@@ -0,0 +1,133 @@
+package repo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/virgil.v5/cryptoimpl"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+var cardCrypto = &cryptoimpl.VirgilCardCrypto{Crypto: cryptoimpl.NewVirgilCrypto()}
+
+func exportedCard(t *testing.T, identity, previousCardID string) (string, string) {
+	kp, err := cardCrypto.Crypto.GenerateKeypair()
+	require.NoError(t, err)
+	model, err := virgil.GenerateRawCard(cardCrypto, &virgil.CardParams{
+		Identity:       identity,
+		PublicKey:      kp.PublicKey(),
+		PrivateKey:     kp.PrivateKey(),
+		PreviousCardId: previousCardID,
+	}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, (&virgil.ModelSigner{Crypto: cardCrypto}).SelfSign(model, kp.PrivateKey(), nil))
+
+	raw, err := model.ExportAsBase64EncodedString()
+	require.NoError(t, err)
+	card, err := virgil.ParseRawCard(cardCrypto, model, false)
+	require.NoError(t, err)
+	return card.Id, raw
+}
+
+func cardDir(t *testing.T) (*CardDir, func()) {
+	path, err := ioutil.TempDir("", "cards")
+	require.NoError(t, err)
+	return &CardDir{Path: path, Crypto: cardCrypto}, func() { os.RemoveAll(path) }
+}
+
+func TestParseCard_JSONAndBase64Parsed(t *testing.T) {
+	id, raw := exportedCard(t, "alice", "")
+	model, err := virgil.GenerateRawSignedModelFromString(raw)
+	require.NoError(t, err)
+	js, err := model.ExportAsJson()
+	require.NoError(t, err)
+
+	for _, v := range []string{raw, string(js), raw + "\n"} {
+		card, err := ParseCard(cardCrypto, v, true)
+
+		require.NoError(t, err)
+		assert.Equal(t, id, card.Id)
+		assert.Equal(t, "alice", card.Identity)
+		assert.True(t, card.IsOutdated)
+	}
+}
+
+func TestCardDir_AddedCard_Found(t *testing.T) {
+	r, cleanup := cardDir(t)
+	defer cleanup()
+	id, raw := exportedCard(t, "alice", "")
+	newID, newRaw := exportedCard(t, "alice", id)
+	_, bobRaw := exportedCard(t, "bob", "")
+
+	for _, v := range []string{raw, newRaw, bobRaw} {
+		card, err := ParseCard(cardCrypto, v, false)
+		require.NoError(t, err)
+		require.NoError(t, r.Add(&db.Card{ID: card.Id, Identity: card.Identity, Raw: v}))
+	}
+	ioutil.WriteFile(filepath.Join(r.Path, "README"), []byte("not a card"), 0644)
+
+	c, err := r.Get(newID)
+	require.NoError(t, err)
+	assert.Equal(t, &db.Card{ID: newID, Identity: "alice", PreviousCardID: id, Raw: newRaw}, c)
+
+	list, err := r.Search("alice")
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+}
+
+func TestCardDir_MissingCard_ReturnNil(t *testing.T) {
+	r, cleanup := cardDir(t)
+	defer cleanup()
+
+	for _, id := range []string{"missing", "../cards", ""} {
+		c, err := r.Get(id)
+		assert.NoError(t, err)
+		assert.Nil(t, c)
+	}
+}
+
+func TestCardDir_Remove_CardFileRemoved(t *testing.T) {
+	r, cleanup := cardDir(t)
+	defer cleanup()
+	id, raw := exportedCard(t, "alice", "")
+	require.NoError(t, r.Add(&db.Card{ID: id, Identity: "alice", Raw: raw}))
+
+	require.NoError(t, r.Remove(id))
+	require.NoError(t, r.Remove(id))
+
+	c, err := r.Get(id)
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+// countingLogger counts the logged messages
+type countingLogger struct {
+	count int
+}
+
+func (l *countingLogger) Printf(format string, args ...interface{}) {
+	l.count++
+}
+
+func TestCardDir_BrokenCardFile_Skipped(t *testing.T) {
+	r, cleanup := cardDir(t)
+	defer cleanup()
+	l := new(countingLogger)
+	r.Logger = l
+	id, raw := exportedCard(t, "alice", "")
+	require.NoError(t, r.Add(&db.Card{ID: id, Identity: "alice", Raw: raw}))
+	ioutil.WriteFile(filepath.Join(r.Path, "broken.card"), []byte("not a card"), 0644)
+
+	list, err := r.Search("alice")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, id, list[0].ID)
+	assert.Equal(t, 1, l.count)
+}
@@ -0,0 +1,41 @@
+package repo
+
+import (
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	mgo "gopkg.in/mgo.v2"
+)
+
+type Client struct {
+	C *mgo.Collection
+}
+
+func (r *Client) Get(id string) (*db.Client, error) {
+	c := new(db.Client)
+	err := r.C.FindId(id).One(c)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *Client) Create(c *db.Client) error {
+	return r.C.Insert(c)
+}
+
+func (r *Client) Update(c *db.Client) error {
+	return r.C.UpdateId(c.ID, c)
+}
+
+func (r *Client) Remove(id string) (bool, error) {
+	err := r.C.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
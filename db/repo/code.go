@@ -25,16 +25,25 @@ func (r *Code) GetCode(code string) (*db.Code, error) {
 	if err != nil {
 		return nil, err
 	}
-	r.C.UpdateId(code, bson.M{"used": true})
-
 	return c, nil
 }
-func (r *Code) Make(ownerID string, scope string) (*db.Code, error) {
+
+// Use marks the code as used. The update is conditional, so only one of the concurrent exchanges wins.
+func (r *Code) Use(code string) (bool, error) {
+	err := r.C.Update(bson.M{"_id": code, "used": false}, bson.M{"$set": bson.M{"used": true}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+func (r *Code) Make(grant db.Grant) (*db.Code, error) {
 	b := make([]byte, 32)
 	rand.Read(b)
 	c := &db.Code{
-		OwnerID: ownerID,
-		Scope:   scope,
+		Grant:   grant,
 		Expired: time.Now().Add(CodeExpiresIn),
 		Code:    base64.RawURLEncoding.EncodeToString(b),
 	}
@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+// Consent keeps the consents of owners to clients. The id of a consent is made of the owner and the client ids.
+type Consent struct {
+	C *mgo.Collection
+}
+
+func consentID(ownerID, clientID string) string {
+	return ownerID + " " + clientID
+}
+
+func (r *Consent) Get(ownerID, clientID string) (*db.Consent, error) {
+	c := new(db.Consent)
+	err := r.C.FindId(consentID(ownerID, clientID)).One(c)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (r *Consent) Grant(ownerID, clientID, scope string) error {
+	c, err := r.Get(ownerID, clientID)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		scope = core.MergeScope(c.Scope, scope)
+	}
+	_, err = r.C.UpsertId(consentID(ownerID, clientID), &db.Consent{
+		ID:        consentID(ownerID, clientID),
+		OwnerID:   ownerID,
+		ClientID:  clientID,
+		Scope:     scope,
+		GrantedAt: time.Now().UTC(),
+	})
+	return err
+}
+
+func (r *Consent) List(ownerID string) ([]db.Consent, error) {
+	var list []db.Consent
+	err := r.C.Find(bson.M{"owner_id": ownerID}).Sort("client_id").All(&list)
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Remove removes the consent, the missing one isn't an error
+func (r *Consent) Remove(ownerID, clientID string) error {
+	err := r.C.RemoveId(consentID(ownerID, clientID))
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+package repo
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"time"
+
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	DeviceCodeExpiresIn time.Duration = 10 * time.Minute
+	DeviceCodeInterval                = 5 // seconds
+	DeviceCodeSlowDown                = 5 // seconds added to the interval on too frequent polls
+
+	// userCodeAlphabet has no vowels to avoid words and no similar looking characters (RFC 8628 section 6.1)
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+	makeAttempts     = 3
+)
+
+// DeviceCode keeps device authorizations. The user_code field must have the unique index, see EnsureIndexes.
+type DeviceCode struct {
+	C *mgo.Collection
+}
+
+// EnsureIndexes creates the unique index on user_code, so a clashing user code is made again and a user code finds
+// a single device
+func (r *DeviceCode) EnsureIndexes() error {
+	return r.C.EnsureIndex(mgo.Index{Key: []string{"user_code"}, Unique: true})
+}
+
+func (r *DeviceCode) Make(grant db.Grant) (*db.DeviceCode, error) {
+	var err error
+	for i := 0; i < makeAttempts; i++ {
+		b := make([]byte, 32)
+		rand.Read(b)
+		grant.UserCode = userCode()
+		d := &db.DeviceCode{
+			Grant:    grant,
+			Code:     base64.RawURLEncoding.EncodeToString(b),
+			Interval: DeviceCodeInterval,
+			Expired:  time.Now().Add(DeviceCodeExpiresIn),
+		}
+		err = r.C.Insert(d)
+		if err == nil {
+			return d, nil
+		}
+		// the user code is short, it can clash with a pending one
+		if !mgo.IsDup(err) {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+func (r *DeviceCode) GetByUserCode(userCode string) (*db.DeviceCode, error) {
+	d := new(db.DeviceCode)
+	err := r.C.Find(bson.M{"user_code": userCode}).One(d)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (r *DeviceCode) Approve(userCode string, grant db.Grant) (bool, error) {
+	err := r.C.Update(bson.M{
+		"user_code": userCode,
+		"approved":  false,
+		"expired":   bson.M{"$gt": time.Now()},
+	}, bson.M{"$set": bson.M{
+		"owner_id":      grant.OwnerID,
+		"scope":         grant.Scope,
+		"token_ttl":     grant.TokenTTL,
+		"authority":     grant.Authority,
+		"identity":      grant.Identity,
+		"identity_type": grant.IdentityType,
+		"auth_time":     grant.AuthTime,
+		"acr":           grant.ACR,
+		"approved":      true,
+	}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *DeviceCode) Poll(code string) (*db.DeviceCode, error) {
+	d := new(db.DeviceCode)
+	_, err := r.C.FindId(code).Apply(mgo.Change{
+		Update: bson.M{"$set": bson.M{"last_poll": time.Now()}},
+	}, d)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (r *DeviceCode) SlowDown(code string) error {
+	return r.C.UpdateId(code, bson.M{"$inc": bson.M{"interval": DeviceCodeSlowDown}})
+}
+
+func (r *DeviceCode) Use(code string) (bool, error) {
+	err := r.C.RemoveId(code)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func userCode() string {
+	// bytes above the largest multiple of the alphabet length are skipped, so every letter is equally likely
+	max := 256 - 256%len(userCodeAlphabet)
+	code := make([]byte, 0, userCodeLength)
+	b := make([]byte, 1)
+	for len(code) < userCodeLength {
+		rand.Read(b)
+		if int(b[0]) < max {
+			code = append(code, userCodeAlphabet[int(b[0])%len(userCodeAlphabet)])
+		}
+	}
+	return string(code)
+}
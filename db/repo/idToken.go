@@ -0,0 +1,57 @@
+package repo
+
+import (
+	"time"
+
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+)
+
+const idTokenExpiresIn time.Duration = 10 * time.Minute
+
+// idClaims are the claims of the id_token (OpenID Connect Core 1.0 section 2). identity and identity_type are
+// the ones of the owner's card.
+type idClaims struct {
+	Issuer       string `json:"iss"`
+	Subject      string `json:"sub"`
+	Audience     string `json:"aud"`
+	ExpiresAt    int64  `json:"exp"`
+	IssuedAt     int64  `json:"iat"`
+	AuthTime     int64  `json:"auth_time,omitempty"`
+	Nonce        string `json:"nonce,omitempty"`
+	ACR          string `json:"acr,omitempty"`
+	Identity     string `json:"identity,omitempty"`
+	IdentityType string `json:"identity_type,omitempty"`
+}
+
+func (c *idClaims) Valid() error {
+	return nil
+}
+
+// IDToken signs id_tokens with the service key as access tokens are signed
+type IDToken struct {
+	PrivateKey interface{}
+	Crypto     Crypto
+	Issuer     string
+}
+
+func (r *IDToken) Make(grant db.Grant, subject string) (string, error) {
+	issuer := r.Issuer
+	if issuer == "" {
+		issuer = defaultIssuer
+	}
+	iat := time.Now().UTC().Truncate(time.Second)
+	t := jwt.NewWithClaims(SigningMethodVirgilCrypt, &idClaims{
+		Issuer:       issuer,
+		Subject:      subject,
+		Audience:     grant.ClientID,
+		ExpiresAt:    iat.Add(idTokenExpiresIn).Unix(),
+		IssuedAt:     iat.Unix(),
+		AuthTime:     grant.AuthTime,
+		Nonce:        grant.Nonce,
+		ACR:          grant.ACR,
+		Identity:     grant.Identity,
+		IdentityType: grant.IdentityType,
+	})
+	return t.SignedString(KeyCryptoPair{Crypto: r.Crypto, Key: r.PrivateKey})
+}
@@ -0,0 +1,30 @@
+package repo
+
+import (
+	"time"
+
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// JTI remembers ids of used assertions until they expire
+type JTI struct {
+	C *mgo.Collection
+}
+
+// EnsureIndexes creates the TTL index that removes the ids once they expire
+func (r *JTI) EnsureIndexes() error {
+	return r.C.EnsureIndex(mgo.Index{Key: []string{"expired"}, ExpireAfter: time.Second})
+}
+
+// Use marks the id as used. It returns false if the id was used before.
+func (r *JTI) Use(id string, expired time.Time) (bool, error) {
+	err := r.C.Insert(bson.M{"_id": id, "expired": expired})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -7,19 +7,19 @@ import (
 
 	"github.com/VirgilSecurity/virgil-services-auth/db"
 	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type Refresh struct {
 	C *mgo.Collection
 }
 
-func (r *Refresh) Make(ownerId string, scope string) (*db.RefreshToken, error) {
+func (r *Refresh) Make(grant db.Grant) (*db.RefreshToken, error) {
 	b := make([]byte, 32)
 	rand.Read(b)
 
 	t := &db.RefreshToken{
-		OwnerID: ownerId,
-		Scope:   scope,
+		Grant:   grant,
 		Expired: time.Date(2999, 1, 1, 0, 0, 0, 0, time.UTC),
 		Token:   base64.RawURLEncoding.EncodeToString(b),
 	}
@@ -40,3 +40,30 @@ func (r *Refresh) Get(token string) (*db.RefreshToken, error) {
 	}
 	return t, nil
 }
+
+func (r *Refresh) Remove(token string) error {
+	err := r.C.RemoveId(token)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+func (r *Refresh) Bind(token, clientID string) (bool, error) {
+	err := r.C.Update(
+		bson.M{"_id": token, "client_id": bson.M{"$in": []interface{}{nil, ""}}},
+		bson.M{"$set": bson.M{"client_id": clientID}},
+	)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *Refresh) RemoveByClient(ownerID, clientID string) error {
+	_, err := r.C.RemoveAll(bson.M{"owner_id": ownerID, "client_id": clientID})
+	return err
+}
@@ -0,0 +1,25 @@
+package http
+
+import (
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+// Admin middleware between http and the operator calls. The admin token is passed in the Bearer authorization
+// header.
+type Admin struct {
+	Handler core.AdminHandler
+}
+
+func (a *Admin) PurgeCard(cardID string, ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	a.Handler.PurgeCard(resp, bearerToken(ctx), cardID)
+}
+
+func (a *Admin) Fingerprints(ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	a.Handler.Fingerprints(resp, bearerToken(ctx))
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type FakeAdminService struct {
+	mock.Mock
+}
+
+func (s *FakeAdminService) PurgeCard(resp core.Response, token string, cardID string) {
+	s.Called(resp, token, cardID)
+}
+
+func (s *FakeAdminService) Fingerprints(resp core.Response, token string) {
+	s.Called(resp, token)
+}
+
+func TestAdmin_PurgeCard_Routed(t *testing.T) {
+	s := new(FakeAdminService)
+	s.On("PurgeCard", mock.Anything, "token", "card").Once()
+
+	router := &Router{Admin: &Admin{Handler: s}}
+	router.Handler(consentRequest("DELETE", "/v5/admin/cards/card"))
+
+	s.AssertExpectations(t)
+}
+
+func TestAdmin_Fingerprints_Routed(t *testing.T) {
+	s := new(FakeAdminService)
+	s.On("Fingerprints", mock.Anything, "token").Once()
+
+	router := &Router{Admin: &Admin{Handler: s}}
+	router.Handler(consentRequest("GET", "/v5/admin/fingerprints"))
+
+	s.AssertExpectations(t)
+}
+
+func TestAdmin_WrongRequest_Rejected(t *testing.T) {
+	table := map[string]int{
+		"POST /v5/admin/fingerprints": fasthttp.StatusMethodNotAllowed,
+		"GET /v5/admin/cards/card":    fasthttp.StatusMethodNotAllowed,
+		"DELETE /v5/admin/cards/":     fasthttp.StatusNotFound,
+		"DELETE /v5/admin/cards/a/b":  fasthttp.StatusNotFound,
+		"DELETE /v5/admin/clients/a":  fasthttp.StatusNotFound,
+	}
+	router := &Router{Admin: &Admin{Handler: new(FakeAdminService)}}
+	for req, status := range table {
+		parts := strings.SplitN(req, " ", 2)
+		r := consentRequest(parts[0], parts[1])
+		router.Handler(r)
+
+		assert.Equal(t, status, r.Response.StatusCode(), req)
+	}
+}
+
+func TestAdmin_Disabled_Return404(t *testing.T) {
+	r := consentRequest("DELETE", "/v5/admin/cards/card")
+	(&Router{}).Handler(r)
+
+	assert.Equal(t, fasthttp.StatusNotFound, r.Response.StatusCode())
+}
@@ -1,7 +1,10 @@
 package http
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"net/url"
 
 	"github.com/VirgilSecurity/virgil-services-auth/core"
 	"github.com/valyala/fasthttp"
@@ -20,11 +23,14 @@ func (c *Auth) AccessToken(ctx *fasthttp.RequestCtx) {
 		resp.Error(core.StatusErrorCodeNotFound)
 		return
 	}
+	basicAuth(ctx, &ac.ClientCredentials)
 
 	c.Handler.AccessToken(resp, ac)
 }
 
 type refreshToken struct {
+	core.ClientCredentials
+
 	Refresh   string `json:"refresh_token,omitted"`
 	GrantType string `json:"grant_type,omitted"`
 }
@@ -38,7 +44,45 @@ func (c *Auth) Refresh(ctx *fasthttp.RequestCtx) {
 		resp.Error(core.StatusErrorRefreshTokenNotFound)
 		return
 	}
-	c.Handler.Refresh(resp, t.GrantType, t.Refresh)
+	basicAuth(ctx, &t.ClientCredentials)
+
+	c.Handler.Refresh(resp, t.GrantType, t.Refresh, t.ClientCredentials)
+}
+
+func (c *Auth) DeviceAuthorization(ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	var req core.DeviceAuthorizationRequest
+	err := json.Unmarshal(ctx.PostBody(), &req)
+	if err != nil {
+		resp.Error(core.StatusErrorClientAuthenticationFailed)
+		return
+	}
+	basicAuth(ctx, &req.ClientCredentials)
+
+	c.Handler.DeviceAuthorization(resp, req)
+}
+
+func (c *Auth) Introspect(ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	req, err := tokenRequest(ctx)
+	if err != nil {
+		resp.Error(core.StatusErrorClientAuthenticationFailed)
+		return
+	}
+	c.Handler.Introspect(resp, req)
+}
+
+func (c *Auth) Revoke(ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	req, err := tokenRequest(ctx)
+	if err != nil {
+		resp.Error(core.StatusErrorClientAuthenticationFailed)
+		return
+	}
+	c.Handler.Revoke(resp, req)
 }
 
 func (c *Auth) Verify(ctx *fasthttp.RequestCtx) {
@@ -52,3 +96,49 @@ func (c *Auth) Verify(ctx *fasthttp.RequestCtx) {
 	}
 	c.Handler.Verify(resp, t.Token)
 }
+
+// tokenRequest reads the introspection or revocation request. The RFC 7662 and RFC 7009 clients send the form, other
+// ones may send JSON as to the rest of the API.
+func tokenRequest(ctx *fasthttp.RequestCtx) (core.TokenRequest, error) {
+	var req core.TokenRequest
+	if bytes.HasPrefix(ctx.Request.Header.ContentType(), []byte("application/x-www-form-urlencoded")) {
+		args := ctx.PostArgs()
+		req.Token = string(args.Peek("token"))
+		req.TokenTypeHint = string(args.Peek("token_type_hint"))
+		req.ID = string(args.Peek("client_id"))
+		req.Secret = string(args.Peek("client_secret"))
+		req.AssertionType = string(args.Peek("client_assertion_type"))
+		req.Assertion = string(args.Peek("client_assertion"))
+	} else if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		return req, err
+	}
+	basicAuth(ctx, &req.ClientCredentials)
+	return req, nil
+}
+
+// basicAuth takes the client credentials from the HTTP Basic authorization header (RFC 6749 section 2.3.1).
+// The header has priority over the credentials in the request body.
+func basicAuth(ctx *fasthttp.RequestCtx, cred *core.ClientCredentials) {
+	const prefix = "Basic "
+	h := ctx.Request.Header.Peek("Authorization")
+	if !bytes.HasPrefix(h, []byte(prefix)) {
+		return
+	}
+	b, err := base64.StdEncoding.DecodeString(string(h[len(prefix):]))
+	if err != nil {
+		return
+	}
+	i := bytes.IndexByte(b, ':')
+	if i < 0 {
+		return
+	}
+	id, err := url.QueryUnescape(string(b[:i]))
+	if err != nil {
+		return
+	}
+	secret, err := url.QueryUnescape(string(b[i+1:]))
+	if err != nil {
+		return
+	}
+	cred.ID, cred.Secret = id, secret
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"encoding/base64"
 	"testing"
 
 	"github.com/VirgilSecurity/virgil-services-auth/core"
@@ -15,14 +16,26 @@ func (s *FakeAuthService) AccessToken(resp core.Response, m core.AccessCode) {
 	s.Called(resp, m)
 }
 
-func (s *FakeAuthService) Refresh(resp core.Response, grantType string, token string) {
-	s.Called(resp, grantType, token)
+func (s *FakeAuthService) Refresh(resp core.Response, grantType string, token string, client core.ClientCredentials) {
+	s.Called(resp, grantType, token, client)
 }
 
 func (s *FakeAuthService) Verify(resp core.Response, token string) {
 	s.Called(resp, token)
 }
 
+func (s *FakeAuthService) DeviceAuthorization(resp core.Response, req core.DeviceAuthorizationRequest) {
+	s.Called(resp, req)
+}
+
+func (s *FakeAuthService) Introspect(resp core.Response, req core.TokenRequest) {
+	s.Called(resp, req)
+}
+
+func (s *FakeAuthService) Revoke(resp core.Response, req core.TokenRequest) {
+	s.Called(resp, req)
+}
+
 func TestAccessToken_BodyIncorrect_ReturnErr(t *testing.T) {
 	r := makeRequestCtx("asdf,sa")
 	c := &Auth{}
@@ -47,6 +60,44 @@ func TestAccessToken_MethodInvoked(t *testing.T) {
 	s.AssertExpectations(t)
 }
 
+func TestAccessToken_BasicAuth_ClientCredentialsFromHeader(t *testing.T) {
+	r := makeRequestCtx(core.AccessCode{
+		ClientCredentials: core.ClientCredentials{ID: "body client", Secret: "body secret"},
+		GrantType:         "type",
+		Code:              "code",
+	})
+	// client id and secret are form-urlencoded before the encoding into base64
+	r.Request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("my+client:s%3Acret")))
+
+	s := new(FakeAuthService)
+	s.On("AccessToken", mock.Anything, core.AccessCode{
+		ClientCredentials: core.ClientCredentials{ID: "my client", Secret: "s:cret"},
+		GrantType:         "type",
+		Code:              "code",
+	}).Once()
+
+	g := Auth{Handler: s}
+	g.AccessToken(r)
+
+	s.AssertExpectations(t)
+}
+
+func TestDeviceAuthorization_BasicAuth_MethodInvoked(t *testing.T) {
+	r := makeRequestCtx(core.DeviceAuthorizationRequest{Scope: "cards"})
+	r.Request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("tv:secret")))
+
+	s := new(FakeAuthService)
+	s.On("DeviceAuthorization", mock.Anything, core.DeviceAuthorizationRequest{
+		ClientCredentials: core.ClientCredentials{ID: "tv", Secret: "secret"},
+		Scope:             "cards",
+	}).Once()
+
+	g := Auth{Handler: s}
+	g.DeviceAuthorization(r)
+
+	s.AssertExpectations(t)
+}
+
 func TestRefresh_BodyIncorrect_ReturnErr(t *testing.T) {
 	r := makeRequestCtx("asdf,sa")
 	c := new(Auth)
@@ -59,10 +110,12 @@ func TestRefresh_MethodInvoked(t *testing.T) {
 	tk := map[string]string{
 		"grant_type":    "type",
 		"refresh_token": "refresh",
+		"client_id":     "client",
+		"client_secret": "secret",
 	}
 	r := makeRequestCtx(tk)
 	s := new(FakeAuthService)
-	s.On("Refresh", mock.Anything, "type", "refresh").Once()
+	s.On("Refresh", mock.Anything, "type", "refresh", core.ClientCredentials{ID: "client", Secret: "secret"}).Once()
 
 	g := Auth{Handler: s}
 	g.Refresh(r)
@@ -90,3 +143,45 @@ func TestVerify_MethodInvoked(t *testing.T) {
 
 	s.AssertExpectations(t)
 }
+
+func TestIntrospect_Form_MethodInvoked(t *testing.T) {
+	r := makeRequestCtx("token=access&token_type_hint=access_token")
+	r.Request.Header.SetContentType("application/x-www-form-urlencoded")
+	r.Request.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("api:secret")))
+
+	s := new(FakeAuthService)
+	s.On("Introspect", mock.Anything, core.TokenRequest{
+		ClientCredentials: core.ClientCredentials{ID: "api", Secret: "secret"},
+		Token:             "access",
+		TokenTypeHint:     "access_token",
+	}).Once()
+
+	g := Auth{Handler: s}
+	g.Introspect(r)
+
+	s.AssertExpectations(t)
+}
+
+func TestIntrospect_BodyIncorrect_ReturnErr(t *testing.T) {
+	r := makeRequestCtx("asdf,sa")
+	c := &Auth{}
+	c.Introspect(r)
+
+	assertResponse(t, core.StatusErrorClientAuthenticationFailed, r)
+}
+
+func TestRevoke_JSON_MethodInvoked(t *testing.T) {
+	req := core.TokenRequest{
+		ClientCredentials: core.ClientCredentials{ID: "client", Secret: "secret"},
+		Token:             "refresh",
+	}
+	r := makeRequestCtx(req)
+
+	s := new(FakeAuthService)
+	s.On("Revoke", mock.Anything, req).Once()
+
+	g := Auth{Handler: s}
+	g.Revoke(r)
+
+	s.AssertExpectations(t)
+}
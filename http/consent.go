@@ -0,0 +1,25 @@
+package http
+
+import (
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+// Consents middleware between http and the consent service. The owner's access token is passed in the Bearer
+// authorization header.
+type Consents struct {
+	Handler core.ConsentHandler
+}
+
+func (c *Consents) List(ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	c.Handler.List(resp, bearerToken(ctx))
+}
+
+func (c *Consents) Revoke(clientID string, ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	c.Handler.Revoke(resp, bearerToken(ctx), clientID)
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type FakeConsentService struct {
+	mock.Mock
+}
+
+func (s *FakeConsentService) List(resp core.Response, token string) {
+	s.Called(resp, token)
+}
+
+func (s *FakeConsentService) Revoke(resp core.Response, token string, clientID string) {
+	s.Called(resp, token, clientID)
+}
+
+func consentRequest(method, path string) *fasthttp.RequestCtx {
+	r := makeRequestCtx(nil)
+	r.Request.Header.SetMethod(method)
+	r.Request.SetRequestURI(path)
+	r.Request.Header.Set("Authorization", "Bearer token")
+	return r
+}
+
+func TestConsents_Routed(t *testing.T) {
+	s := new(FakeConsentService)
+	s.On("List", mock.Anything, "token").Once()
+	s.On("Revoke", mock.Anything, "token", "tv").Once()
+
+	router := &Router{Consents: &Consents{Handler: s}}
+	router.Handler(consentRequest("GET", "/v5/consents"))
+	router.Handler(consentRequest("DELETE", "/v5/consents/tv"))
+
+	s.AssertExpectations(t)
+}
+
+func TestConsents_WrongRequest_Rejected(t *testing.T) {
+	table := map[string]int{
+		"POST /v5/consents":      fasthttp.StatusMethodNotAllowed,
+		"GET /v5/consents/tv":    fasthttp.StatusMethodNotAllowed,
+		"DELETE /v5/consents/":   fasthttp.StatusNotFound,
+		"DELETE /v5/consents/a/": fasthttp.StatusNotFound,
+	}
+	router := &Router{Consents: &Consents{Handler: new(FakeConsentService)}}
+	for req, status := range table {
+		parts := strings.SplitN(req, " ", 2)
+		r := consentRequest(parts[0], parts[1])
+		router.Handler(r)
+
+		assert.Equal(t, status, r.Response.StatusCode(), req)
+	}
+}
+
+func TestConsents_Disabled_Return404(t *testing.T) {
+	r := consentRequest("GET", "/v5/consents")
+	router := &Router{}
+	router.Handler(r)
+
+	assert.Equal(t, fasthttp.StatusNotFound, r.Response.StatusCode())
+}
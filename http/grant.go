@@ -7,9 +7,46 @@ import (
 	"github.com/VirgilSecurity/virgil-services-auth/core"
 )
 
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+type RequestVerifier interface {
+	VerifyRequest(clientID string, body []byte, timestamp, sign string) (bool, error)
+}
+
 // Grant middleware between http and high level service
 type Grant struct {
-	Handler core.GrantHandler
+	Handler    core.GrantHandler
+	Signatures RequestVerifier // optional, checks request_sign of handshakes
+	Logger     Logger
+}
+
+// Authorize shows the page of the browser flow (RFC 6749 section 4.1.1)
+func (c *Grant) Authorize(ctx *fasthttp.RequestCtx) {
+	resp := &pageResponse{ctx: ctx}
+
+	args := ctx.QueryArgs()
+	c.Handler.Authorize(resp, core.AuthorizationRequest{
+		ResponseType:        string(args.Peek("response_type")),
+		ClientID:            string(args.Peek("client_id")),
+		RedirectURI:         string(args.Peek("redirect_uri")),
+		Scope:               string(args.Peek("scope")),
+		State:               string(args.Peek("state")),
+		CodeChallenge:       string(args.Peek("code_challenge")),
+		CodeChallengeMethod: string(args.Peek("code_challenge_method")),
+		Nonce:               string(args.Peek("nonce")),
+	})
+}
+
+// Device shows the page where the owner approves the user code of the device authorization (RFC 8628 section 3.3)
+func (c *Grant) Device(ctx *fasthttp.RequestCtx) {
+	resp := &pageResponse{ctx: ctx}
+
+	resp.Success(&core.AuthorizationRequest{
+		Device:   true,
+		UserCode: string(ctx.QueryArgs().Peek("user_code")),
+	})
 }
 
 func (c *Grant) Handshake(ctx *fasthttp.RequestCtx) {
@@ -21,10 +58,33 @@ func (c *Grant) Handshake(ctx *fasthttp.RequestCtx) {
 		resp.Error(core.StatusErrorUUIDValidFailed)
 		return
 	}
-	if owner.ID == "" {
+	if owner.ID == "" && owner.Identity == "" {
 		resp.Error(core.StatusErrorUUIDValidFailed)
 		return
 	}
+	// the id of the raw card is checked against the requested one
+	if owner.RawCard != "" && owner.ID == "" {
+		resp.Error(core.StatusErrorUUIDValidFailed)
+		return
+	}
+	if c.Signatures != nil {
+		ok, err := c.Signatures.VerifyRequest(owner.ClientID, ctx.PostBody(),
+			string(ctx.Request.Header.Peek("X-Request-Sign-Timestamp")),
+			string(ctx.Request.Header.Peek("X-Request-Sign")))
+		if err != nil {
+			if core.CardErrorKindOf(err) == core.CardsUnavailable {
+				resp.Error(core.StatusErrorCardsServiceUnavailable)
+				return
+			}
+			c.Logger.Printf("Handshake[Verify request sign]: %v", err)
+			resp.Error(core.StatusErrorInternalApplicationError)
+			return
+		}
+		if !ok {
+			resp.Error(core.StatusErrorRequestSignInvalid)
+			return
+		}
+	}
 	if owner.Scope == "" {
 		owner.Scope = "*"
 	}
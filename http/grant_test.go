@@ -1,16 +1,23 @@
 package http
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
 )
 
 type FakeGrantService struct {
 	mock.Mock
 }
 
+func (s *FakeGrantService) Authorize(resp core.Response, req core.AuthorizationRequest) {
+	s.Called(resp, req)
+}
+
 func (s *FakeGrantService) Handshake(resp core.Response, card core.OwnerCard) {
 	s.Called(resp, card)
 }
@@ -45,6 +52,14 @@ func TestHandshake_ScopeMiss_SetWildcardScope(t *testing.T) {
 	s.AssertExpectations(t)
 }
 
+func TestHandshake_RawCardWithoutID_ReturnErr(t *testing.T) {
+	r := makeRequestCtx(core.OwnerCard{Identity: "alice", RawCard: "raw card"})
+	g := Grant{}
+	g.Handshake(r)
+
+	assertResponse(t, core.StatusErrorUUIDValidFailed, r)
+}
+
 func TestHandshake_MethodInvoked(t *testing.T) {
 	ownCard := core.OwnerCard{
 		ID:    "id",
@@ -52,7 +67,7 @@ func TestHandshake_MethodInvoked(t *testing.T) {
 	}
 	r := makeRequestCtx(ownCard)
 	s := new(FakeGrantService)
-	s.On("Handshake", mock.Anything, core.OwnerCard{ID: "id", Scope: "*"}).Once()
+	s.On("Handshake", mock.Anything, ownCard).Once()
 	g := Grant{Handler: s}
 	g.Handshake(r)
 
@@ -79,3 +94,127 @@ func TestAcknowledge_StatusOk_ReturnResp(t *testing.T) {
 
 	s.AssertExpectations(t)
 }
+
+func TestAuthorize_QueryPassed(t *testing.T) {
+	r := makeRequestCtx(nil)
+	r.Request.SetRequestURI("/v5/authorize?response_type=code&client_id=web&redirect_uri=https%3A%2F%2Fapp.example.com%2Fcb&state=xyz&scope=profile")
+
+	s := new(FakeGrantService)
+	s.On("Authorize", mock.Anything, core.AuthorizationRequest{
+		ResponseType: "code",
+		ClientID:     "web",
+		RedirectURI:  "https://app.example.com/cb",
+		State:        "xyz",
+		Scope:        "profile",
+	}).Once()
+
+	g := Grant{Handler: s}
+	g.Authorize(r)
+
+	s.AssertExpectations(t)
+}
+
+func TestPageResponse_Success_RenderEscapedPage(t *testing.T) {
+	r := makeRequestCtx(nil)
+	resp := &pageResponse{ctx: r}
+	resp.Success(&core.AuthorizationRequest{ClientID: "web", State: `"</script><script>alert(1)</script>`})
+
+	assert.Equal(t, fasthttp.StatusOK, r.Response.StatusCode())
+	assert.Equal(t, "DENY", string(r.Response.Header.Peek("X-Frame-Options")))
+	assert.Contains(t, string(r.Response.Body()), `client_id: "web"`)
+	assert.NotContains(t, string(r.Response.Body()), "<script>alert(1)")
+}
+
+func TestPageResponse_Error_RenderErrorPage(t *testing.T) {
+	r := makeRequestCtx(nil)
+	resp := &pageResponse{ctx: r}
+	resp.Error(core.StatusErrorRedirectURIInvalid)
+
+	assert.Equal(t, fasthttp.StatusBadRequest, r.Response.StatusCode())
+	assert.Contains(t, string(r.Response.Body()), "53180")
+}
+
+type FakeRequestVerifier struct {
+	mock.Mock
+}
+
+func (v *FakeRequestVerifier) VerifyRequest(clientID string, body []byte, timestamp, sign string) (bool, error) {
+	args := v.Called(clientID, timestamp, sign)
+	return args.Bool(0), args.Error(1)
+}
+
+type FakeLogger struct {
+	mock.Mock
+}
+
+func (l *FakeLogger) Printf(format string, args ...interface{}) {
+	l.Called()
+}
+
+func TestHandshake_RequestSignInvalid_ReturnErr(t *testing.T) {
+	r := makeRequestCtx(core.OwnerCard{ID: "id", ClientID: "app"})
+	r.Request.Header.Set("X-Request-Sign-Timestamp", "1500000000")
+	r.Request.Header.Set("X-Request-Sign", "sign")
+
+	v := new(FakeRequestVerifier)
+	v.On("VerifyRequest", "app", "1500000000", "sign").Return(false, nil).Once()
+
+	g := Grant{Signatures: v}
+	g.Handshake(r)
+
+	v.AssertExpectations(t)
+	assertResponse(t, core.StatusErrorRequestSignInvalid, r)
+}
+
+func TestHandshake_RequestSignVerifierReturnErr_ReturnInternalErr(t *testing.T) {
+	r := makeRequestCtx(core.OwnerCard{ID: "id", ClientID: "app"})
+
+	v := new(FakeRequestVerifier)
+	v.On("VerifyRequest", "app", "", "").Return(false, fmt.Errorf("ERROR")).Once()
+
+	l := new(FakeLogger)
+	l.On("Printf").Once()
+
+	g := Grant{Signatures: v, Logger: l}
+	g.Handshake(r)
+
+	assertResponse(t, core.StatusErrorInternalApplicationError, r)
+	l.AssertExpectations(t)
+}
+
+func TestHandshake_RequestSignCardsServiceUnavailable_Return503(t *testing.T) {
+	r := makeRequestCtx(core.OwnerCard{ID: "id", ClientID: "app"})
+
+	v := new(FakeRequestVerifier)
+	v.On("VerifyRequest", "app", "", "").Return(false, &core.CardError{Kind: core.CardsUnavailable, Err: fmt.Errorf("ERROR")}).Once()
+
+	g := Grant{Signatures: v}
+	g.Handshake(r)
+
+	assertResponse(t, core.StatusErrorCardsServiceUnavailable, r)
+}
+
+func TestHandshake_RequestSignValid_MethodInvoked(t *testing.T) {
+	r := makeRequestCtx(core.OwnerCard{ID: "id", ClientID: "app", Scope: "*"})
+
+	v := new(FakeRequestVerifier)
+	v.On("VerifyRequest", "app", "", "").Return(true, nil).Once()
+
+	s := new(FakeGrantService)
+	s.On("Handshake", mock.Anything, core.OwnerCard{ID: "id", ClientID: "app", Scope: "*"}).Once()
+
+	g := Grant{Handler: s, Signatures: v}
+	g.Handshake(r)
+
+	s.AssertExpectations(t)
+}
+
+func TestHandshake_Identity_MethodInvoked(t *testing.T) {
+	r := makeRequestCtx(core.OwnerCard{Identity: "alice"})
+	s := new(FakeGrantService)
+	s.On("Handshake", mock.Anything, core.OwnerCard{Identity: "alice", Scope: "*"}).Once()
+	g := Grant{Handler: s}
+	g.Handshake(r)
+
+	s.AssertExpectations(t)
+}
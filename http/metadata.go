@@ -0,0 +1,61 @@
+package http
+
+import (
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type ScopeLister interface {
+	Scopes() []string
+}
+
+// Metadata serves the authorization server metadata (RFC 8414). The document is made on every request from the
+// router and the configuration, so it lists only the endpoints and the features that are enabled.
+type Metadata struct {
+	Issuer     string
+	BaseURL    string      // base of the endpoint URLs
+	GrantTypes []string    // grant types that the token endpoint supports
+	Scopes     ScopeLister // optional, scopes_supported is omitted without it
+	// SubjectTypes are the subject types of id tokens. The OpenID Connect fields are omitted without them
+	SubjectTypes []string
+}
+
+func (m *Metadata) Serve(ctx *fasthttp.RequestCtx, r *Router) {
+	resp := &response{ctx: ctx}
+
+	doc := &core.ServerMetadata{
+		Issuer:                            m.Issuer,
+		TokenEndpoint:                     m.BaseURL + "/v5/authorization/actions/obtain-access-token",
+		IntrospectionEndpoint:             m.BaseURL + "/v5/authorization/actions/introspect",
+		RevocationEndpoint:                m.BaseURL + "/v5/authorization/actions/revoke",
+		ResponseTypesSupported:            []string{},
+		GrantTypesSupported:               m.GrantTypes,
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"},
+		TokenEndpointAuthSigningAlgValuesSupported: []string{"virgil"},
+		CodeChallengeMethodsSupported:              []string{core.CodeChallengeMethodS256},
+	}
+	if r.Grant != nil {
+		doc.AuthorizationEndpoint = m.BaseURL + "/v5/authorize"
+		doc.ResponseTypesSupported = []string{"code"}
+	}
+	if r.Registration != nil {
+		doc.RegistrationEndpoint = m.BaseURL + "/v5/clients"
+	}
+	if r.UserInfo != nil {
+		doc.UserInfoEndpoint = m.BaseURL + "/v5/userinfo"
+	}
+	for _, g := range m.GrantTypes {
+		if g == core.GrantTypeDeviceCode {
+			doc.DeviceAuthorizationEndpoint = m.BaseURL + "/v5/authorization/actions/device-authorization"
+		}
+	}
+	if m.Scopes != nil {
+		doc.ScopesSupported = m.Scopes.Scopes()
+	}
+	if len(m.SubjectTypes) != 0 {
+		doc.SubjectTypesSupported = m.SubjectTypes
+		doc.IDTokenSigningAlgValuesSupported = []string{"virgil"}
+	}
+	resp.Success(doc)
+}
@@ -0,0 +1,84 @@
+package http
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type fakeScopes []string
+
+func (s fakeScopes) Scopes() []string {
+	return s
+}
+
+func getMetadata(t *testing.T, router *Router, path string) (int, *core.ServerMetadata) {
+	r := makeRequestCtx(nil)
+	r.Request.Header.SetMethod("GET")
+	r.Request.SetRequestURI(path)
+	router.Handler(r)
+
+	var doc core.ServerMetadata
+	if r.Response.StatusCode() == fasthttp.StatusOK {
+		require.NoError(t, json.Unmarshal(r.Response.Body(), &doc))
+	}
+	return r.Response.StatusCode(), &doc
+}
+
+func TestMetadata_ListsOnlyEnabledEndpoints(t *testing.T) {
+	m := &Metadata{
+		Issuer:     "https://auth.example.com",
+		BaseURL:    "https://auth.example.com",
+		GrantTypes: []string{"access_code", "refresh_token"},
+	}
+	status, doc := getMetadata(t, &Router{Metadata: m}, "/.well-known/oauth-authorization-server")
+
+	assert.Equal(t, fasthttp.StatusOK, status)
+	assert.Equal(t, "https://auth.example.com", doc.Issuer)
+	assert.Equal(t, "https://auth.example.com/v5/authorization/actions/obtain-access-token", doc.TokenEndpoint)
+	assert.Equal(t, "https://auth.example.com/v5/authorization/actions/introspect", doc.IntrospectionEndpoint)
+	assert.Equal(t, "https://auth.example.com/v5/authorization/actions/revoke", doc.RevocationEndpoint)
+	assert.Empty(t, doc.AuthorizationEndpoint)
+	assert.Empty(t, doc.RegistrationEndpoint)
+	assert.Empty(t, doc.DeviceAuthorizationEndpoint)
+	assert.Empty(t, doc.UserInfoEndpoint)
+	assert.Empty(t, doc.ScopesSupported)
+	assert.Empty(t, doc.SubjectTypesSupported)
+	assert.Empty(t, doc.IDTokenSigningAlgValuesSupported)
+	assert.Equal(t, []string{"access_code", "refresh_token"}, doc.GrantTypesSupported)
+	assert.Equal(t, []string{"S256"}, doc.CodeChallengeMethodsSupported)
+}
+
+func TestMetadata_EnabledFeatures_Listed(t *testing.T) {
+	m := &Metadata{
+		BaseURL:    "https://auth.example.com",
+		GrantTypes: []string{"access_code", core.GrantTypeDeviceCode},
+		Scopes:     fakeScopes{"profile"},
+
+		SubjectTypes: []string{"public"},
+	}
+	router := &Router{Metadata: m, Grant: &Grant{}, Registration: &Registration{}, UserInfo: &UserInfo{}}
+	for _, path := range []string{"/.well-known/oauth-authorization-server", "/.well-known/openid-configuration"} {
+		status, doc := getMetadata(t, router, path)
+
+		assert.Equal(t, fasthttp.StatusOK, status)
+		assert.Equal(t, "https://auth.example.com/v5/authorize", doc.AuthorizationEndpoint)
+		assert.Equal(t, []string{"code"}, doc.ResponseTypesSupported)
+		assert.Equal(t, "https://auth.example.com/v5/clients", doc.RegistrationEndpoint)
+		assert.Equal(t, "https://auth.example.com/v5/userinfo", doc.UserInfoEndpoint)
+		assert.Equal(t, "https://auth.example.com/v5/authorization/actions/device-authorization", doc.DeviceAuthorizationEndpoint)
+		assert.Equal(t, []string{"profile"}, doc.ScopesSupported)
+		assert.Equal(t, []string{"public"}, doc.SubjectTypesSupported)
+		assert.Equal(t, []string{"virgil"}, doc.IDTokenSigningAlgValuesSupported)
+	}
+}
+
+func TestMetadata_NotConfigured_Return404(t *testing.T) {
+	status, _ := getMetadata(t, &Router{}, "/.well-known/oauth-authorization-server")
+	assert.Equal(t, fasthttp.StatusNotFound, status)
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"html/template"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/valyala/fasthttp"
+)
+
+// pageResponse renders the pages of the browser flow instead of JSON
+type pageResponse struct {
+	ctx *fasthttp.RequestCtx
+}
+
+func (r *pageResponse) Error(code core.ResponseStatus) {
+	status := fasthttp.StatusBadRequest
+	if code == core.StatusErrorInternalApplicationError {
+		status = fasthttp.StatusInternalServerError
+	}
+	r.render(status, errorPage, code)
+}
+
+func (r *pageResponse) Success(model interface{}) {
+	r.render(fasthttp.StatusOK, authorizePage, model)
+}
+
+func (r *pageResponse) Created(model interface{}) {
+	r.Success(model)
+}
+
+func (r *pageResponse) NoContent() {
+	r.ctx.ResetBody()
+	r.ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
+
+func (r *pageResponse) render(status int, t *template.Template, model interface{}) {
+	r.ctx.ResetBody()
+	r.ctx.SetStatusCode(status)
+	r.ctx.SetContentType("text/html; charset=utf-8")
+	// the page must not be framed by other sites (clickjacking)
+	r.ctx.Response.Header.Set("X-Frame-Options", "DENY")
+	r.ctx.Response.Header.Set("Content-Security-Policy", "frame-ancestors 'none'")
+	r.ctx.Response.Header.Set("Cache-Control", "no-store")
+	if err := t.Execute(r.ctx, model); err != nil {
+		r.ctx.ResetBody()
+		r.ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+	}
+}
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Virgil Auth</title></head>
+<body>
+<h1>Authorization failed</h1>
+<p>Error code: {{.}}</p>
+</body>
+</html>
+`))
+
+// authorizePage runs the handshake from the browser. The encrypted message is passed to window.virgilAuthRespond
+// if the page is extended with it (e.g. by a browser extension that holds the owner's private key), otherwise the
+// owner decrypts and re-encrypts the message with any Virgil tool and pastes the result. The device verification page
+// additionally asks for the user code and has nothing to redirect to.
+var authorizePage = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Virgil Auth</title></head>
+<body>
+<h1>Sign in with Virgil card</h1>
+<form id="handshake">
+{{- if .Device}}
+  <label>Code shown on the device <input id="user_code" value="{{.UserCode}}" required></label>
+{{- end}}
+  <label>Virgil card id <input id="card" required></label>
+  <button type="submit">Continue</button>
+</form>
+<form id="consent" hidden>
+  <p>The application <b id="consent_client"></b> asks for access to <b id="consent_scope"></b>.</p>
+  <button type="submit">Allow</button>
+</form>
+<form id="acknowledge" hidden>
+  <p>Decrypt the message with your private key and encrypt it for the Virgil Auth service:</p>
+  <textarea id="challenge" readonly rows="6" cols="80"></textarea>
+  <textarea id="response" required rows="6" cols="80"></textarea>
+  <button type="submit">Sign in</button>
+</form>
+<p id="done" hidden>The device is signed in, you can return to it.</p>
+<p id="error"></p>
+<script>
+(function () {
+  var req = {
+    client_id: {{.ClientID}},
+    redirect_uri: {{.RedirectURI}},
+    scope: {{.Scope}},
+    state: {{.State}},
+    code_challenge: {{.CodeChallenge}},
+    code_challenge_method: {{.CodeChallengeMethod}},
+    nonce: {{.Nonce}}
+  };
+  var grantID;
+
+  // the urls are relative to /v5/, so the page works under the path prefix of a tenant as well
+  function post(url, body) {
+    return fetch(url, {
+      method: "POST",
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify(body)
+    }).then(function (r) {
+      if (r.status === 204) { return {}; }
+      return r.json().then(function (b) {
+        if (!r.ok) {
+          var err = new Error("error code " + b.code);
+          err.code = b.code;
+          throw err;
+        }
+        return b;
+      });
+    });
+  }
+  function fail(err) {
+    document.getElementById("error").textContent = err.message;
+  }
+  function acknowledge(message) {
+    return post("authorization-grant/" + encodeURIComponent(grantID) + "/actions/acknowledge",
+      {encrypted_message: message}).then(function (r) {
+      if (!r.redirect_uri) {
+        document.getElementById("acknowledge").hidden = true;
+        document.getElementById("handshake").hidden = true;
+        document.getElementById("done").hidden = false;
+        return;
+      }
+      window.location.assign(r.redirect_uri);
+    });
+  }
+
+  function handshake() {
+    return post("authorization-grant/actions/get-challenge-message", req).then(function (r) {
+      grantID = r.authorization_grant_id;
+      if (typeof window.virgilAuthRespond === "function") {
+        return Promise.resolve(window.virgilAuthRespond(r.encrypted_message)).then(acknowledge);
+      }
+      document.getElementById("challenge").value = r.encrypted_message;
+      document.getElementById("handshake").hidden = true;
+      document.getElementById("consent").hidden = true;
+      document.getElementById("acknowledge").hidden = false;
+    }).catch(function (err) {
+      // the client requires the owner's consent, the handshake is repeated after the owner allows it
+      if (err.code !== 53270 || req.consent) { throw err; }
+      // the client and the scope of the device authorization aren't known to the page
+      document.getElementById("consent_client").textContent = req.client_id || "on the device";
+      document.getElementById("consent_scope").textContent = req.scope || "the requested scope";
+      document.getElementById("handshake").hidden = true;
+      document.getElementById("consent").hidden = false;
+    });
+  }
+
+  document.getElementById("handshake").addEventListener("submit", function (e) {
+    e.preventDefault();
+    req.resource_owner_virgil_card_id = document.getElementById("card").value;
+    if (document.getElementById("user_code")) {
+      req.user_code = document.getElementById("user_code").value;
+    }
+    handshake().catch(fail);
+  });
+  document.getElementById("consent").addEventListener("submit", function (e) {
+    e.preventDefault();
+    req.consent = true;
+    handshake().catch(fail);
+  });
+  document.getElementById("acknowledge").addEventListener("submit", function (e) {
+    e.preventDefault();
+    acknowledge(document.getElementById("response").value.trim()).catch(fail);
+  });
+})();
+</script>
+</body>
+</html>
+`))
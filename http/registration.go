@@ -0,0 +1,87 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/valyala/fasthttp"
+)
+
+// Registration middleware between http and the client registration service
+type Registration struct {
+	Handler core.RegistrationHandler
+}
+
+func (c *Registration) Register(ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	req, ok := registrationRequest(ctx, resp, "")
+	if !ok {
+		return
+	}
+	c.Handler.Register(resp, req)
+}
+
+func (c *Registration) Read(clientID string, ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	c.Handler.Read(resp, core.ClientRegistrationRequest{
+		Token:      bearerToken(ctx),
+		ClientID:   clientID,
+		RemoteAddr: ctx.RemoteIP().String(),
+	})
+}
+
+func (c *Registration) Update(clientID string, ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	req, ok := registrationRequest(ctx, resp, clientID)
+	if !ok {
+		return
+	}
+	c.Handler.Update(resp, req)
+}
+
+func (c *Registration) Delete(clientID string, ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	c.Handler.Delete(resp, core.ClientRegistrationRequest{
+		Token:      bearerToken(ctx),
+		ClientID:   clientID,
+		RemoteAddr: ctx.RemoteIP().String(),
+	})
+}
+
+// clientUpdate is the body of the update request. It must name the client it's sent to (RFC 7592 section 2.2).
+type clientUpdate struct {
+	core.ClientMetadata
+
+	ClientID string `json:"client_id"`
+}
+
+func registrationRequest(ctx *fasthttp.RequestCtx, resp *response, clientID string) (core.ClientRegistrationRequest, bool) {
+	req := core.ClientRegistrationRequest{
+		Token:      bearerToken(ctx),
+		ClientID:   clientID,
+		RemoteAddr: ctx.RemoteIP().String(),
+	}
+	var m clientUpdate
+	err := json.Unmarshal(ctx.PostBody(), &m)
+	if err != nil || (clientID != "" && m.ClientID != clientID) {
+		resp.Error(core.StatusErrorInvalidClientMetadata)
+		return req, false
+	}
+	req.Metadata = m.ClientMetadata
+	return req, true
+}
+
+// bearerToken takes the token from the Bearer authorization header (RFC 6750 section 2.1)
+func bearerToken(ctx *fasthttp.RequestCtx) string {
+	const prefix = "Bearer "
+	h := ctx.Request.Header.Peek("Authorization")
+	if !bytes.HasPrefix(h, []byte(prefix)) {
+		return ""
+	}
+	return string(h[len(prefix):])
+}
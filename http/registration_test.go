@@ -0,0 +1,96 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
+)
+
+type FakeRegistrationService struct {
+	mock.Mock
+}
+
+func (s *FakeRegistrationService) Register(resp core.Response, req core.ClientRegistrationRequest) {
+	s.Called(resp, req)
+}
+
+func (s *FakeRegistrationService) Read(resp core.Response, req core.ClientRegistrationRequest) {
+	s.Called(resp, req)
+}
+
+func (s *FakeRegistrationService) Update(resp core.Response, req core.ClientRegistrationRequest) {
+	s.Called(resp, req)
+}
+
+func (s *FakeRegistrationService) Delete(resp core.Response, req core.ClientRegistrationRequest) {
+	s.Called(resp, req)
+}
+
+func TestRegister_BodyIncorrect_ReturnErr(t *testing.T) {
+	r := makeRequestCtx("asdf,sa")
+	c := &Registration{}
+	c.Register(r)
+
+	assertResponse(t, core.StatusErrorInvalidClientMetadata, r)
+}
+
+func TestRegister_BearerToken_MethodInvoked(t *testing.T) {
+	r := makeRequestCtx(core.ClientMetadata{ClientName: "app", Scope: "profile"})
+	r.Request.Header.Set("Authorization", "Bearer initial")
+
+	s := new(FakeRegistrationService)
+	s.On("Register", mock.Anything, mock.MatchedBy(func(req core.ClientRegistrationRequest) bool {
+		return req.Token == "initial" && req.Metadata.ClientName == "app" && req.Metadata.Scope == "profile"
+	})).Once()
+
+	c := &Registration{Handler: s}
+	c.Register(r)
+
+	s.AssertExpectations(t)
+}
+
+func TestUpdate_ClientIDMismatch_ReturnErr(t *testing.T) {
+	r := makeRequestCtx(clientUpdate{ClientID: "other"})
+
+	c := &Registration{}
+	c.Update("id", r)
+
+	assertResponse(t, core.StatusErrorInvalidClientMetadata, r)
+}
+
+func TestRouter_ClientConfiguration_MethodDispatched(t *testing.T) {
+	table := map[string]string{
+		"GET":    "Read",
+		"PUT":    "Update",
+		"DELETE": "Delete",
+	}
+	for method, handler := range table {
+		r := makeRequestCtx(clientUpdate{ClientID: "id"})
+		r.Request.Header.SetMethod(method)
+		r.Request.SetRequestURI("/v5/clients/id")
+
+		s := new(FakeRegistrationService)
+		s.On(handler, mock.Anything, mock.MatchedBy(func(req core.ClientRegistrationRequest) bool {
+			return req.ClientID == "id"
+		})).Once()
+
+		router := &Router{Registration: &Registration{Handler: s}}
+		router.Handler(r)
+
+		s.AssertExpectations(t)
+	}
+}
+
+func TestRouter_RegistrationDisabled_Return404(t *testing.T) {
+	r := makeRequestCtx(nil)
+	r.Request.Header.SetMethod("POST")
+	r.Request.SetRequestURI("/v5/clients")
+
+	router := &Router{}
+	router.Handler(r)
+
+	assert.Equal(t, fasthttp.StatusNotFound, r.Response.StatusCode())
+}
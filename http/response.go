@@ -19,8 +19,14 @@ func (r *response) Error(code core.ResponseStatus) {
 		return
 	}
 	status := fasthttp.StatusBadRequest
-	if code == core.StatusErrorInternalApplicationError {
+	switch code {
+	case core.StatusErrorInternalApplicationError:
 		status = fasthttp.StatusInternalServerError
+	case core.StatusErrorCardsServiceUnavailable:
+		status = fasthttp.StatusServiceUnavailable
+	case core.StatusErrorClientAuthenticationFailed, core.StatusErrorRegistrationTokenInvalid,
+		core.StatusErrorAdminTokenInvalid:
+		status = fasthttp.StatusUnauthorized
 	}
 	r.ctx.SetStatusCode(status)
 
@@ -35,3 +41,13 @@ func (r *response) Success(model interface{}) {
 		return
 	}
 }
+
+func (r *response) Created(model interface{}) {
+	r.ctx.SetStatusCode(fasthttp.StatusCreated)
+	r.Success(model)
+}
+
+func (r *response) NoContent() {
+	r.ctx.ResetBody()
+	r.ctx.SetStatusCode(fasthttp.StatusNoContent)
+}
@@ -41,6 +41,11 @@ func assertResponse(t *testing.T, expected core.ResponseStatus, r *fasthttp.Requ
 	statusCpde := fasthttp.StatusBadRequest
 	if expected == core.StatusErrorInternalApplicationError {
 		statusCpde = fasthttp.StatusInternalServerError
+	} else if expected == core.StatusErrorClientAuthenticationFailed || expected == core.StatusErrorRegistrationTokenInvalid ||
+		expected == core.StatusErrorAdminTokenInvalid {
+		statusCpde = fasthttp.StatusUnauthorized
+	} else if expected == core.StatusErrorCardsServiceUnavailable {
+		statusCpde = fasthttp.StatusServiceUnavailable
 	} else if expected == core.StatusErrorAttemptNotFound {
 		statusCpde = fasthttp.StatusNotFound
 	}
@@ -69,6 +74,20 @@ func TestError_StatusErrorInernalApplicationError_Return500(t *testing.T) {
 	assertResponse(t, core.StatusErrorInternalApplicationError, ctx)
 }
 
+func TestError_StatusErrorCardsServiceUnavailable_Return503(t *testing.T) {
+	ctx := makeRequestCtx("body")
+	resp := response{ctx: ctx}
+	resp.Error(core.StatusErrorCardsServiceUnavailable)
+	assertResponse(t, core.StatusErrorCardsServiceUnavailable, ctx)
+}
+
+func TestError_StatusErrorAdminTokenInvalid_Return401(t *testing.T) {
+	ctx := makeRequestCtx("body")
+	resp := response{ctx: ctx}
+	resp.Error(core.StatusErrorAdminTokenInvalid)
+	assertResponse(t, core.StatusErrorAdminTokenInvalid, ctx)
+}
+
 func TestError_OtherErrors_Return400(t *testing.T) {
 	table := []core.ResponseStatus{
 		core.StatusErrorUUIDValidFailed,
@@ -82,6 +101,22 @@ func TestError_OtherErrors_Return400(t *testing.T) {
 		core.StatusErrorAccessTokenBroken,
 		core.StatusErrorRefreshTokenNotFound,
 		core.StatusErrorCardInvalid,
+		core.StatusErrorScopeNotAllowed,
+		core.StatusErrorGrantTypeNotAllowed,
+		core.StatusErrorInvalidClientMetadata,
+		core.StatusErrorCodeChallengeInvalid,
+		core.StatusErrorCodeVerifierInvalid,
+		core.StatusErrorRedirectURIInvalid,
+		core.StatusErrorRequestSignInvalid,
+		core.StatusErrorAssertionInvalid,
+		core.StatusErrorChallengeMethodUnsupported,
+		core.StatusErrorAuthorizationPending,
+		core.StatusErrorSlowDown,
+		core.StatusErrorUserCodeInvalid,
+		core.StatusErrorSubjectTokenInvalid,
+		core.StatusErrorAudienceNotAllowed,
+		core.StatusErrorConsentRequired,
+		core.StatusErrorOwnerCardRevoked,
 	}
 	for _, v := range table {
 		ctx := makeRequestCtx("body")
@@ -91,6 +126,13 @@ func TestError_OtherErrors_Return400(t *testing.T) {
 	}
 }
 
+func TestError_ClientAuthenticationFailed_Return401(t *testing.T) {
+	ctx := makeRequestCtx("body")
+	resp := response{ctx: ctx}
+	resp.Error(core.StatusErrorClientAuthenticationFailed)
+	assertResponse(t, core.StatusErrorClientAuthenticationFailed, ctx)
+}
+
 func TestSuccess(t *testing.T) {
 	ctx := makeRequestCtx("body")
 	resp := response{ctx: ctx}
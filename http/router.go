@@ -10,15 +10,48 @@ type Router struct {
 	Grant         *Grant
 	Auth          *Auth
 	HealthChecker *HealthChecker
+	Registration  *Registration // nil if the dynamic client registration is disabled
+	Metadata      *Metadata
+	UserInfo      *UserInfo // nil if the userinfo endpoint is disabled
+	Consents      *Consents // nil if consents aren't recorded
+	Admin         *Admin    // nil if the admin token isn't set
 }
 
 func (r *Router) Handler(ctx *fasthttp.RequestCtx) {
+	if strings.HasPrefix(string(ctx.Path()), "/v5/clients") {
+		r.clients(ctx)
+		return
+	}
+
+	if strings.HasPrefix(string(ctx.Path()), "/v5/consents") {
+		r.consents(ctx)
+		return
+	}
+	if strings.HasPrefix(string(ctx.Path()), "/v5/admin/") {
+		r.admin(ctx)
+		return
+	}
+	if string(ctx.Path()) == "/v5/userinfo" {
+		r.userInfo(ctx)
+		return
+	}
+
 	if ctx.IsGet() {
 		switch string(ctx.Path()) {
 		case "/v5/health/status":
 			r.HealthChecker.Status(ctx)
 		case "/v5/health/info":
 			r.HealthChecker.Info(ctx)
+		case "/v5/authorize":
+			r.Grant.Authorize(ctx)
+		case "/v5/device":
+			r.Grant.Device(ctx)
+		case "/.well-known/oauth-authorization-server", "/.well-known/openid-configuration":
+			if r.Metadata == nil {
+				ctx.Error("", fasthttp.StatusNotFound)
+				return
+			}
+			r.Metadata.Serve(ctx, r)
 		default:
 			ctx.Error("", fasthttp.StatusMethodNotAllowed)
 		}
@@ -42,6 +75,15 @@ func (r *Router) Handler(ctx *fasthttp.RequestCtx) {
 	case path == "/v5/authorization/actions/verify":
 		r.Auth.Verify(ctx)
 
+	case path == "/v5/authorization/actions/introspect":
+		r.Auth.Introspect(ctx)
+
+	case path == "/v5/authorization/actions/revoke":
+		r.Auth.Revoke(ctx)
+
+	case path == "/v5/authorization/actions/device-authorization":
+		r.Auth.DeviceAuthorization(ctx)
+
 	case path == "/v5/authorization-grant/actions/get-challenge-message":
 		r.Grant.Handshake(ctx)
 
@@ -60,3 +102,104 @@ func (r *Router) Handler(ctx *fasthttp.RequestCtx) {
 		return
 	}
 }
+
+// userInfo serves the userinfo endpoint on GET and POST (OpenID Connect Core section 5.3.1)
+func (r *Router) userInfo(ctx *fasthttp.RequestCtx) {
+	if r.UserInfo == nil {
+		ctx.Error("", fasthttp.StatusNotFound)
+		return
+	}
+	if !ctx.IsGet() && !ctx.IsPost() {
+		ctx.Error("", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	r.UserInfo.UserInfo(ctx)
+}
+
+// consents routes the requests of owners to their consents
+func (r *Router) consents(ctx *fasthttp.RequestCtx) {
+	if r.Consents == nil {
+		ctx.Error("", fasthttp.StatusNotFound)
+		return
+	}
+	path := string(ctx.Path())
+	if path == "/v5/consents" {
+		if !ctx.IsGet() {
+			ctx.Error("", fasthttp.StatusMethodNotAllowed)
+			return
+		}
+		r.Consents.List(ctx)
+		return
+	}
+
+	clientID := strings.TrimPrefix(path, "/v5/consents/")
+	if clientID == path || clientID == "" || strings.Contains(clientID, "/") {
+		ctx.Error("", fasthttp.StatusNotFound)
+		return
+	}
+	if !ctx.IsDelete() {
+		ctx.Error("", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	r.Consents.Revoke(clientID, ctx)
+}
+
+// admin routes the operator calls
+func (r *Router) admin(ctx *fasthttp.RequestCtx) {
+	if r.Admin == nil {
+		ctx.Error("", fasthttp.StatusNotFound)
+		return
+	}
+	path := string(ctx.Path())
+	if path == "/v5/admin/fingerprints" {
+		if !ctx.IsGet() {
+			ctx.Error("", fasthttp.StatusMethodNotAllowed)
+			return
+		}
+		r.Admin.Fingerprints(ctx)
+		return
+	}
+	cardID := strings.TrimPrefix(path, "/v5/admin/cards/")
+	if cardID == path || cardID == "" || strings.Contains(cardID, "/") {
+		ctx.Error("", fasthttp.StatusNotFound)
+		return
+	}
+	if !ctx.IsDelete() {
+		ctx.Error("", fasthttp.StatusMethodNotAllowed)
+		return
+	}
+	r.Admin.PurgeCard(cardID, ctx)
+}
+
+// clients routes the client registration (RFC 7591) and management (RFC 7592) requests
+func (r *Router) clients(ctx *fasthttp.RequestCtx) {
+	if r.Registration == nil {
+		ctx.Error("", fasthttp.StatusNotFound)
+		return
+	}
+	path := string(ctx.Path())
+	if path == "/v5/clients" {
+		if !ctx.IsPost() {
+			ctx.Error("", fasthttp.StatusMethodNotAllowed)
+			return
+		}
+		r.Registration.Register(ctx)
+		return
+	}
+
+	clientID := strings.TrimPrefix(path, "/v5/clients/")
+	if clientID == path || clientID == "" || strings.Contains(clientID, "/") {
+		ctx.Error("", fasthttp.StatusNotFound)
+		return
+	}
+	switch {
+	case ctx.IsGet():
+		r.Registration.Read(clientID, ctx)
+	case ctx.IsPut():
+		r.Registration.Update(clientID, ctx)
+	case ctx.IsDelete():
+		r.Registration.Delete(clientID, ctx)
+	default:
+		ctx.Error("", fasthttp.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"strings"
+
+	"github.com/valyala/fasthttp"
+)
+
+// Tenants routes the requests to the routers of the tenants by the host name or the first path segment. The segment
+// is cut off the path before the request is routed. The health of the instance is served on any host before the
+// tenants are looked up, so the probes don't depend on the tenant routes.
+type Tenants struct {
+	Hosts         map[string]*Router // the host names are lower case and have no port
+	Prefixes      map[string]*Router // the prefixes are like /acme
+	HealthChecker *HealthChecker
+}
+
+func (t *Tenants) Handler(ctx *fasthttp.RequestCtx) {
+	if ctx.IsGet() {
+		switch string(ctx.Path()) {
+		case "/v5/health/status":
+			t.HealthChecker.Status(ctx)
+			return
+		case "/v5/health/info":
+			t.HealthChecker.Info(ctx)
+			return
+		}
+	}
+
+	host := strings.ToLower(string(ctx.Host()))
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	if r, ok := t.Hosts[host]; ok {
+		r.Handler(ctx)
+		return
+	}
+
+	path := string(ctx.Path())
+	prefix, rest := path, "/"
+	if i := strings.Index(strings.TrimPrefix(path, "/"), "/"); i >= 0 {
+		prefix, rest = path[:i+1], path[i+1:]
+	}
+	if r, ok := t.Prefixes[prefix]; ok {
+		ctx.URI().SetPath(rest)
+		r.Handler(ctx)
+		return
+	}
+	ctx.Error("", fasthttp.StatusNotFound)
+}
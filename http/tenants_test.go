@@ -0,0 +1,81 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
+)
+
+func tenantRequest(host, path string) *fasthttp.RequestCtx {
+	r := consentRequest("DELETE", path)
+	r.Request.Header.SetHost(host)
+	return r
+}
+
+func TestTenants_RoutedByHost(t *testing.T) {
+	acme, other := new(FakeAdminService), new(FakeAdminService)
+	acme.On("PurgeCard", mock.Anything, "token", "card").Once()
+
+	tenants := &Tenants{
+		Hosts: map[string]*Router{
+			"auth.acme.com":  {Admin: &Admin{Handler: acme}},
+			"auth.other.com": {Admin: &Admin{Handler: other}},
+		},
+	}
+	tenants.Handler(tenantRequest("Auth.Acme.com:8080", "/v5/admin/cards/card"))
+
+	acme.AssertExpectations(t)
+	other.AssertNotCalled(t, "PurgeCard", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestTenants_RoutedByPathPrefix(t *testing.T) {
+	acme := new(FakeAdminService)
+	acme.On("PurgeCard", mock.Anything, "token", "card").Once()
+
+	tenants := &Tenants{
+		Prefixes: map[string]*Router{
+			"/acme": {Admin: &Admin{Handler: acme}},
+		},
+	}
+	tenants.Handler(tenantRequest("auth.example.com", "/acme/v5/admin/cards/card"))
+
+	acme.AssertExpectations(t)
+}
+
+func TestTenants_UnknownTenant_Return404(t *testing.T) {
+	table := []struct {
+		host string
+		path string
+	}{
+		{"auth.example.com", "/v5/admin/cards/card"},
+		{"auth.example.com", "/other/v5/admin/cards/card"},
+		{"auth.example.com", "/acmecorp/v5/admin/cards/card"},
+	}
+	tenants := &Tenants{
+		Hosts:    map[string]*Router{"auth.acme.com": {}},
+		Prefixes: map[string]*Router{"/acme": {}},
+	}
+	for _, v := range table {
+		r := tenantRequest(v.host, v.path)
+		tenants.Handler(r)
+
+		assert.Equal(t, fasthttp.StatusNotFound, r.Response.StatusCode(), v.path)
+	}
+}
+
+func TestTenants_Health_ServedForInstance(t *testing.T) {
+	for _, host := range []string{"auth.acme.com", "10.0.0.1:8080"} {
+		r := tenantRequest(host, "/v5/health/status")
+		r.Request.Header.SetMethod("GET")
+
+		tenants := &Tenants{
+			Hosts:         map[string]*Router{"auth.acme.com": {}},
+			HealthChecker: &HealthChecker{},
+		}
+		tenants.Handler(r)
+
+		assert.Equal(t, fasthttp.StatusOK, r.Response.StatusCode(), host)
+	}
+}
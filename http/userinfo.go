@@ -0,0 +1,19 @@
+package http
+
+import (
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+// UserInfo middleware between http and the userinfo service. The access token is passed in the Bearer
+// authorization header.
+type UserInfo struct {
+	Handler core.UserInfoHandler
+}
+
+func (c *UserInfo) UserInfo(ctx *fasthttp.RequestCtx) {
+	resp := &response{ctx: ctx}
+
+	c.Handler.UserInfo(resp, bearerToken(ctx))
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/valyala/fasthttp"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type FakeUserInfoService struct {
+	mock.Mock
+}
+
+func (s *FakeUserInfoService) UserInfo(resp core.Response, token string) {
+	s.Called(resp, token)
+}
+
+func TestUserInfo_BearerToken_MethodInvoked(t *testing.T) {
+	for _, method := range []string{"GET", "POST"} {
+		r := makeRequestCtx(nil)
+		r.Request.Header.SetMethod(method)
+		r.Request.SetRequestURI("/v5/userinfo")
+		r.Request.Header.Set("Authorization", "Bearer token")
+
+		s := new(FakeUserInfoService)
+		s.On("UserInfo", mock.Anything, "token").Once()
+
+		router := &Router{UserInfo: &UserInfo{Handler: s}}
+		router.Handler(r)
+
+		s.AssertExpectations(t)
+	}
+}
+
+func TestUserInfo_Disabled_Return404(t *testing.T) {
+	r := makeRequestCtx(nil)
+	r.Request.Header.SetMethod("GET")
+	r.Request.SetRequestURI("/v5/userinfo")
+
+	router := &Router{}
+	router.Handler(r)
+
+	assert.Equal(t, fasthttp.StatusNotFound, r.Response.StatusCode())
+}
@@ -9,6 +9,9 @@ import (
 	"github.com/dghubble/sling"
 )
 
+// clientID is the public client that is registered by the setup
+const clientID = "integration"
+
 func MakeClient() *client {
 	return &client{
 		c: sling.New().Base("http://localhost:8080"),
@@ -67,8 +70,9 @@ func (c *client) GetCode(msg core.EncryptedMessage) (string, error) {
 func (c *client) GetToken(code string) (*core.Token, error) {
 	s, e := new(core.Token), new(errorResponse)
 	resp, err := c.c.New().Post("v5/authorization/actions/obtain-access-token").BodyJSON(core.AccessCode{
-		GrantType: "access_code",
-		Code:      code,
+		ClientCredentials: core.ClientCredentials{ID: clientID},
+		GrantType:         "access_code",
+		Code:              code,
 	}).Receive(s, e)
 	if err == io.EOF {
 		return nil, &errorResponse{StatusCode: resp.StatusCode}
@@ -88,6 +92,7 @@ func (c *client) Refresh(token string) (*core.RefreshAccessToken, error) {
 	resp, err := c.c.New().Post("v5/authorization/actions/refresh-access-token").BodyJSON(map[string]string{
 		"grant_type":    "refresh_token",
 		"refresh_token": token,
+		"client_id":     clientID,
 	}).Receive(s, e)
 	if err == io.EOF {
 		return nil, &errorResponse{StatusCode: resp.StatusCode}
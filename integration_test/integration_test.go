@@ -47,6 +47,7 @@ func TestMain(m *testing.M) {
 	setupClient()
 	setupUntrustedClient()
 	setupCardsService()
+	setupOAuthClient()
 
 	authKeyPair, err := config.Crypto.GenerateKeypair()
 	if err != nil {
@@ -232,8 +233,9 @@ func TestGetMessage_ReqBodyIncorrect_ReturnErr(t *testing.T) {
 
 func TestGetToken_UnsupportedGrantType_ReturnErr(t *testing.T) {
 	b, _ := json.Marshal(core.AccessCode{
-		GrantType: "broken",
-		Code:      "",
+		ClientCredentials: core.ClientCredentials{ID: clientID},
+		GrantType:         "broken",
+		Code:              "",
 	})
 	resp, err := http.Post("http://localhost:8080/v5/authorization/actions/obtain-access-token", "application/json", ioutil.NopCloser(bytes.NewReader(b)))
 	assert.Nil(t, err)
@@ -277,7 +279,7 @@ func TestGetToken_CodeExpired_ReturnErr(t *testing.T) {
 	codes := config.session.DB("").C("code")
 	codes.Insert(db.Code{
 		Code:    code,
-		OwnerID: "123",
+		Grant:   db.Grant{OwnerID: "123"},
 		Used:    false,
 		Expired: time.Now().UTC().Add(-repo.CodeExpiresIn),
 	})
@@ -295,7 +297,7 @@ func TestGetCode_AttemptExpired_ReturnErr(t *testing.T) {
 	c := MakeClient()
 	attempts := config.session.DB("").C("attempt")
 	attempts.Insert(db.Attempt{
-		OwnerID: "123",
+		Grant:   db.Grant{OwnerID: "123"},
 		Message: "secret message",
 		ID:      code,
 		Expired: time.Now().UTC().Add(-repo.AttemptExpiresIn),
@@ -366,3 +368,23 @@ func TestGetMessage_CardNotGlobal_ReturnErr(t *testing.T) {
 	_, err := c.GetMessage(config.untrustedClient.ID)
 	assert.Equal(t, &errorResponse{StatusCode: http.StatusBadRequest, Code: core.StatusErrorCardInvalid}, err)
 }
+
+func TestGetToken_UnknownClient_ReturnErr(t *testing.T) {
+	b, _ := json.Marshal(core.AccessCode{
+		ClientCredentials: core.ClientCredentials{ID: "unknown"},
+		GrantType:         "access_code",
+		Code:              "code",
+	})
+	resp, err := http.Post("http://localhost:8080/v5/authorization/actions/obtain-access-token", "application/json", ioutil.NopCloser(bytes.NewReader(b)))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+
+	b, _ = ioutil.ReadAll(resp.Body)
+	e := &errorResponse{
+		StatusCode: resp.StatusCode,
+	}
+	err = json.Unmarshal(b, e)
+	assert.Nil(t, err)
+
+	assert.Equal(t, &errorResponse{Code: core.StatusErrorClientAuthenticationFailed, StatusCode: http.StatusUnauthorized}, e)
+}
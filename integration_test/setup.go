@@ -15,6 +15,7 @@ import (
 	virgil "gopkg.in/virgil.v5/sdk"
 
 	"github.com/VirgilSecurity/virgil-services-auth/app"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
 )
 
 type entity struct {
@@ -218,6 +219,18 @@ func setupCardsService() {
 	config.cardsHost = "http://" + l.Addr().String()
 }
 
+func setupOAuthClient() {
+	err := config.session.DB("").C("client").Insert(db.Client{
+		ID:                clientID,
+		GrantTypes:        []string{"access_code", "refresh_token"},
+		IssueRefreshToken: true,
+	})
+	if err != nil {
+		fmt.Println("Cannot register a client:", err)
+		os.Exit(1)
+	}
+}
+
 func setupAuthService() {
 	pk, err := config.Crypto.ExportPublicKey(config.authority.PK)
 	if err != nil {
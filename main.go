@@ -1,7 +1,13 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
 	"github.com/VirgilSecurity/virgil-services-auth/app"
+	"github.com/VirgilSecurity/virgil-services-auth/services"
 	"github.com/namsral/flag"
 )
 
@@ -9,6 +15,7 @@ var (
 	config  app.Config
 	Version string
 	address string
+	tenant  string
 )
 
 func init() {
@@ -21,14 +28,83 @@ func init() {
 	flag.StringVar(&config.PrivateServiceKey.Key, "key", "", `(*) Private key for response signing and message decryption (encoded into bas64)`)
 	flag.StringVar(&config.PrivateServiceKey.Password, "key-password", "", `Passphrase for the private key`)
 	flag.StringVar(&config.VirgilClient.AuthorityCardID, "authority-id", "", "Authority card id. A client's card must have signature of the authority. By default usage Virgil Cards Service id.")
+	flag.StringVar(&config.VirgilClient.AuthoritiesFile, "authorities", "", "Path to the JSON list of authorities, a client's card must have signature of one of them. It replaces authority-id and authority-pubkey")
 	flag.StringVar(&config.VirgilClient.AuthorityPublicKey, "authority-pubkey", "", "Authority public key (encoded into bas64).  Authority card id. A client's card must have signature of the authority. By default usege Virgil Cards Service public key.")
 	flag.BoolVar(&config.UseSha256Fingerprints, "use-sha256-fingerprints", false, "Use for encryption/decryption SHA256 (old format)")
+	flag.BoolVar(&config.DualFingerprints, "dual-fingerprints", false, "Accept the keys of both fingerprint formats, e.g. during the migration from the SHA256 format")
+	flag.StringVar(&config.PolicyFile, "policy", "", "Path to the policy file that maps cards to allowed scopes. The file is reloaded on SIGHUP")
+	flag.StringVar(&config.Issuer, "issuer", "Virgil Security, Inc", "Issuer of access tokens. It's the expected audience of client assertions")
+	flag.StringVar(&config.RegistrationToken, "registration-token", "", "Initial access token of the dynamic client registration. The registration is disabled if it's empty")
+	flag.BoolVar(&config.RequireRequestSign, "require-request-sign", false, "Reject handshakes that aren't signed by a registered application card (request_sign)")
+	flag.StringVar(&config.PublicURL, "public-url", "", "External URL of the service, e.g. https://auth.example.com. It's used to build URLs returned to clients")
+	flag.StringVar(&config.PairwiseSecret, "pairwise-secret", "", "Secret of pairwise subject identifiers in id tokens. Clients with subject_type pairwise can't get id tokens if it's empty")
+	flag.DurationVar(&config.UserInfoCacheTTL, "userinfo-cache-ttl", 5*time.Minute, "How long the userinfo endpoint keeps the card information. The cache is disabled if it's 0")
+	flag.DurationVar(&config.CardRevalidation, "card-revalidation-interval", 10*time.Minute, "How long the owner card is trusted after it was checked on refresh. The card is checked on every refresh if it's 0")
+	flag.DurationVar(&config.CardsService.Timeout, "cards-timeout", 5*time.Second, "Timeout of a call of the Cards service. There is no timeout if it's 0")
+	flag.IntVar(&config.CardsService.Retries, "cards-retries", 2, "Number of retries of a call of the Cards service failed with a server or network error")
+	flag.DurationVar(&config.CardsService.RetryBackoff, "cards-retry-backoff", 100*time.Millisecond, "Delay before the first retry of the Cards service call, it doubles on the next retries")
+	flag.IntVar(&config.CardsService.FailureThreshold, "cards-failure-threshold", 5, "Number of failed calls in a row after which the calls of the Cards service fail fast. The circuit breaker is disabled if it's 0")
+	flag.DurationVar(&config.CardsService.OpenTimeout, "cards-open-timeout", 30*time.Second, "How long the calls of the Cards service fail fast before a trial call")
+	flag.DurationVar(&config.CardCache.TTL, "card-cache-ttl", time.Minute, "How long a card got from the Cards service is kept. The cache is disabled if it's 0")
+	flag.DurationVar(&config.CardCache.NotFoundTTL, "card-cache-not-found-ttl", 10*time.Second, "How long a card that wasn't found is kept as not found")
+	flag.IntVar(&config.CardCache.Size, "card-cache-size", 10000, "Max number of cards in the cache, the least recently used cards are evicted. The size is unbounded if it's 0")
+	flag.StringVar(&config.AdminToken, "admin-token", "", "Token of the admin calls, e.g. the card cache purge. The admin calls are disabled if it's empty")
+	flag.StringVar(&config.CardStore.Kind, "card-store", app.CardStoreRemote, "Where the cards are got from: remote (the Cards service), mongo (the card collection of the db) or dir (the card files of card-store-dir)")
+	flag.BoolVar(&config.InlineCards, "inline-cards", false, "Accept the raw cards sent with handshakes, the cards are verified locally instead of the Cards service lookup")
+	flag.StringVar(&config.CardStore.Dir, "card-store-dir", "", "Directory of the card files {card id}.card of the dir card store")
+	flag.StringVar(&config.TenantsFile, "tenants", "", "Path to the JSON list of tenants, the instance serves several Virgil applications then")
+	flag.StringVar(&tenant, "tenant", "", "Name of the tenant whose local card store is changed by the card commands")
 	flag.StringVar(&address, "address", ":8080", "Virgil Auth service address")
 }
 
 func main() {
 	config.Version = Version
 	flag.Parse()
+
+	// [CMD] hash-client-secret {secret} prints the hash of a client secret for the client registry
+	if flag.Arg(0) == "hash-client-secret" {
+		h, err := services.HashClientSecret(flag.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot hash the secret:", err)
+			os.Exit(1)
+		}
+		fmt.Println(h)
+		return
+	}
+
+	if flag.Arg(0) == "import-card" || flag.Arg(0) == "remove-card" {
+		var err error
+		if config, err = app.TenantConfig(config, tenant); err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot load the tenant:", err)
+			os.Exit(1)
+		}
+	}
+
+	// [CMD] import-card {file} verifies the exported card and adds it to the local card store
+	if flag.Arg(0) == "import-card" {
+		raw, err := ioutil.ReadFile(flag.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot read the card:", err)
+			os.Exit(1)
+		}
+		id, err := app.ImportCard(config, string(raw))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot import the card:", err)
+			os.Exit(1)
+		}
+		fmt.Println(id)
+		return
+	}
+
+	// [CMD] remove-card {card id} removes the card from the local card store
+	if flag.Arg(0) == "remove-card" {
+		if err := app.RemoveCard(config, flag.Arg(1)); err != nil {
+			fmt.Fprintln(os.Stderr, "Cannot remove the card:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	app.Init(config)
 	app.Run(address)
 }
@@ -0,0 +1,141 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+// Authority is a registration authority whose signature makes the cards trusted. Scopes restrict what the cards of
+// the authority may obtain, the empty list allows any scope.
+//
+// Authorities file example:
+//
+//	[
+//	  {
+//	    "name": "partner",
+//	    "card_id": "<authority card id>",
+//	    "public_key": "<base64 encoded public key>",
+//	    "scopes": ["profile"],
+//	    "access_token_ttl": 300
+//	  }
+//	]
+type Authority struct {
+	Name           string   `json:"name"`
+	CardID         string   `json:"card_id"`
+	PublicKey      string   `json:"public_key"`
+	Scopes         []string `json:"scopes"`
+	AccessTokenTTL int      `json:"access_token_ttl"`
+}
+
+// LoadAuthorities reads the list of authorities from the file.
+func LoadAuthorities(path string) ([]Authority, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var list []Authority
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("authorities %v: %v", path, err)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("authorities %v: the list is empty", path)
+	}
+	names := make(map[string]bool, len(list))
+	for i, a := range list {
+		switch {
+		case a.Name == "":
+			return nil, fmt.Errorf("authorities %v: authority #%v: name missed", path, i)
+		case names[a.Name]:
+			return nil, fmt.Errorf("authorities %v: authority %v is duplicated", path, a.Name)
+		case a.CardID == "" || a.PublicKey == "":
+			return nil, fmt.Errorf("authorities %v: authority %v: card_id or public_key missed", path, a.Name)
+		case a.AccessTokenTTL < 0:
+			return nil, fmt.Errorf("authorities %v: authority %v: access_token_ttl is negative", path, a.Name)
+		}
+		names[a.Name] = true
+	}
+	return list, nil
+}
+
+type PolicyEvaluator interface {
+	Evaluate(req core.PolicyRequest) core.PolicyDecision
+}
+
+// Authorities applies the restrictions of the authority that signed the card on top of the policy. The first
+// authority of the list among the card signers is matched, the card that isn't signed by any of them is denied. The
+// authority restrictions are enforced in the shadow mode of the policy as well, so they're returned apart from the
+// policy decision.
+type Authorities struct {
+	List   []Authority
+	Policy PolicyEvaluator // optional, the authority restrictions are the only ones without it
+}
+
+func (a *Authorities) Evaluate(req core.PolicyRequest) core.PolicyDecision {
+	auth := a.match(req.Signers)
+	if auth == nil {
+		return core.PolicyDecision{}
+	}
+
+	authScope, ok := auth.narrowScope(req.Scope, req.Scope)
+	if !ok {
+		return core.PolicyDecision{}
+	}
+
+	d := core.PolicyDecision{Allowed: true, Scope: req.Scope}
+	if a.Policy != nil {
+		d = a.Policy.Evaluate(req)
+		if !d.Allowed && !d.Shadow {
+			return d
+		}
+	}
+	if d.Allowed {
+		if d.Scope, ok = auth.narrowScope(req.Scope, d.Scope); !ok {
+			if !d.Shadow {
+				return core.PolicyDecision{}
+			}
+			d = core.PolicyDecision{Shadow: true}
+		}
+		if auth.AccessTokenTTL > 0 && (d.TokenTTL == 0 || auth.AccessTokenTTL < d.TokenTTL) {
+			d.TokenTTL = auth.AccessTokenTTL
+		}
+	}
+	d.Authority, d.AuthorityScope, d.AuthorityTokenTTL = auth.Name, authScope, auth.AccessTokenTTL
+	return d
+}
+
+// narrowScope restricts the granted scope by the authority scopes. The scope granted to a wildcard request is
+// intersected with them, other requests must be permitted entirely.
+func (a *Authority) narrowScope(requested, granted string) (string, bool) {
+	if len(a.Scopes) == 0 {
+		return granted, true
+	}
+	if requested != core.ScopeWildcard && requested != "" {
+		return core.NarrowScope(granted, a.Scopes)
+	}
+	if granted == core.ScopeWildcard || granted == "" || contains(a.Scopes, core.ScopeWildcard) {
+		return core.NarrowScope(granted, a.Scopes)
+	}
+	var scopes []string
+	for _, s := range strings.Fields(granted) {
+		if contains(a.Scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if len(scopes) == 0 {
+		return "", false
+	}
+	return strings.Join(scopes, " "), true
+}
+
+func (a *Authorities) match(signers []string) *Authority {
+	for i := range a.List {
+		if contains(signers, a.List[i].CardID) {
+			return &a.List[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+var testAuthorities = []Authority{
+	{Name: "own", CardID: "own card"},
+	{Name: "partner", CardID: "partner card", Scopes: []string{"profile"}, AccessTokenTTL: 60},
+}
+
+type fixedPolicy core.PolicyDecision
+
+func (p fixedPolicy) Evaluate(req core.PolicyRequest) core.PolicyDecision {
+	return core.PolicyDecision(p)
+}
+
+func TestAuthoritiesEvaluate(t *testing.T) {
+	table := []struct {
+		name     string
+		policy   PolicyEvaluator
+		req      core.PolicyRequest
+		expected core.PolicyDecision
+	}{
+		{
+			name:     "unrestricted authority",
+			req:      core.PolicyRequest{Signers: []string{"self", "own card"}, Scope: "*"},
+			expected: core.PolicyDecision{Allowed: true, Scope: "*", Authority: "own", AuthorityScope: "*"},
+		},
+		{
+			name:     "wildcard is narrowed to the authority scopes",
+			req:      core.PolicyRequest{Signers: []string{"self", "partner card"}, Scope: "*"},
+			expected: core.PolicyDecision{Allowed: true, Scope: "profile", TokenTTL: 60, Authority: "partner", AuthorityScope: "profile", AuthorityTokenTTL: 60},
+		},
+		{
+			name:     "scope is not allowed to the authority",
+			req:      core.PolicyRequest{Signers: []string{"self", "partner card"}, Scope: "mail"},
+			expected: core.PolicyDecision{},
+		},
+		{
+			name:     "first authority of the list wins",
+			req:      core.PolicyRequest{Signers: []string{"partner card", "own card"}, Scope: "mail"},
+			expected: core.PolicyDecision{Allowed: true, Scope: "mail", Authority: "own", AuthorityScope: "mail"},
+		},
+		{
+			name:     "card isn't signed by any authority",
+			req:      core.PolicyRequest{Signers: []string{"self"}, Scope: "*"},
+			expected: core.PolicyDecision{},
+		},
+		{
+			name:   "policy scope is narrowed, the shorter ttl wins",
+			policy: fixedPolicy{Allowed: true, Rule: "all", Scope: "profile mail", TokenTTL: 300, InlineCards: true},
+			req:    core.PolicyRequest{Signers: []string{"partner card"}, Scope: "*"},
+			expected: core.PolicyDecision{Allowed: true, Rule: "all", Scope: "profile", TokenTTL: 60, InlineCards: true,
+				Authority: "partner", AuthorityScope: "profile", AuthorityTokenTTL: 60},
+		},
+		{
+			name:     "policy denial",
+			policy:   fixedPolicy{},
+			req:      core.PolicyRequest{Signers: []string{"own card"}, Scope: "*"},
+			expected: core.PolicyDecision{},
+		},
+		{
+			name:     "authority restrictions are kept apart from the shadow denial",
+			policy:   fixedPolicy{Shadow: true},
+			req:      core.PolicyRequest{Signers: []string{"partner card"}, Scope: "*"},
+			expected: core.PolicyDecision{Shadow: true, Authority: "partner", AuthorityScope: "profile", AuthorityTokenTTL: 60},
+		},
+		{
+			name:     "shadow mode doesn't lift the authority restrictions",
+			policy:   fixedPolicy{Shadow: true, Allowed: true, Scope: "mail"},
+			req:      core.PolicyRequest{Signers: []string{"partner card"}, Scope: "mail"},
+			expected: core.PolicyDecision{},
+		},
+	}
+	for _, v := range table {
+		a := &Authorities{List: testAuthorities, Policy: v.policy}
+		assert.Equal(t, v.expected, a.Evaluate(v.req), v.name)
+	}
+}
+
+func TestLoadAuthorities(t *testing.T) {
+	path := writePolicy(t, `[{"name": "partner", "card_id": "card", "public_key": "key", "scopes": ["profile"], "access_token_ttl": 60}]`)
+	defer os.Remove(path)
+
+	list, err := LoadAuthorities(path)
+
+	require.NoError(t, err)
+	assert.Equal(t, []Authority{{Name: "partner", CardID: "card", PublicKey: "key", Scopes: []string{"profile"}, AccessTokenTTL: 60}}, list)
+}
+
+func TestLoadAuthorities_InvalidList_ReturnErr(t *testing.T) {
+	table := []string{
+		`[]`,
+		`[{"card_id": "card", "public_key": "key"}]`,
+		`[{"name": "a", "public_key": "key"}]`,
+		`[{"name": "a", "card_id": "card", "public_key": "key", "access_token_ttl": -1}]`,
+		`[{"name": "a", "card_id": "card", "public_key": "key"}, {"name": "a", "card_id": "card2", "public_key": "key"}]`,
+	}
+	for _, v := range table {
+		path := writePolicy(t, v)
+		_, err := LoadAuthorities(path)
+		os.Remove(path)
+
+		assert.Error(t, err, v)
+	}
+}
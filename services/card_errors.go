@@ -0,0 +1,102 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+
+	verrors "gopkg.in/virgil.v5/errors"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+var cardIDFormat = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// CardErrors adapts the card manager of the Virgil SDK: its errors are returned as core.CardError. The errors it
+// can't classify are returned as they are. The card manager doesn't verify the cards, they're verified by Verifier
+// that classifies the failures itself, e.g. CardSignatures.
+type CardErrors struct {
+	Cards    CardSource
+	Verifier virgil.CardVerifier // optional, the cards are returned as they are without it
+}
+
+func (c *CardErrors) GetCard(id string) (*virgil.Card, error) {
+	if !cardIDFormat.MatchString(id) {
+		return nil, &core.CardError{Kind: core.CardIDInvalid, Err: fmt.Errorf("invalid card id %q", id)}
+	}
+	card, err := c.Cards.GetCard(id)
+	if err != nil {
+		return nil, cardError(err)
+	}
+	if err = c.verify(card); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+func (c *CardErrors) SearchCards(identity string) ([]*virgil.Card, error) {
+	cards, err := c.Cards.SearchCards(identity)
+	if err != nil {
+		return nil, cardError(err)
+	}
+	for _, card := range cards {
+		if err = c.verify(card); err != nil {
+			return nil, err
+		}
+	}
+	return cards, nil
+}
+
+func (c *CardErrors) verify(card *virgil.Card) error {
+	if c.Verifier == nil {
+		return nil
+	}
+	if err := c.Verifier.VerifyCard(card); err != nil {
+		if core.CardErrorKindOf(err) == 0 {
+			return &core.CardError{Kind: core.CardSignatureInvalid, Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+func cardError(err error) error {
+	if core.CardErrorKindOf(err) != 0 {
+		return err
+	}
+	if kind := sdkErrorKind(err); kind != 0 {
+		return &core.CardError{Kind: kind, Err: err}
+	}
+	return err
+}
+
+// sdkErrorKind classifies the error by the HTTP status of the Cards service or the network error. The wrapped errors
+// are classified by their causes.
+func sdkErrorKind(err error) core.CardErrorKind {
+	for cause := err; cause != nil; {
+		if verr, ok := verrors.ToSdkError(cause); ok && verr.IsHTTPError() {
+			switch code := verr.HTTPErrorCode(); {
+			case code == http.StatusNotFound:
+				return core.CardNotFound
+			case code == http.StatusUnauthorized || code == http.StatusForbidden:
+				return core.CardForbidden
+			case code >= http.StatusInternalServerError:
+				return core.CardsUnavailable
+			}
+			return 0
+		}
+		if _, ok := cause.(net.Error); ok {
+			return core.CardsUnavailable
+		}
+		c, ok := cause.(interface {
+			Cause() error
+		})
+		if !ok {
+			break
+		}
+		cause = c.Cause()
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/virgil.v5/errors"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+const (
+	validCardID     = "8a1c1f2b3d4e5f60718293a4b5c6d7e8f9011223344556677889aabbccddeeff"
+	unsignedCardID  = "0000000000000000000000000000000000000000000000000000000000000001"
+	badSignCardID   = "0000000000000000000000000000000000000000000000000000000000000002"
+	notFoundCardID  = "0000000000000000000000000000000000000000000000000000000000000003"
+	forbiddenCardID = "0000000000000000000000000000000000000000000000000000000000000004"
+	brokenCardID    = "0000000000000000000000000000000000000000000000000000000000000005"
+)
+
+// fakeCardsServer serves the cards and the errors of the Cards service
+func fakeCardsServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/card/v5/")
+		status := http.StatusOK
+		body := map[string]interface{}{"id": id, "signers": []string{"self", "virgil"}}
+		switch {
+		case id == notFoundCardID:
+			status, body = http.StatusNotFound, map[string]interface{}{"code": 10001, "message": "Entity was not found"}
+		case id == forbiddenCardID:
+			status, body = http.StatusForbidden, map[string]interface{}{"code": 20500, "message": "Access denied"}
+		case id == brokenCardID || r.URL.Path == "/card/v5/actions/search":
+			status, body = http.StatusBadGateway, map[string]interface{}{"code": 10000, "message": "Internal error"}
+		case id == unsignedCardID:
+			body["signers"] = []string{"self"}
+		case id == badSignCardID:
+			body["broken_signature"] = true
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}))
+}
+
+// sdkCards requests the fake Cards server and fails the way the card manager of the Virgil SDK does. It doesn't verify
+// the cards, the broken signature is marked by the signature data.
+type sdkCards struct {
+	url string
+}
+
+func (c sdkCards) GetCard(id string) (*virgil.Card, error) {
+	resp, err := http.Get(c.url + "/card/v5/" + id)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		ID              string   `json:"id"`
+		Signers         []string `json:"signers"`
+		BrokenSignature bool     `json:"broken_signature"`
+		Code            int      `json:"code"`
+		Message         string   `json:"message"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewServiceError(body.Code, resp.StatusCode, body.Message)
+	}
+	card := &virgil.Card{Id: body.ID}
+	for _, s := range body.Signers {
+		sign := &virgil.CardSignature{Signer: s, Signature: []byte("signature")}
+		if body.BrokenSignature {
+			sign.Signature = []byte("broken")
+		}
+		card.Signatures = append(card.Signatures, sign)
+	}
+	return card, nil
+}
+
+// signatureChecker fails the cards with a broken signature of the signer
+type signatureChecker string
+
+func (s signatureChecker) VerifyCard(card *virgil.Card) error {
+	for _, sign := range card.Signatures {
+		if sign.Signer == string(s) && string(sign.Signature) == "broken" {
+			return fmt.Errorf("any message")
+		}
+	}
+	return nil
+}
+
+var testCardSignatures = &CardSignatures{Checks: []SignatureCheck{
+	{Signers: []string{"self"}, Verifier: signatureChecker("self")},
+	{Signers: []string{"virgil", "authority"}, Verifier: signatureChecker("virgil")},
+}}
+
+func (c sdkCards) SearchCards(identity string) ([]*virgil.Card, error) {
+	resp, err := http.Post(c.url+"/card/v5/actions/search", "application/json", strings.NewReader(identity))
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return nil, errors.NewHttpError(resp.StatusCode, "")
+}
+
+func TestCardErrors_GetCard_ErrorsClassified(t *testing.T) {
+	srv := fakeCardsServer()
+	defer srv.Close()
+
+	table := map[string]core.CardErrorKind{
+		validCardID:     0,
+		notFoundCardID:  core.CardNotFound,
+		forbiddenCardID: core.CardForbidden,
+		"not hex id":    core.CardIDInvalid,
+		unsignedCardID:  core.CardSignatureMissing,
+		badSignCardID:   core.CardSignatureInvalid,
+		brokenCardID:    core.CardsUnavailable,
+	}
+	c := &CardErrors{Cards: sdkCards{url: srv.URL}, Verifier: testCardSignatures}
+	for id, kind := range table {
+		card, err := c.GetCard(id)
+
+		assert.Equal(t, kind, core.CardErrorKindOf(err), id)
+		if kind == 0 {
+			assert.NoError(t, err)
+			assert.Equal(t, id, card.Id)
+		}
+	}
+}
+
+func TestCardErrors_ServerDown_ReturnCardsUnavailable(t *testing.T) {
+	srv := fakeCardsServer()
+	srv.Close()
+
+	c := &CardErrors{Cards: sdkCards{url: srv.URL}}
+	_, err := c.GetCard(validCardID)
+	assert.Equal(t, core.CardsUnavailable, core.CardErrorKindOf(err))
+
+	_, err = c.SearchCards("alice")
+	assert.Equal(t, core.CardsUnavailable, core.CardErrorKindOf(err))
+}
+
+func TestCardErrors_SearchCards_ErrorsClassified(t *testing.T) {
+	srv := fakeCardsServer()
+	defer srv.Close()
+
+	c := &CardErrors{Cards: sdkCards{url: srv.URL}}
+	_, err := c.SearchCards("alice")
+
+	assert.Equal(t, core.CardsUnavailable, core.CardErrorKindOf(err))
+}
+
+func TestCardErrors_UnknownErr_ReturnedAsIs(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("GetCard", validCardID).Return(nil, fmt.Errorf("format"))
+
+	_, err := (&CardErrors{Cards: c}).GetCard(validCardID)
+
+	assert.Equal(t, fmt.Errorf("format"), err)
+}
+
+func TestCardErrors_VerifierErr_ReturnSignatureInvalid(t *testing.T) {
+	srv := fakeCardsServer()
+	defer srv.Close()
+
+	v := new(FakeCardVerifier)
+	v.On("VerifyCard", validCardID).Return(fmt.Errorf("does not have signature for verifier ID virgil"))
+
+	_, err := (&CardErrors{Cards: sdkCards{url: srv.URL}, Verifier: v}).GetCard(validCardID)
+
+	assert.Equal(t, core.CardSignatureInvalid, core.CardErrorKindOf(err))
+}
@@ -0,0 +1,47 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+// SignatureCheck is a signature that the card must have: it's made by one of Signers and is checked by Verifier.
+type SignatureCheck struct {
+	Signers  []string
+	Verifier virgil.CardVerifier
+}
+
+// CardSignatures verifies the required signatures of the card one by one, so a failure is classified by the card
+// itself rather than by the message of the Virgil SDK. A card without the signature of a check is
+// core.CardSignatureMissing, a card whose signature doesn't pass the check is core.CardSignatureInvalid.
+type CardSignatures struct {
+	Checks []SignatureCheck
+}
+
+func (v *CardSignatures) VerifyCard(card *virgil.Card) error {
+	for _, c := range v.Checks {
+		if !signedByAny(card, c.Signers) {
+			return &core.CardError{
+				Kind: core.CardSignatureMissing,
+				Err:  fmt.Errorf("card %v isn't signed by %v", card.Id, strings.Join(c.Signers, " or ")),
+			}
+		}
+		if err := c.Verifier.VerifyCard(card); err != nil {
+			return &core.CardError{Kind: core.CardSignatureInvalid, Err: err}
+		}
+	}
+	return nil
+}
+
+func signedByAny(card *virgil.Card, signers []string) bool {
+	for _, s := range card.Signatures {
+		if contains(signers, s.Signer) {
+			return true
+		}
+	}
+	return false
+}
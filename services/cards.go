@@ -0,0 +1,166 @@
+package services
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+type CardSource interface {
+	GetCard(id string) (*virgil.Card, error)
+	SearchCards(identity string) ([]*virgil.Card, error)
+}
+
+// CardCache keeps the cards got from the Cards service. The core.CardNotFound errors are kept for NotFoundTTL, other
+// errors aren't kept. Concurrent lookups of the same card wait for a single request to the service.
+type CardCache struct {
+	Cards       CardSource
+	TTL         time.Duration
+	NotFoundTTL time.Duration
+	MaxSize     int // the least recently used cards are evicted above the size, 0 is unbounded
+
+	mu        sync.Mutex
+	entries   map[string]*list.Element
+	lru       list.List
+	calls     map[string]*cardCall
+	hits      uint64
+	misses    uint64
+	coalesced uint64
+	evictions uint64
+}
+
+type cachedCard struct {
+	id      string
+	card    *virgil.Card
+	err     error
+	expired time.Time
+}
+
+// cardCall is a lookup in flight, the waiters read the result after done is closed
+type cardCall struct {
+	done chan struct{}
+	card *virgil.Card
+	err  error
+}
+
+func (c *CardCache) GetCard(id string) (*virgil.Card, error) {
+	c.mu.Lock()
+	if e, ok := c.cached(id); ok {
+		c.hits++
+		c.mu.Unlock()
+		return e.card, e.err
+	}
+	if call, ok := c.calls[id]; ok {
+		c.coalesced++
+		c.mu.Unlock()
+		<-call.done
+		return call.card, call.err
+	}
+	c.misses++
+	call := &cardCall{done: make(chan struct{})}
+	if c.calls == nil {
+		c.calls = make(map[string]*cardCall)
+	}
+	c.calls[id] = call
+	c.mu.Unlock()
+
+	call.card, call.err = c.Cards.GetCard(id)
+
+	c.mu.Lock()
+	// the card could be purged while it was requested, the result isn't kept then
+	if c.calls[id] == call {
+		delete(c.calls, id)
+		c.store(id, call.card, call.err)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.card, call.err
+}
+
+// SearchCards isn't cached, a new card of the identity must be found at once
+func (c *CardCache) SearchCards(identity string) ([]*virgil.Card, error) {
+	return c.Cards.SearchCards(identity)
+}
+
+// Purge removes the card from the cache, the next lookup requests the Cards service
+func (c *CardCache) Purge(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[id]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, id)
+	}
+	delete(c.calls, id)
+}
+
+func (c *CardCache) Name() string {
+	return "CardCache"
+}
+
+// Info returns the cache metrics, it's shown by the health info
+func (c *CardCache) Info() (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]interface{}{
+		"size":      len(c.entries),
+		"hits":      c.hits,
+		"misses":    c.misses,
+		"coalesced": c.coalesced,
+		"evictions": c.evictions,
+	}, nil
+}
+
+// cached returns the unexpired entry of the card. It must be called under the lock.
+func (c *CardCache) cached(id string) (*cachedCard, bool) {
+	el, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cachedCard)
+	if time.Now().After(e.expired) {
+		c.lru.Remove(el)
+		delete(c.entries, id)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+// store keeps the lookup result. It must be called under the lock.
+func (c *CardCache) store(id string, card *virgil.Card, err error) {
+	ttl := c.TTL
+	if err != nil {
+		if core.CardErrorKindOf(err) != core.CardNotFound {
+			return
+		}
+		ttl = c.NotFoundTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]*list.Element)
+	}
+	e := &cachedCard{id: id, card: card, err: err, expired: time.Now().Add(ttl)}
+	if el, ok := c.entries[id]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[id] = c.lru.PushFront(e)
+
+	for c.MaxSize > 0 && len(c.entries) > c.MaxSize {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cachedCard).id)
+		c.evictions++
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+func (c *FakeCardGetter) SearchCards(identity string) ([]*virgil.Card, error) {
+	args := c.Called(identity)
+	cards, _ := args.Get(0).([]*virgil.Card)
+	return cards, args.Error(1)
+}
+
+func TestCardCache_CardGot_ServedFromCache(t *testing.T) {
+	card := &virgil.Card{Id: "id"}
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(card, nil).Once()
+
+	cache := &CardCache{Cards: c, TTL: time.Minute}
+	for i := 0; i < 3; i++ {
+		got, err := cache.GetCard("id")
+		assert.NoError(t, err)
+		assert.Equal(t, card, got)
+	}
+
+	c.AssertExpectations(t)
+	info, _ := cache.Info()
+	assert.Equal(t, uint64(2), info["hits"])
+	assert.Equal(t, uint64(1), info["misses"])
+	assert.Equal(t, 1, info["size"])
+}
+
+func TestCardCache_CardNotFound_KeptForNotFoundTTL(t *testing.T) {
+	notFound := &core.CardError{Kind: core.CardNotFound, Err: fmt.Errorf("Entity was not found")}
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(nil, notFound).Twice()
+
+	cache := &CardCache{Cards: c, TTL: time.Minute, NotFoundTTL: 20 * time.Millisecond}
+	_, err := cache.GetCard("id")
+	assert.Equal(t, notFound, err)
+	_, err = cache.GetCard("id")
+	assert.Equal(t, notFound, err)
+
+	time.Sleep(30 * time.Millisecond)
+	_, err = cache.GetCard("id")
+	assert.Equal(t, notFound, err)
+
+	c.AssertExpectations(t)
+}
+
+func TestCardCache_ServiceErr_NotKept(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(nil, &core.CardError{Kind: core.CardsUnavailable, Err: fmt.Errorf("internal server error")}).Twice()
+
+	cache := &CardCache{Cards: c, TTL: time.Minute, NotFoundTTL: time.Minute}
+	cache.GetCard("id")
+	cache.GetCard("id")
+
+	c.AssertExpectations(t)
+}
+
+func TestCardCache_ConcurrentLookups_Coalesced(t *testing.T) {
+	release := make(chan time.Time)
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").WaitUntil(release).Return(&virgil.Card{Id: "id"}, nil).Once()
+
+	cache := &CardCache{Cards: c, TTL: time.Minute}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			card, err := cache.GetCard("id")
+			assert.NoError(t, err)
+			assert.Equal(t, "id", card.Id)
+		}()
+	}
+	for {
+		info, _ := cache.Info()
+		if info["coalesced"] == uint64(4) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	c.AssertExpectations(t)
+}
+
+func TestCardCache_SizeExceeded_LeastRecentlyUsedEvicted(t *testing.T) {
+	c := new(FakeCardGetter)
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprint(i)
+		c.On("GetCard", id).Return(&virgil.Card{Id: id}, nil)
+	}
+
+	cache := &CardCache{Cards: c, TTL: time.Minute, MaxSize: 2}
+	cache.GetCard("0")
+	cache.GetCard("1")
+	cache.GetCard("0")
+	cache.GetCard("2") // evicts 1
+	cache.GetCard("0")
+	cache.GetCard("1")
+
+	c.AssertNumberOfCalls(t, "GetCard", 4)
+	info, _ := cache.Info()
+	assert.Equal(t, 2, info["size"])
+	assert.Equal(t, uint64(2), info["evictions"])
+}
+
+func TestCardCache_Purge_CardRequestedAgain(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(&virgil.Card{Id: "id"}, nil).Twice()
+
+	cache := &CardCache{Cards: c, TTL: time.Minute}
+	cache.GetCard("id")
+	cache.Purge("id")
+	cache.GetCard("id")
+
+	c.AssertExpectations(t)
+}
+
+func TestCardCache_SearchCards_NotCached(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("SearchCards", "alice").Return([]*virgil.Card{{Id: "id"}}, nil).Twice()
+
+	cache := &CardCache{Cards: c, TTL: time.Minute}
+	cache.SearchCards("alice")
+	cards, err := cache.SearchCards("alice")
+
+	assert.NoError(t, err)
+	assert.Len(t, cards, 1)
+	c.AssertExpectations(t)
+	c.AssertNotCalled(t, "GetCard", mock.Anything)
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
+)
+
+const (
+	ClientAssertionTypeJWT = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+	maxClientAssertionLifetime = 10 * time.Minute
+)
+
+type KeyImporter interface {
+	ImportPublicKey([]byte) (interface {
+		IsPublic() bool
+		Identifier() []byte
+	}, error)
+}
+
+type ClientCrypto interface {
+	KeyImporter
+	repo.Crypto
+}
+
+// ClientAuthenticator checks credentials of registered clients. A client assertion must have `jti` that is unique
+// for the client (RFC 7523 section 3), so a captured assertion cannot authenticate the client again.
+type ClientAuthenticator struct {
+	Repo     db.ClientRepo
+	Crypto   ClientCrypto
+	Cards    CardGetter // optional, gets the key of clients that sign assertions with the application card
+	JTI      db.JTIRepo
+	Audience string // expected audience of client assertions
+}
+
+// Authenticate returns the client that the credentials belong to. It returns nil if the client is unknown or the
+// credentials are wrong, an error is returned only if the check cannot be performed.
+func (a *ClientAuthenticator) Authenticate(cred core.ClientCredentials) (*db.Client, error) {
+	if cred.ID == "" {
+		return nil, nil
+	}
+	c, err := a.Repo.Get(cred.ID)
+	if err != nil || c == nil {
+		return nil, err
+	}
+
+	switch {
+	case c.Public():
+		if cred.Secret != "" || cred.Assertion != "" {
+			return nil, nil
+		}
+	case cred.Assertion != "":
+		if cred.AssertionType != ClientAssertionTypeJWT {
+			return nil, nil
+		}
+		pk, err := a.assertionKey(c)
+		if err != nil || pk == nil {
+			return nil, err
+		}
+		claims, err := a.verifyAssertion(c, pk, cred.Assertion)
+		if err != nil {
+			return nil, nil
+		}
+		ok, err := a.JTI.Use(c.ID+":"+claims.Id, time.Unix(claims.ExpiresAt, 0))
+		if err != nil || !ok {
+			return nil, err
+		}
+	case c.SecretHash != "":
+		if bcrypt.CompareHashAndPassword([]byte(c.SecretHash), []byte(cred.Secret)) != nil {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	return c, nil
+}
+
+// assertionKey returns the key that verifies client assertions: the registered public key or the key of the
+// application card. It returns nil if the client has no key.
+func (a *ClientAuthenticator) assertionKey(c *db.Client) (interface{}, error) {
+	if len(c.PublicKey) != 0 {
+		pk, err := a.Crypto.ImportPublicKey(c.PublicKey)
+		if err != nil {
+			return nil, nil
+		}
+		return pk, nil
+	}
+	if !c.SignsWithCard() || a.Cards == nil {
+		return nil, nil
+	}
+	card, err := a.Cards.GetCard(c.AppCardID)
+	if err != nil {
+		return nil, err
+	}
+	return card.PublicKey, nil
+}
+
+func (a *ClientAuthenticator) verifyAssertion(c *db.Client, pk interface{}, assertion string) (*jwt.StandardClaims, error) {
+	claims := new(jwt.StandardClaims)
+	_, err := jwt.ParseWithClaims(assertion, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != repo.SigningMethodVirgilCrypt.Alg() {
+			return nil, fmt.Errorf("signing method %v is invalid", t.Method.Alg())
+		}
+		return repo.KeyCryptoPair{Crypto: a.Crypto, Key: pk}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != c.ID || claims.Subject != c.ID:
+		return nil, fmt.Errorf("assertion is not issued by the client")
+	case claims.Audience != a.Audience:
+		return nil, fmt.Errorf("assertion audience is invalid")
+	case claims.ExpiresAt == 0 || time.Unix(claims.ExpiresAt, 0).Sub(now) > maxClientAssertionLifetime:
+		return nil, fmt.Errorf("assertion lifetime is invalid")
+	case claims.Id == "":
+		return nil, fmt.Errorf("assertion has no jti")
+	}
+	return claims, nil
+}
+
+// HashClientSecret returns the hash of the client secret that is kept in the client registry
+func HashClientSecret(secret string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(h), nil
+}
+
+// Bcrypt hashes the client secrets and the registration access tokens
+type Bcrypt struct{}
+
+func (Bcrypt) Hash(secret string) (string, error) {
+	return HashClientSecret(secret)
+}
+
+func (Bcrypt) Compare(hash, secret string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
+)
+
+type FakeClientRepo struct {
+	mock.Mock
+}
+
+func (r *FakeClientRepo) Get(id string) (c *db.Client, err error) {
+	args := r.Called(id)
+	c, _ = args.Get(0).(*db.Client)
+	err = args.Error(1)
+	return
+}
+
+func TestAuthenticate_Secret(t *testing.T) {
+	hash, err := HashClientSecret("secret")
+	require.NoError(t, err)
+	client := &db.Client{ID: "client", SecretHash: hash}
+
+	r := new(FakeClientRepo)
+	r.On("Get", "client").Return(client, nil)
+	a := ClientAuthenticator{Repo: r}
+
+	c, err := a.Authenticate(core.ClientCredentials{ID: "client", Secret: "secret"})
+	assert.NoError(t, err)
+	assert.Equal(t, client, c)
+
+	c, err = a.Authenticate(core.ClientCredentials{ID: "client", Secret: "wrong"})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	c, err = a.Authenticate(core.ClientCredentials{ID: "client"})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestAuthenticate_PublicClient(t *testing.T) {
+	client := &db.Client{ID: "client"}
+
+	r := new(FakeClientRepo)
+	r.On("Get", "client").Return(client, nil)
+	a := ClientAuthenticator{Repo: r}
+
+	c, err := a.Authenticate(core.ClientCredentials{ID: "client"})
+	assert.NoError(t, err)
+	assert.Equal(t, client, c)
+
+	c, err = a.Authenticate(core.ClientCredentials{ID: "client", Secret: "secret"})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestAuthenticate_UnknownClient_ReturnNil(t *testing.T) {
+	r := new(FakeClientRepo)
+	r.On("Get", "client").Return(nil, nil)
+	a := ClientAuthenticator{Repo: r}
+
+	c, err := a.Authenticate(core.ClientCredentials{ID: "client", Secret: "secret"})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestAuthenticate_Assertion(t *testing.T) {
+	kp, err := crypto.GenerateKeypair()
+	require.NoError(t, err)
+	pk, err := crypto.ExportPublicKey(kp.PublicKey())
+	require.NoError(t, err)
+	client := &db.Client{ID: "client", PublicKey: pk}
+
+	r := new(FakeClientRepo)
+	r.On("Get", "client").Return(client, nil)
+	jti := new(FakeJTIRepo)
+	jti.On("Use", "client:id").Return(true, nil)
+	jti.On("Use", "client:used").Return(false, nil)
+	a := ClientAuthenticator{Repo: r, Crypto: crypto, JTI: jti, Audience: "auth"}
+
+	assertion := func(aud string, exp time.Duration, id string) string {
+		token, err := jwt.NewWithClaims(repo.SigningMethodVirgilCrypt, jwt.StandardClaims{
+			Id:        id,
+			Issuer:    "client",
+			Subject:   "client",
+			Audience:  aud,
+			ExpiresAt: time.Now().Add(exp).Unix(),
+		}).SignedString(repo.KeyCryptoPair{Crypto: crypto, Key: kp.PrivateKey()})
+		require.NoError(t, err)
+		return token
+	}
+
+	table := []struct {
+		name      string
+		assertion string
+		ok        bool
+	}{
+		{"valid", assertion("auth", time.Minute, "id"), true},
+		{"wrong audience", assertion("another", time.Minute, "id"), false},
+		{"expired", assertion("auth", -time.Minute, "id"), false},
+		{"too long lifetime", assertion("auth", time.Hour, "id"), false},
+		{"without jti", assertion("auth", time.Minute, ""), false},
+		{"replayed", assertion("auth", time.Minute, "used"), false},
+		{"broken", "broken", false},
+	}
+	for _, v := range table {
+		c, err := a.Authenticate(core.ClientCredentials{ID: "client", AssertionType: ClientAssertionTypeJWT, Assertion: v.assertion})
+		assert.NoError(t, err, v.name)
+		assert.Equal(t, v.ok, c != nil, v.name)
+	}
+}
+
+func TestAuthenticate_AssertionJTIRepoReturnErr_ReturnErr(t *testing.T) {
+	kp, err := crypto.GenerateKeypair()
+	require.NoError(t, err)
+	pk, err := crypto.ExportPublicKey(kp.PublicKey())
+	require.NoError(t, err)
+
+	r := new(FakeClientRepo)
+	r.On("Get", "client").Return(&db.Client{ID: "client", PublicKey: pk}, nil)
+	jti := new(FakeJTIRepo)
+	jti.On("Use", "client:id").Return(false, fmt.Errorf("ERROR"))
+	a := ClientAuthenticator{Repo: r, Crypto: crypto, JTI: jti, Audience: "auth"}
+
+	assertion, err := jwt.NewWithClaims(repo.SigningMethodVirgilCrypt, jwt.StandardClaims{
+		Id:        "id",
+		Issuer:    "client",
+		Subject:   "client",
+		Audience:  "auth",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}).SignedString(repo.KeyCryptoPair{Crypto: crypto, Key: kp.PrivateKey()})
+	require.NoError(t, err)
+
+	c, err := a.Authenticate(core.ClientCredentials{ID: "client", AssertionType: ClientAssertionTypeJWT, Assertion: assertion})
+	assert.Error(t, err)
+	assert.Nil(t, c)
+}
+
+func TestAuthenticate_AssertionSignedWithAppCard(t *testing.T) {
+	kp, err := crypto.GenerateKeypair()
+	require.NoError(t, err)
+	client := &db.Client{ID: "daemon", AppCardID: "app card", AuthMethod: "private_key_jwt"}
+
+	r := new(FakeClientRepo)
+	r.On("Get", "daemon").Return(client, nil)
+	cards := new(FakeCardGetter)
+	cards.On("GetCard", "app card").Return(&virgil.Card{Id: "app card", PublicKey: kp.PublicKey()}, nil)
+	jti := new(FakeJTIRepo)
+	jti.On("Use", "daemon:id").Return(true, nil)
+	a := ClientAuthenticator{Repo: r, Crypto: crypto, Cards: cards, JTI: jti, Audience: "auth"}
+
+	assertion, err := jwt.NewWithClaims(repo.SigningMethodVirgilCrypt, jwt.StandardClaims{
+		Id:        "id",
+		Issuer:    "daemon",
+		Subject:   "daemon",
+		Audience:  "auth",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}).SignedString(repo.KeyCryptoPair{Crypto: crypto, Key: kp.PrivateKey()})
+	require.NoError(t, err)
+
+	assert.False(t, client.Public())
+	c, err := a.Authenticate(core.ClientCredentials{ID: "daemon", AssertionType: ClientAssertionTypeJWT, Assertion: assertion})
+	assert.NoError(t, err)
+	assert.Equal(t, client, c)
+
+	c, err = a.Authenticate(core.ClientCredentials{ID: "daemon"})
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"bytes"
+	"sync"
 
 	"gopkg.in/virgil.v5/cryptoapi"
 )
@@ -15,25 +16,195 @@ type CryptoProvider interface {
 		IsPrivate() bool
 		Identifier() []byte
 	}) ([]byte, error)
+	DecryptThenVerify(cipherData []byte, key interface {
+		IsPrivate() bool
+		Identifier() []byte
+	}, verifierKeys ...interface {
+		IsPublic() bool
+		Identifier() []byte
+	}) ([]byte, error)
 	Sign(data []byte, key interface {
 		IsPrivate() bool
 		Identifier() []byte
 	}) ([]byte, error)
+	VerifySignature(data []byte, signature []byte, key interface {
+		IsPublic() bool
+		Identifier() []byte
+	}) error
 }
+
+// PublicKeyCodec exports and imports the public keys. The identifiers of the imported keys depend on the fingerprint
+// format of the crypto.
+type PublicKeyCodec interface {
+	ExportPublicKey(key interface {
+		IsPublic() bool
+		Identifier() []byte
+	}) ([]byte, error)
+	ImportPublicKey(data []byte) (interface {
+		IsPublic() bool
+		Identifier() []byte
+	}, error)
+}
+
+// maxCountedClients bounds the clients whose answers are counted apart, the answers of other clients are counted in
+// the totals only
+const maxCountedClients = 1000
+
 type Crypto struct {
 	PrivateKey cryptoapi.PrivateKey
 	Crypto     CryptoProvider
+	Format     string // name of the fingerprint format of the keys, it's shown by the metrics
+	Dual       *DualFingerprints
+
+	mu      sync.Mutex
+	formats map[string]uint64
+	clients map[string]map[string]uint64
+}
+
+// DualFingerprints is the other fingerprint format of the migration between the formats. The messages are encrypted
+// for the recipient keys under the identifiers of both formats and the answers encrypted for the service key under
+// either identifier are accepted.
+type DualFingerprints struct {
+	Format     string
+	PrivateKey cryptoapi.PrivateKey // the service key imported by the crypto of the format
+	Export     PublicKeyCodec       // the crypto of the keys of Crypto.Format
+	Import     PublicKeyCodec       // the crypto of the format
 }
 
-func (c *Crypto) Encrypt(data []byte, recipient cryptoapi.PublicKey) ([]byte, error) {
-	return c.Crypto.Encrypt(data, recipient)
+func (c *Crypto) Encrypt(data []byte, recipients ...cryptoapi.PublicKey) ([]byte, error) {
+	keys := make([]interface {
+		IsPublic() bool
+		Identifier() []byte
+	}, 0, 2*len(recipients))
+	for _, r := range recipients {
+		keys = append(keys, r)
+		if c.Dual == nil {
+			continue
+		}
+		k, err := c.Dual.publicKey(r)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(k.Identifier(), r.Identifier()) {
+			keys = append(keys, k)
+		}
+	}
+	return c.Crypto.Encrypt(data, keys...)
 }
-func (c *Crypto) Validate(CipherData, plainData []byte) bool {
+
+// Validate checks that the cipher data is the plain data encrypted for the service key. The fingerprint format of the
+// answer is counted for the client.
+func (c *Crypto) Validate(CipherData, plainData []byte, clientID string) bool {
 	decryptData, _ := c.Crypto.Decrypt(CipherData, c.PrivateKey)
+	if bytes.Equal(decryptData, plainData) {
+		c.count(c.Format, clientID)
+		return true
+	}
+	if c.Dual == nil {
+		return false
+	}
+	decryptData, _ = c.Crypto.Decrypt(CipherData, c.Dual.PrivateKey)
+	if bytes.Equal(decryptData, plainData) {
+		c.count(c.Dual.Format, clientID)
+		return true
+	}
+	return false
+}
+
+// ValidateSigned checks that the cipher data is the plain data signed by the signer key and encrypted for the service
+// key, so the answer tells which of the recipients decrypted the message. The fingerprint format of the answer is
+// counted for the client.
+func (c *Crypto) ValidateSigned(CipherData, plainData []byte, signer cryptoapi.PublicKey, clientID string) bool {
+	decryptData, _ := c.Crypto.DecryptThenVerify(CipherData, c.PrivateKey, signer)
+	if decryptData != nil && bytes.Equal(decryptData, plainData) {
+		c.count(c.Format, clientID)
+		return true
+	}
+	if c.Dual == nil {
+		return false
+	}
+	k, err := c.Dual.publicKey(signer)
+	if err != nil {
+		return false
+	}
+	decryptData, _ = c.Crypto.DecryptThenVerify(CipherData, c.Dual.PrivateKey, k)
+	if decryptData != nil && bytes.Equal(decryptData, plainData) {
+		c.count(c.Dual.Format, clientID)
+		return true
+	}
+	return false
+}
 
-	return bytes.Equal(decryptData, plainData)
+func (c *Crypto) Name() string {
+	return "Fingerprints"
+}
+
+// Info returns the number of the answers of every fingerprint format
+func (c *Crypto) Info() (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	formats := make(map[string]uint64, len(c.formats))
+	for f, n := range c.formats {
+		formats[f] = n
+	}
+	return map[string]interface{}{
+		"formats": formats,
+	}, nil
+}
+
+// ClientFormats returns the number of the answers of every fingerprint format by the clients
+func (c *Crypto) ClientFormats() map[string]map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clients := make(map[string]map[string]uint64, len(c.clients))
+	for id, counts := range c.clients {
+		clients[id] = make(map[string]uint64, len(counts))
+		for f, n := range counts {
+			clients[id][f] = n
+		}
+	}
+	return clients
+}
+
+func (c *Crypto) count(format, clientID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.formats == nil {
+		c.formats = make(map[string]uint64)
+		c.clients = make(map[string]map[string]uint64)
+	}
+	c.formats[format]++
+	if clientID == "" {
+		return
+	}
+	if c.clients[clientID] == nil {
+		if len(c.clients) >= maxCountedClients {
+			return
+		}
+		c.clients[clientID] = make(map[string]uint64)
+	}
+	c.clients[clientID][format]++
+}
+
+// publicKey returns the key with the identifier of the format
+func (d *DualFingerprints) publicKey(key cryptoapi.PublicKey) (interface {
+	IsPublic() bool
+	Identifier() []byte
+}, error) {
+	b, err := d.Export.ExportPublicKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return d.Import.ImportPublicKey(b)
 }
 
 func (c *Crypto) Sign(data []byte) ([]byte, error) {
 	return c.Crypto.Sign(data, c.PrivateKey)
 }
+
+func (c *Crypto) VerifySignature(data, signature []byte, signer cryptoapi.PublicKey) bool {
+	return c.Crypto.VerifySignature(data, signature, signer) == nil
+}
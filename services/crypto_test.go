@@ -1,11 +1,14 @@
 package services
 
 import (
+	"fmt"
 	"testing"
 
+	"gopkg.in/virgil.v5/cryptoapi"
 	"gopkg.in/virgil.v5/cryptoimpl"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var crypto = cryptoimpl.NewVirgilCrypto()
@@ -20,7 +23,7 @@ func TestReversibility(t *testing.T) {
 	}
 	msg := []byte(`message`)
 	emsg, _ := c.Encrypt(msg, pbk)
-	ok := c.Validate(emsg, msg)
+	ok := c.Validate(emsg, msg, "")
 
 	assert.True(t, ok)
 }
@@ -35,7 +38,135 @@ func TestValidate_MsgNotEquals_ReturnFalse(t *testing.T) {
 	}
 	msg := []byte(`message`)
 	emsg, _ := c.Encrypt([]byte("broken message"), pbk)
-	ok := c.Validate(emsg, msg)
+	ok := c.Validate(emsg, msg, "")
 
 	assert.False(t, ok)
 }
+
+func TestVerifySignature(t *testing.T) {
+	kp, _ := crypto.GenerateKeypair()
+	c := Crypto{Crypto: crypto}
+
+	msg := []byte(`message`)
+	sign, _ := crypto.Sign(msg, kp.PrivateKey())
+
+	assert.True(t, c.VerifySignature(msg, sign, kp.PublicKey()))
+	assert.False(t, c.VerifySignature([]byte(`another message`), sign, kp.PublicKey()))
+}
+
+func TestValidateSigned_AnsweringKeyFound(t *testing.T) {
+	service, _ := crypto.GenerateKeypair()
+	phone, _ := crypto.GenerateKeypair()
+	laptop, _ := crypto.GenerateKeypair()
+	c := Crypto{PrivateKey: service.PrivateKey(), Crypto: crypto, Format: "sha512"}
+
+	msg := []byte(`message`)
+	answer, err := crypto.SignThenEncrypt(msg, laptop.PrivateKey(), service.PublicKey())
+	require.NoError(t, err)
+	unsigned, err := crypto.Encrypt(msg, service.PublicKey())
+	require.NoError(t, err)
+
+	assert.False(t, c.ValidateSigned(answer, msg, phone.PublicKey(), "client"))
+	assert.True(t, c.ValidateSigned(answer, msg, laptop.PublicKey(), "client"))
+	assert.False(t, c.ValidateSigned(answer, []byte(`another message`), laptop.PublicKey(), "client"))
+	assert.False(t, c.ValidateSigned(unsigned, msg, laptop.PublicKey(), "client"))
+}
+
+func TestEncrypt_SeveralRecipients_EveryoneDecrypts(t *testing.T) {
+	kp1, _ := crypto.GenerateKeypair()
+	kp2, _ := crypto.GenerateKeypair()
+
+	c := Crypto{Crypto: crypto}
+	msg := []byte(`message`)
+	emsg, err := c.Encrypt(msg, kp1.PublicKey(), kp2.PublicKey())
+	assert.NoError(t, err)
+
+	for _, sk := range []cryptoapi.PrivateKey{kp1.PrivateKey(), kp2.PrivateKey()} {
+		c.PrivateKey = sk
+		assert.True(t, c.Validate(emsg, msg, ""))
+	}
+}
+
+const (
+	servicePrivateKey = `MC4CAQAwBQYDK2VwBCIEIAMIR/IZeffxbUT+BmbQSWv+E0QELSC9zhwq4jPp0zEp`
+	cardPrivateKey    = `MC4CAQAwBQYDK2VwBCIEICM4hGSdeteNCGAmgI1rYo9lEq91bsgqIIOs4mC4h+IK`
+)
+
+var sha256Crypto = &cryptoimpl.VirgilCrypto{UseSHA256Fingerprints: true}
+
+func dualCrypto(t *testing.T) *Crypto {
+	sk, err := crypto.ImportPrivateKey([]byte(servicePrivateKey), "")
+	require.NoError(t, err)
+	altSK, err := sha256Crypto.ImportPrivateKey([]byte(servicePrivateKey), "")
+	require.NoError(t, err)
+
+	return &Crypto{
+		PrivateKey: sk.(cryptoapi.PrivateKey),
+		Crypto:     crypto,
+		Format:     "sha512",
+		Dual: &DualFingerprints{
+			Format:     "sha256",
+			PrivateKey: altSK.(cryptoapi.PrivateKey),
+			Export:     crypto,
+			Import:     sha256Crypto,
+		},
+	}
+}
+
+func TestEncrypt_Dual_BothFormatsDecrypt(t *testing.T) {
+	c := dualCrypto(t)
+	sk, _ := crypto.ImportPrivateKey([]byte(cardPrivateKey), "")
+	pk, _ := crypto.ExtractPublicKey(sk)
+
+	msg := []byte(`message`)
+	emsg, err := c.Encrypt(msg, pk.(cryptoapi.PublicKey))
+	require.NoError(t, err)
+
+	for _, cr := range []*cryptoimpl.VirgilCrypto{crypto, sha256Crypto} {
+		key, _ := cr.ImportPrivateKey([]byte(cardPrivateKey), "")
+		plain, err := cr.Decrypt(emsg, key)
+		assert.NoError(t, err)
+		assert.Equal(t, msg, plain)
+	}
+}
+
+func TestValidate_Dual_BothFormatsAcceptedAndCounted(t *testing.T) {
+	c := dualCrypto(t)
+	msg := []byte(`message`)
+	for client, cr := range map[string]*cryptoimpl.VirgilCrypto{"new": crypto, "old": sha256Crypto} {
+		sk, _ := cr.ImportPrivateKey([]byte(servicePrivateKey), "")
+		pk, _ := cr.ExtractPublicKey(sk)
+		emsg, err := cr.Encrypt(msg, pk)
+		require.NoError(t, err)
+
+		assert.True(t, c.Validate(emsg, msg, client), client)
+		assert.False(t, c.Validate(emsg, []byte(`another message`), client), client)
+	}
+
+	info, _ := c.Info()
+	assert.Equal(t, map[string]uint64{"sha512": 1, "sha256": 1}, info["formats"])
+	assert.Equal(t, map[string]map[string]uint64{"new": {"sha512": 1}, "old": {"sha256": 1}}, c.ClientFormats())
+}
+
+func TestValidate_ManyClients_ClientsCapped(t *testing.T) {
+	c := &Crypto{}
+	for i := 0; i < maxCountedClients+10; i++ {
+		c.count("sha512", fmt.Sprint(i))
+	}
+
+	info, _ := c.Info()
+	assert.Equal(t, map[string]uint64{"sha512": maxCountedClients + 10}, info["formats"])
+	assert.Len(t, c.ClientFormats(), maxCountedClients)
+}
+
+func TestValidate_OtherFormatWithoutDual_ReturnFalse(t *testing.T) {
+	c := dualCrypto(t)
+	c.Dual = nil
+	sk, _ := sha256Crypto.ImportPrivateKey([]byte(servicePrivateKey), "")
+	pk, _ := sha256Crypto.ExtractPublicKey(sk)
+	msg := []byte(`message`)
+	emsg, err := sha256Crypto.Encrypt(msg, pk)
+	require.NoError(t, err)
+
+	assert.False(t, c.Validate(emsg, msg, "old"))
+}
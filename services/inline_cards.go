@@ -0,0 +1,30 @@
+package services
+
+import (
+	"gopkg.in/virgil.v5/cryptoapi"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
+)
+
+// InlineCards parses the cards sent with the handshake. The cards are checked by Verifier like the cards got from the
+// Cards service, the errors are returned as core.CardError.
+type InlineCards struct {
+	Crypto   cryptoapi.CardCrypto
+	Verifier virgil.CardVerifier
+}
+
+func (c *InlineCards) ParseCard(raw string) (*virgil.Card, error) {
+	card, err := repo.ParseCard(c.Crypto, raw, false)
+	if err != nil {
+		return nil, &core.CardError{Kind: core.CardMalformed, Err: err}
+	}
+	if err = c.Verifier.VerifyCard(card); err != nil {
+		if core.CardErrorKindOf(err) == core.CardSignatureMissing {
+			return nil, err
+		}
+		return nil, &core.CardError{Kind: core.CardSignatureInvalid, Err: err}
+	}
+	return card, nil
+}
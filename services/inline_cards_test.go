@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+func TestInlineCards_ParseCard_ReturnVerifiedCard(t *testing.T) {
+	c := storedCard(t, "alice", "")
+	v := trustingVerifier()
+
+	card, err := (&InlineCards{Crypto: cardCrypto, Verifier: v}).ParseCard(c.Raw)
+
+	assert.NoError(t, err)
+	assert.Equal(t, c.ID, card.Id)
+	assert.Equal(t, "alice", card.Identity)
+	v.AssertCalled(t, "VerifyCard", c.ID)
+}
+
+func TestInlineCards_MalformedCard_ReturnCardMalformed(t *testing.T) {
+	v := trustingVerifier()
+
+	_, err := (&InlineCards{Crypto: cardCrypto, Verifier: v}).ParseCard("not a card")
+
+	assert.Equal(t, core.CardMalformed, core.CardErrorKindOf(err))
+	v.AssertNotCalled(t, "VerifyCard", mock.Anything)
+}
+
+func TestInlineCards_VerificationFailed_ReturnSignatureErr(t *testing.T) {
+	c := storedCard(t, "alice", "")
+	table := map[core.CardErrorKind]error{
+		core.CardSignatureMissing: &core.CardError{Kind: core.CardSignatureMissing, Err: errors.New("missing")},
+		core.CardSignatureInvalid: errors.New("does not have signature for verifier ID virgil"),
+	}
+	for kind, verr := range table {
+		v := new(FakeCardVerifier)
+		v.On("VerifyCard", c.ID).Return(verr)
+
+		_, err := (&InlineCards{Crypto: cardCrypto, Verifier: v}).ParseCard(c.Raw)
+
+		assert.Equal(t, kind, core.CardErrorKindOf(err), kind.String())
+	}
+}
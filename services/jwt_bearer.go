@@ -0,0 +1,69 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
+)
+
+const maxBearerAssertionLifetime = 10 * time.Minute
+
+// JWTBearerVerifier checks assertions of the JWT bearer grant (RFC 7523 section 2.1). The assertion is signed with
+// the private key of the resource owner's card: `sub` is the card id, `aud` is the service issuer, `exp` is not later
+// than 10 minutes from now and `jti` is unique.
+type JWTBearerVerifier struct {
+	Cards    CardGetter
+	Crypto   repo.Crypto
+	Audience string
+	JTI      db.JTIRepo
+}
+
+// Verify returns the card that signed the assertion. It returns nil if the assertion is invalid or replayed.
+// Errors of the Cards service are returned as they are.
+func (v *JWTBearerVerifier) Verify(assertion string) (*virgil.Card, error) {
+	var (
+		card    *virgil.Card
+		cardErr error
+	)
+	claims := new(jwt.StandardClaims)
+	_, err := jwt.ParseWithClaims(assertion, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != repo.SigningMethodVirgilCrypt.Alg() {
+			return nil, fmt.Errorf("signing method %v is invalid", t.Method.Alg())
+		}
+		if claims.Subject == "" {
+			return nil, fmt.Errorf("subject is missed")
+		}
+		card, cardErr = v.Cards.GetCard(claims.Subject)
+		if cardErr != nil {
+			return nil, cardErr
+		}
+		return repo.KeyCryptoPair{Crypto: v.Crypto, Key: card.PublicKey}, nil
+	})
+	if cardErr != nil {
+		return nil, cardErr
+	}
+	if err != nil {
+		return nil, nil
+	}
+
+	exp := time.Unix(claims.ExpiresAt, 0)
+	switch {
+	case claims.Audience != v.Audience:
+		return nil, nil
+	case claims.ExpiresAt == 0 || exp.Sub(time.Now()) > maxBearerAssertionLifetime:
+		return nil, nil
+	case claims.Id == "":
+		return nil, nil
+	}
+	// jti is unique for the issuer, that is the card
+	ok, err := v.JTI.Use(claims.Subject+":"+claims.Id, exp)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return card, nil
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	jwt "gopkg.in/dgrijalva/jwt-go.v3"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
+)
+
+type FakeJTIRepo struct {
+	mock.Mock
+}
+
+func (r *FakeJTIRepo) Use(id string, expired time.Time) (bool, error) {
+	args := r.Called(id)
+	return args.Bool(0), args.Error(1)
+}
+
+func TestJWTBearerVerify(t *testing.T) {
+	kp, err := crypto.GenerateKeypair()
+	require.NoError(t, err)
+	card := &virgil.Card{Id: "card", PublicKey: kp.PublicKey()}
+
+	cards := new(FakeCardGetter)
+	cards.On("GetCard", "card").Return(card, nil)
+	cards.On("GetCard", "missed").Return(nil, fmt.Errorf("card not found"))
+
+	jti := new(FakeJTIRepo)
+	jti.On("Use", "card:used").Return(false, nil)
+	jti.On("Use", mock.Anything).Return(true, nil)
+
+	v := JWTBearerVerifier{Cards: cards, Crypto: crypto, Audience: "auth", JTI: jti}
+
+	assertion := func(sub, aud, id string, exp time.Duration) string {
+		token, err := jwt.NewWithClaims(repo.SigningMethodVirgilCrypt, jwt.StandardClaims{
+			Subject:   sub,
+			Audience:  aud,
+			Id:        id,
+			ExpiresAt: time.Now().Add(exp).Unix(),
+		}).SignedString(repo.KeyCryptoPair{Crypto: crypto, Key: kp.PrivateKey()})
+		require.NoError(t, err)
+		return token
+	}
+
+	table := []struct {
+		name      string
+		assertion string
+		ok        bool
+	}{
+		{"valid", assertion("card", "auth", "1", time.Minute), true},
+		{"wrong audience", assertion("card", "another", "2", time.Minute), false},
+		{"expired", assertion("card", "auth", "3", -time.Minute), false},
+		{"too long lifetime", assertion("card", "auth", "4", time.Hour), false},
+		{"without jti", assertion("card", "auth", "", time.Minute), false},
+		{"replayed", assertion("card", "auth", "used", time.Minute), false},
+		{"broken", "broken", false},
+	}
+	for _, c := range table {
+		got, err := v.Verify(c.assertion)
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.ok, got == card, c.name)
+	}
+
+	_, err = v.Verify(assertion("missed", "auth", "5", time.Minute))
+	assert.Error(t, err)
+}
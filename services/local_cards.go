@@ -0,0 +1,106 @@
+package services
+
+import (
+	"fmt"
+
+	"gopkg.in/virgil.v5/cryptoapi"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+	"github.com/VirgilSecurity/virgil-services-auth/db/repo"
+)
+
+// LocalCards serves the cards of the local store instead of the Cards service, e.g. for the installations that
+// can't reach it. The cards are checked by Verifier like the cards got from the Cards service. A card is outdated if
+// the store has a card of the same identity that replaces it. A stored card that cannot be parsed or verified is logged
+// and skipped by the search, so it doesn't hide the other cards of the identity.
+type LocalCards struct {
+	Store    db.CardStore
+	Crypto   cryptoapi.CardCrypto
+	Verifier virgil.CardVerifier
+	Logger   Logger
+}
+
+func (l *LocalCards) GetCard(id string) (*virgil.Card, error) {
+	c, err := l.Store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil || c.ID != id {
+		return nil, &core.CardError{Kind: core.CardNotFound, Err: fmt.Errorf("card %v isn't in the local store", id)}
+	}
+	cards, err := l.Store.Search(c.Identity)
+	if err != nil {
+		return nil, err
+	}
+	return l.card(c, outdated(c.ID, cards))
+}
+
+func (l *LocalCards) SearchCards(identity string) ([]*virgil.Card, error) {
+	cards, err := l.Store.Search(identity)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*virgil.Card, 0, len(cards))
+	for i := range cards {
+		card, err := l.card(&cards[i], outdated(cards[i].ID, cards))
+		if err != nil {
+			if l.Logger != nil {
+				l.Logger.Printf("Local cards[Search %v]: skip card %v: %v", identity, cards[i].ID, err)
+			}
+			continue
+		}
+		res = append(res, card)
+	}
+	return res, nil
+}
+
+// Import verifies the exported card and adds it to the store
+func (l *LocalCards) Import(raw string) (*virgil.Card, error) {
+	card, err := repo.ParseCard(l.Crypto, raw, false)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.Verifier.VerifyCard(card); err != nil {
+		return nil, err
+	}
+	err = l.Store.Add(&db.Card{
+		ID:             card.Id,
+		Identity:       card.Identity,
+		PreviousCardID: card.PreviousCardId,
+		Raw:            raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+func (l *LocalCards) Remove(id string) error {
+	return l.Store.Remove(id)
+}
+
+func (l *LocalCards) card(c *db.Card, outdated bool) (*virgil.Card, error) {
+	card, err := repo.ParseCard(l.Crypto, c.Raw, outdated)
+	if err != nil {
+		return nil, err
+	}
+	if card.Id != c.ID {
+		return nil, fmt.Errorf("card %v is kept as %v", card.Id, c.ID)
+	}
+	if err = l.Verifier.VerifyCard(card); err != nil {
+		return nil, err
+	}
+	return card, nil
+}
+
+// outdated reports whether one of the cards replaces the card
+func outdated(id string, cards []db.Card) bool {
+	for _, c := range cards {
+		if c.PreviousCardID == id {
+			return true
+		}
+	}
+	return false
+}
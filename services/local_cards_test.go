@@ -0,0 +1,194 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/virgil.v5/cryptoimpl"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+var cardCrypto = &cryptoimpl.VirgilCardCrypto{Crypto: crypto}
+
+type FakeCardStore struct {
+	mock.Mock
+}
+
+func (s *FakeCardStore) Get(id string) (*db.Card, error) {
+	args := s.Called(id)
+	c, _ := args.Get(0).(*db.Card)
+	return c, args.Error(1)
+}
+
+func (s *FakeCardStore) Search(identity string) ([]db.Card, error) {
+	args := s.Called(identity)
+	list, _ := args.Get(0).([]db.Card)
+	return list, args.Error(1)
+}
+
+func (s *FakeCardStore) Add(c *db.Card) error {
+	return s.Called(c).Error(0)
+}
+
+func (s *FakeCardStore) Remove(id string) error {
+	return s.Called(id).Error(0)
+}
+
+type FakeCardVerifier struct {
+	mock.Mock
+}
+
+func (v *FakeCardVerifier) VerifyCard(card *virgil.Card) error {
+	return v.Called(card.Id).Error(0)
+}
+
+func storedCard(t *testing.T, identity, previousCardID string) db.Card {
+	kp, err := crypto.GenerateKeypair()
+	require.NoError(t, err)
+	model, err := virgil.GenerateRawCard(cardCrypto, &virgil.CardParams{
+		Identity:       identity,
+		PublicKey:      kp.PublicKey(),
+		PrivateKey:     kp.PrivateKey(),
+		PreviousCardId: previousCardID,
+	}, time.Now())
+	require.NoError(t, err)
+	require.NoError(t, (&virgil.ModelSigner{Crypto: cardCrypto}).SelfSign(model, kp.PrivateKey(), nil))
+	raw, err := model.ExportAsBase64EncodedString()
+	require.NoError(t, err)
+	card, err := virgil.ParseRawCard(cardCrypto, model, false)
+	require.NoError(t, err)
+
+	return db.Card{ID: card.Id, Identity: identity, PreviousCardID: previousCardID, Raw: raw}
+}
+
+func trustingVerifier() *FakeCardVerifier {
+	v := new(FakeCardVerifier)
+	v.On("VerifyCard", mock.Anything).Return(nil)
+	return v
+}
+
+func TestLocalCards_GetCard_ReturnVerifiedCard(t *testing.T) {
+	old := storedCard(t, "alice", "")
+	current := storedCard(t, "alice", old.ID)
+	s := new(FakeCardStore)
+	s.On("Get", old.ID).Return(&old, nil)
+	s.On("Get", current.ID).Return(&current, nil)
+	s.On("Search", "alice").Return([]db.Card{old, current}, nil)
+
+	v := new(FakeCardVerifier)
+	v.On("VerifyCard", old.ID).Return(nil).Once()
+	v.On("VerifyCard", current.ID).Return(nil).Once()
+
+	l := &LocalCards{Store: s, Crypto: cardCrypto, Verifier: v}
+	card, err := l.GetCard(old.ID)
+	require.NoError(t, err)
+	assert.Equal(t, old.ID, card.Id)
+	assert.True(t, card.IsOutdated)
+
+	card, err = l.GetCard(current.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", card.Identity)
+	assert.Equal(t, old.ID, card.PreviousCardId)
+	assert.False(t, card.IsOutdated)
+
+	v.AssertExpectations(t)
+}
+
+func TestLocalCards_CardNotStored_ReturnCardNotFound(t *testing.T) {
+	c := storedCard(t, "alice", "")
+	s := new(FakeCardStore)
+	s.On("Get", "missing").Return(nil, nil)
+	s.On("Get", "other").Return(&c, nil)
+
+	l := &LocalCards{Store: s, Crypto: cardCrypto, Verifier: trustingVerifier()}
+	for _, id := range []string{"missing", "other"} {
+		_, err := l.GetCard(id)
+		assert.Equal(t, core.CardNotFound, core.CardErrorKindOf(err), id)
+	}
+}
+
+func TestLocalCards_VerificationFailed_ReturnErr(t *testing.T) {
+	c := storedCard(t, "alice", "")
+	s := new(FakeCardStore)
+	s.On("Get", c.ID).Return(&c, nil)
+	s.On("Search", "alice").Return([]db.Card{c}, nil)
+
+	v := &CardSignatures{Checks: []SignatureCheck{{Signers: []string{"virgil"}, Verifier: trustingVerifier()}}}
+
+	_, err := (&CardErrors{Cards: &LocalCards{Store: s, Crypto: cardCrypto, Verifier: v}}).GetCard(c.ID)
+
+	assert.Equal(t, core.CardSignatureMissing, core.CardErrorKindOf(err))
+}
+
+func TestLocalCards_SearchCards_ReturnIdentityCards(t *testing.T) {
+	old := storedCard(t, "alice", "")
+	current := storedCard(t, "alice", old.ID)
+	s := new(FakeCardStore)
+	s.On("Search", "alice").Return([]db.Card{old, current}, nil)
+
+	l := &LocalCards{Store: s, Crypto: cardCrypto, Verifier: trustingVerifier()}
+	cards, err := l.SearchCards("alice")
+
+	require.NoError(t, err)
+	require.Len(t, cards, 2)
+	assert.True(t, cards[0].IsOutdated)
+	assert.False(t, cards[1].IsOutdated)
+}
+
+func TestLocalCards_SearchCardsWithBadCards_BadCardsSkipped(t *testing.T) {
+	good := storedCard(t, "alice", "")
+	unverified := storedCard(t, "alice", "")
+	broken := db.Card{ID: "broken", Identity: "alice", Raw: "not a card"}
+	s := new(FakeCardStore)
+	s.On("Search", "alice").Return([]db.Card{broken, unverified, good}, nil)
+
+	v := new(FakeCardVerifier)
+	v.On("VerifyCard", good.ID).Return(nil)
+	v.On("VerifyCard", unverified.ID).Return(fmt.Errorf("ERROR"))
+
+	logger := new(FakeLogger)
+	logger.On("Printf").Twice()
+
+	l := &LocalCards{Store: s, Crypto: cardCrypto, Verifier: v, Logger: logger}
+	cards, err := l.SearchCards("alice")
+
+	require.NoError(t, err)
+	require.Len(t, cards, 1)
+	assert.Equal(t, good.ID, cards[0].Id)
+	logger.AssertExpectations(t)
+}
+
+func TestLocalCards_Import_VerifiedCardAdded(t *testing.T) {
+	c := storedCard(t, "alice", "")
+	s := new(FakeCardStore)
+	s.On("Add", &c).Return(nil).Once()
+
+	l := &LocalCards{Store: s, Crypto: cardCrypto, Verifier: trustingVerifier()}
+	card, err := l.Import(c.Raw)
+
+	require.NoError(t, err)
+	assert.Equal(t, c.ID, card.Id)
+	s.AssertExpectations(t)
+}
+
+func TestLocalCards_ImportUnverifiedCard_ReturnErr(t *testing.T) {
+	c := storedCard(t, "alice", "")
+	s := new(FakeCardStore)
+	v := new(FakeCardVerifier)
+	v.On("VerifyCard", c.ID).Return(fmt.Errorf("signature validation failed"))
+
+	l := &LocalCards{Store: s, Crypto: cardCrypto, Verifier: v}
+	_, err := l.Import(c.Raw)
+	assert.Error(t, err)
+	_, err = l.Import("not a card")
+	assert.Error(t, err)
+
+	s.AssertNotCalled(t, "Add", mock.Anything)
+}
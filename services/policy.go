@@ -0,0 +1,184 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+// Policy decides which cards may obtain which scopes. Rules are loaded from a JSON file and are evaluated in order:
+// the first rule that matches the card and the client and permits the requested scope wins. A request that is not
+// permitted by any rule is denied.
+//
+// Policy file example:
+//
+//	{
+//	  "shadow": false,
+//	  "rules": [
+//	    {
+//	      "name": "partners",
+//	      "identity": {"regex": "^.+@partner\\.com$"},
+//	      "signers": ["<authority card id>"],
+//	      "clients": ["<client id>"],
+//	      "scopes": ["profile", "mail"],
+//	      "access_token_ttl": 300,
+//	      "inline_cards": true
+//	    }
+//	  ]
+//	}
+type Policy struct {
+	Path string
+
+	mu     sync.RWMutex
+	shadow bool
+	rules  []*policyRule
+}
+
+type policyFile struct {
+	Shadow bool          `json:"shadow"`
+	Rules  []*policyRule `json:"rules"`
+}
+
+type identityMatcher struct {
+	Exact  string `json:"exact"`
+	Prefix string `json:"prefix"`
+	Regex  string `json:"regex"`
+
+	re *regexp.Regexp
+}
+
+type policyRule struct {
+	Name           string           `json:"name"`
+	Identity       *identityMatcher `json:"identity"`
+	Signers        []string         `json:"signers"`
+	Clients        []string         `json:"clients"`
+	Scopes         []string         `json:"scopes"`
+	AccessTokenTTL int              `json:"access_token_ttl"`
+	InlineCards    bool             `json:"inline_cards"`
+}
+
+// LoadPolicy reads a policy from the file.
+func LoadPolicy(path string) (*Policy, error) {
+	p := &Policy{Path: path}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Reload rereads the policy file. The current rules stay in force if the file cannot be loaded.
+func (p *Policy) Reload() error {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return err
+	}
+	var f policyFile
+	if err = json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("policy %v: %v", p.Path, err)
+	}
+	for i, r := range f.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("#%v", i)
+		}
+		if r.AccessTokenTTL < 0 {
+			return fmt.Errorf("policy %v: rule %v: access_token_ttl is negative", p.Path, r.Name)
+		}
+		if r.Identity != nil && r.Identity.Regex != "" {
+			r.Identity.re, err = regexp.Compile(r.Identity.Regex)
+			if err != nil {
+				return fmt.Errorf("policy %v: rule %v: %v", p.Path, r.Name, err)
+			}
+		}
+	}
+
+	p.mu.Lock()
+	p.shadow, p.rules = f.Shadow, f.Rules
+	p.mu.Unlock()
+	return nil
+}
+
+// Evaluate checks the request against the rules.
+func (p *Policy) Evaluate(req core.PolicyRequest) core.PolicyDecision {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	for _, r := range p.rules {
+		if !r.match(req) {
+			continue
+		}
+		scope, ok := core.NarrowScope(req.Scope, r.Scopes)
+		if !ok {
+			continue
+		}
+		return core.PolicyDecision{
+			Allowed:  true,
+			Shadow:   p.shadow,
+			Rule:     r.Name,
+			Scope:    scope,
+			TokenTTL: r.AccessTokenTTL,
+
+			InlineCards: r.InlineCards,
+		}
+	}
+	return core.PolicyDecision{Shadow: p.shadow}
+}
+
+// Scopes returns the scopes that are named in the rules. The wildcard is skipped.
+func (p *Policy) Scopes() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var scopes []string
+	for _, r := range p.rules {
+		for _, s := range r.Scopes {
+			if s != core.ScopeWildcard && !contains(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	return scopes
+}
+
+func (r *policyRule) match(req core.PolicyRequest) bool {
+	if r.Identity != nil && !r.Identity.match(req.Identity) {
+		return false
+	}
+	if len(r.Clients) != 0 && !contains(r.Clients, req.ClientID) {
+		return false
+	}
+	if len(r.Signers) != 0 {
+		for _, s := range req.Signers {
+			if contains(r.Signers, s) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (m *identityMatcher) match(identity string) bool {
+	switch {
+	case m.Exact != "":
+		return identity == m.Exact
+	case m.Prefix != "":
+		return strings.HasPrefix(identity, m.Prefix)
+	case m.re != nil:
+		return m.re.MatchString(identity)
+	}
+	return true
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+const testPolicy = `{
+	"rules": [
+		{"name": "admins", "identity": {"exact": "root"}, "scopes": ["*"], "access_token_ttl": 60},
+		{"name": "staff", "identity": {"prefix": "staff-"}, "clients": ["web"], "scopes": ["profile", "mail"]},
+		{"name": "partners", "identity": {"regex": "@partner\\.com$"}, "signers": ["authority"], "scopes": ["profile"], "inline_cards": true}
+	]
+}`
+
+func writePolicy(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "policy")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(content)
+	require.NoError(t, err)
+	return f.Name()
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	defer os.Remove(path)
+	p, err := LoadPolicy(path)
+	require.NoError(t, err)
+
+	table := []struct {
+		name     string
+		req      core.PolicyRequest
+		expected core.PolicyDecision
+	}{
+		{
+			name:     "exact identity, any scope",
+			req:      core.PolicyRequest{Identity: "root", Scope: "anything"},
+			expected: core.PolicyDecision{Allowed: true, Rule: "admins", Scope: "anything", TokenTTL: 60},
+		},
+		{
+			name:     "wildcard is narrowed to the rule scopes",
+			req:      core.PolicyRequest{Identity: "staff-bob", ClientID: "web", Scope: "*"},
+			expected: core.PolicyDecision{Allowed: true, Rule: "staff", Scope: "profile mail"},
+		},
+		{
+			name:     "subset of the rule scopes",
+			req:      core.PolicyRequest{Identity: "staff-bob", ClientID: "web", Scope: "mail"},
+			expected: core.PolicyDecision{Allowed: true, Rule: "staff", Scope: "mail"},
+		},
+		{
+			name:     "scope is not allowed",
+			req:      core.PolicyRequest{Identity: "staff-bob", ClientID: "web", Scope: "mail admin"},
+			expected: core.PolicyDecision{},
+		},
+		{
+			name:     "client does not match",
+			req:      core.PolicyRequest{Identity: "staff-bob", ClientID: "mobile", Scope: "mail"},
+			expected: core.PolicyDecision{},
+		},
+		{
+			name:     "regex and signer match",
+			req:      core.PolicyRequest{Identity: "bob@partner.com", Signers: []string{"self", "authority"}, Scope: "profile"},
+			expected: core.PolicyDecision{Allowed: true, Rule: "partners", Scope: "profile", InlineCards: true},
+		},
+		{
+			name:     "signer does not match",
+			req:      core.PolicyRequest{Identity: "bob@partner.com", Signers: []string{"self"}, Scope: "profile"},
+			expected: core.PolicyDecision{},
+		},
+	}
+	for _, v := range table {
+		assert.Equal(t, v.expected, p.Evaluate(v.req), v.name)
+	}
+}
+
+func TestPolicyEvaluate_ShadowMode_ReturnShadowDecision(t *testing.T) {
+	path := writePolicy(t, `{"shadow": true, "rules": []}`)
+	defer os.Remove(path)
+	p, err := LoadPolicy(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, core.PolicyDecision{Shadow: true}, p.Evaluate(core.PolicyRequest{Identity: "bob", Scope: "*"}))
+}
+
+func TestPolicyScopes_WildcardSkipped(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	defer os.Remove(path)
+	p, err := LoadPolicy(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"profile", "mail"}, p.Scopes())
+}
+
+func TestPolicyReload_BrokenFile_KeepRules(t *testing.T) {
+	path := writePolicy(t, testPolicy)
+	defer os.Remove(path)
+	p, err := LoadPolicy(path)
+	require.NoError(t, err)
+
+	err = ioutil.WriteFile(path, []byte(`{"rules": [{"identity": {"regex": "("}}]}`), 0600)
+	require.NoError(t, err)
+
+	assert.Error(t, p.Reload())
+	assert.True(t, p.Evaluate(core.PolicyRequest{Identity: "root", Scope: "*"}).Allowed)
+}
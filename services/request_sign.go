@@ -0,0 +1,114 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+const DefaultRequestSignMaxSkew = 5 * time.Minute
+
+type CardGetter interface {
+	GetCard(id string) (*virgil.Card, error)
+}
+
+type SignatureVerifier interface {
+	VerifySignature(data []byte, signature []byte, key interface {
+		IsPublic() bool
+		Identifier() []byte
+	}) error
+}
+
+// RequestSignVerifier checks the request_sign of handshake requests. The application signs the timestamp and the
+// request body with the private key of its Virgil card. The card is taken from the client registry by the client_id
+// of the request, so handshakes of unknown applications cannot be signed.
+//
+// The signed data is the decimal unix timestamp, a line feed and the request body. The signed data is accepted once,
+// its hashes are remembered until the timestamp is out of the allowed skew, so the same request cannot be replayed
+// with another encoding of the signature.
+type RequestSignVerifier struct {
+	Clients  db.ClientRepo
+	Cards    CardGetter
+	Crypto   SignatureVerifier
+	Used     db.JTIRepo
+	Logger   Logger
+	Required bool          // reject all handshakes without the signature, otherwise only ones of clients that require it
+	MaxSkew  time.Duration // allowed difference between the timestamp and the service time
+}
+
+// VerifyRequest reports whether the request is accepted. An error is returned only if the check cannot be performed,
+// the unavailable Cards service is returned as the core.CardError.
+func (v *RequestSignVerifier) VerifyRequest(clientID string, body []byte, timestamp, sign string) (bool, error) {
+	if sign == "" && v.Required {
+		return false, nil
+	}
+	if clientID == "" {
+		return sign == "", nil
+	}
+	c, err := v.Clients.Get(clientID)
+	if err != nil {
+		v.Logger.Printf("Request sign[Get client %v]: %v", clientID, err)
+		return false, err
+	}
+	if sign == "" {
+		return c == nil || !c.RequireRequestSign, nil
+	}
+	if c == nil || c.AppCardID == "" {
+		return false, nil
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > v.maxSkew() {
+		return false, nil
+	}
+	signature, err := base64.StdEncoding.Strict().DecodeString(sign)
+	if err != nil {
+		return false, nil
+	}
+
+	card, err := v.Cards.GetCard(c.AppCardID)
+	if err != nil {
+		switch core.CardErrorKindOf(err) {
+		case core.CardsUnavailable:
+			return false, err
+		case 0:
+			v.Logger.Printf("Request sign[GetCard %v]: %+v", c.AppCardID, err)
+			return false, err
+		}
+		// the application card is revoked or invalid
+		return false, nil
+	}
+	data := make([]byte, 0, len(timestamp)+1+len(body))
+	data = append(append(append(data, timestamp...), '\n'), body...)
+	if v.Crypto.VerifySignature(data, signature, card.PublicKey) != nil {
+		return false, nil
+	}
+	hash := sha256.Sum256(data)
+	ok, err := v.Used.Use("request_sign:"+clientID+":"+hex.EncodeToString(hash[:]), time.Unix(ts, 0).Add(v.maxSkew()))
+	if err != nil {
+		v.Logger.Printf("Request sign[Use signature]: %v", err)
+		return false, err
+	}
+	return ok, nil
+}
+
+func (v *RequestSignVerifier) maxSkew() time.Duration {
+	if v.MaxSkew > 0 {
+		return v.MaxSkew
+	}
+	return DefaultRequestSignMaxSkew
+}
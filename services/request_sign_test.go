@@ -0,0 +1,174 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+	"github.com/VirgilSecurity/virgil-services-auth/db"
+)
+
+type FakeLogger struct {
+	mock.Mock
+}
+
+func (l *FakeLogger) Printf(format string, args ...interface{}) {
+	l.Called()
+}
+
+type FakeCardGetter struct {
+	mock.Mock
+}
+
+func (c *FakeCardGetter) GetCard(id string) (card *virgil.Card, err error) {
+	args := c.Called(id)
+	card, _ = args.Get(0).(*virgil.Card)
+	err = args.Error(1)
+	return
+}
+
+func signedRequestVerifier(t *testing.T) (*RequestSignVerifier, func(ts string, body []byte) string) {
+	kp, err := crypto.GenerateKeypair()
+	require.NoError(t, err)
+
+	r := new(FakeClientRepo)
+	r.On("Get", "app").Return(&db.Client{ID: "app", AppCardID: "app card", RequireRequestSign: true}, nil)
+	r.On("Get", "web").Return(&db.Client{ID: "web"}, nil)
+
+	cards := new(FakeCardGetter)
+	cards.On("GetCard", "app card").Return(&virgil.Card{Id: "app card", PublicKey: kp.PublicKey()}, nil)
+
+	sign := func(ts string, body []byte) string {
+		s, err := crypto.Sign(append([]byte(ts+"\n"), body...), kp.PrivateKey())
+		require.NoError(t, err)
+		return base64.StdEncoding.EncodeToString(s)
+	}
+	used := new(FakeJTIRepo)
+	used.On("Use", mock.Anything).Return(true, nil)
+
+	return &RequestSignVerifier{Clients: r, Cards: cards, Crypto: crypto, Used: used}, sign
+}
+
+// nonCanonical changes the unused trailing bits of the base64 string, the lenient decoding ignores them
+func nonCanonical(s string) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	end := strings.TrimRight(s, "=")
+	last := strings.IndexByte(alphabet, end[len(end)-1])
+	return end[:len(end)-1] + string(alphabet[last^1]) + s[len(end):]
+}
+
+func TestVerifyRequest_ValidSign_ReturnTrue(t *testing.T) {
+	v, sign := signedRequestVerifier(t)
+	body := []byte(`{"client_id":"app"}`)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	ok, err := v.VerifyRequest("app", body, ts, sign(ts, body))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestVerifyRequest_InvalidSign_ReturnFalse(t *testing.T) {
+	v, sign := signedRequestVerifier(t)
+	body := []byte(`{"client_id":"app"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	old := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	table := []struct {
+		clientID, timestamp, sign string
+	}{
+		{"app", now, sign(now, []byte(`{"client_id":"other"}`))},
+		{"app", old, sign(old, body)},
+		{"app", "", sign(now, body)},
+		{"app", now, "not base64"},
+		{"app", now, nonCanonical(sign(now, body))},
+		{"web", now, sign(now, body)},
+		{"", now, sign(now, body)},
+	}
+	for _, c := range table {
+		ok, err := v.VerifyRequest(c.clientID, body, c.timestamp, c.sign)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	}
+}
+
+func TestVerifyRequest_WithoutSign_CheckPolicy(t *testing.T) {
+	v, _ := signedRequestVerifier(t)
+
+	ok, _ := v.VerifyRequest("web", nil, "", "")
+	assert.True(t, ok)
+	ok, _ = v.VerifyRequest("", nil, "", "")
+	assert.True(t, ok)
+	// the client requires signed handshakes
+	ok, _ = v.VerifyRequest("app", nil, "", "")
+	assert.False(t, ok)
+
+	v.Required = true
+	ok, _ = v.VerifyRequest("web", nil, "", "")
+	assert.False(t, ok)
+}
+
+func TestVerifyRequest_SignReplayed_ReturnFalse(t *testing.T) {
+	v, sign := signedRequestVerifier(t)
+	body := []byte(`{"client_id":"app"}`)
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	s := sign(ts, body)
+	hash := sha256.Sum256(append([]byte(ts+"\n"), body...))
+
+	used := new(FakeJTIRepo)
+	used.On("Use", "request_sign:app:"+hex.EncodeToString(hash[:])).Return(false, nil).Once()
+	v.Used = used
+
+	ok, err := v.VerifyRequest("app", body, ts, s)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	used.AssertExpectations(t)
+}
+
+func TestVerifyRequest_AppCardInvalid_ReturnFalse(t *testing.T) {
+	for _, kind := range []core.CardErrorKind{core.CardNotFound, core.CardForbidden, core.CardSignatureInvalid} {
+		v, sign := signedRequestVerifier(t)
+		body := []byte(`{"client_id":"app"}`)
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+		cards := new(FakeCardGetter)
+		cards.On("GetCard", "app card").Return(nil, &core.CardError{Kind: kind, Err: fmt.Errorf("ERROR")})
+		v.Cards = cards
+
+		ok, err := v.VerifyRequest("app", body, ts, sign(ts, body))
+		assert.NoError(t, err, kind.String())
+		assert.False(t, ok, kind.String())
+	}
+}
+
+func TestVerifyRequest_CardsServiceFailed_ReturnErr(t *testing.T) {
+	table := map[string]error{
+		"unavailable": &core.CardError{Kind: core.CardsUnavailable, Err: fmt.Errorf("timeout")},
+		"unexpected":  fmt.Errorf("ERROR"),
+	}
+	for name, cerr := range table {
+		v, sign := signedRequestVerifier(t)
+		body := []byte(`{"client_id":"app"}`)
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+		cards := new(FakeCardGetter)
+		cards.On("GetCard", "app card").Return(nil, cerr)
+		l := new(FakeLogger)
+		l.On("Printf")
+		v.Cards, v.Logger = cards, l
+
+		ok, err := v.VerifyRequest("app", body, ts, sign(ts, body))
+		assert.Equal(t, cerr, err, name)
+		assert.False(t, ok, name)
+	}
+}
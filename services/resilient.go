@@ -0,0 +1,205 @@
+package services
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+var errBreakerOpen = fmt.Errorf("circuit breaker is open")
+
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// ResilientCards limits the calls of the Cards service by Timeout and retries the calls failed with the
+// core.CardsUnavailable error. The circuit breaker opens after FailureThreshold failed calls in a row, the calls fail
+// fast during OpenTimeout and then a single trial call closes the breaker again if it succeeds. The calls that timed
+// out, failed after all retries or weren't made due to the open breaker return the core.CardsUnavailable error.
+type ResilientCards struct {
+	Cards            CardSource
+	Logger           Logger
+	Timeout          time.Duration // 0 is no timeout
+	Retries          int
+	RetryBackoff     time.Duration // the delay before the first retry, it doubles on the next ones
+	FailureThreshold int           // 0 disables the circuit breaker
+	OpenTimeout      time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	trial    bool // the trial call of the half-open breaker is in flight
+}
+
+type cardResult struct {
+	card  *virgil.Card
+	cards []*virgil.Card
+	err   error
+}
+
+func (r *ResilientCards) GetCard(id string) (*virgil.Card, error) {
+	res := r.call(func() cardResult {
+		card, err := r.Cards.GetCard(id)
+		return cardResult{card: card, err: err}
+	})
+	return res.card, res.err
+}
+
+func (r *ResilientCards) SearchCards(identity string) ([]*virgil.Card, error) {
+	res := r.call(func() cardResult {
+		cards, err := r.Cards.SearchCards(identity)
+		return cardResult{cards: cards, err: err}
+	})
+	return res.cards, res.err
+}
+
+func (r *ResilientCards) Name() string {
+	return "CardsService"
+}
+
+// Info returns the state of the circuit breaker
+func (r *ResilientCards) Info() (map[string]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	info := map[string]interface{}{
+		"state":    r.currentState(),
+		"failures": r.failures,
+	}
+	if r.state == breakerOpen {
+		info["opened_at"] = r.openedAt.Unix()
+	}
+	return info, nil
+}
+
+func (r *ResilientCards) call(f func() cardResult) cardResult {
+	if !r.allow() {
+		return cardResult{err: &core.CardError{Kind: core.CardsUnavailable, Err: errBreakerOpen}}
+	}
+
+	var res cardResult
+	for attempt := 0; ; attempt++ {
+		res = r.attempt(f)
+		if !retryable(res.err) {
+			r.succeeded()
+			return res
+		}
+		if attempt >= r.Retries {
+			break
+		}
+		time.Sleep(r.backoff(attempt))
+	}
+	r.failed(res.err)
+	if core.CardErrorKindOf(res.err) != core.CardsUnavailable {
+		res.err = &core.CardError{Kind: core.CardsUnavailable, Err: res.err}
+	}
+	return res
+}
+
+// attempt makes the call, the result of a call that timed out is dropped. The card source must limit its own requests
+// by the timeout as well, the wait here is only a backstop.
+func (r *ResilientCards) attempt(f func() cardResult) cardResult {
+	if r.Timeout <= 0 {
+		return f()
+	}
+	done := make(chan cardResult, 1)
+	go func() {
+		done <- f()
+	}()
+
+	t := time.NewTimer(r.Timeout)
+	defer t.Stop()
+	select {
+	case res := <-done:
+		return res
+	case <-t.C:
+		return cardResult{err: timeoutError(r.Timeout)}
+	}
+}
+
+// backoff returns the delay before the retry, it's randomized between the half and the full exponential delay
+func (r *ResilientCards) backoff(attempt int) time.Duration {
+	d := r.RetryBackoff << uint(attempt)
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (r *ResilientCards) allow() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.currentState() {
+	case breakerOpen:
+		return false
+	case breakerHalfOpen:
+		if r.trial {
+			return false
+		}
+		r.trial = true
+	}
+	return true
+}
+
+func (r *ResilientCards) succeeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.state == breakerOpen && r.Logger != nil {
+		r.Logger.Printf("Cards service: circuit breaker is closed")
+	}
+	r.state, r.failures, r.trial = breakerClosed, 0, false
+}
+
+func (r *ResilientCards) failed(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failures++
+	r.trial = false
+	if r.FailureThreshold <= 0 || (r.state != breakerOpen && r.failures < r.FailureThreshold) {
+		return
+	}
+	if r.state != breakerOpen && r.Logger != nil {
+		r.Logger.Printf("Cards service: circuit breaker is open after %v failures: %v", r.failures, err)
+	}
+	r.state, r.openedAt = breakerOpen, time.Now()
+}
+
+// currentState reports the open breaker as half-open after OpenTimeout. It must be called under the lock.
+func (r *ResilientCards) currentState() string {
+	switch {
+	case r.state != breakerOpen:
+		return breakerClosed
+	case time.Since(r.openedAt) >= r.OpenTimeout:
+		return breakerHalfOpen
+	}
+	return breakerOpen
+}
+
+type timeoutError time.Duration
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("call timed out after %v", time.Duration(e))
+}
+
+// retryable reports whether the call timed out or the Cards service can't be reached
+func retryable(err error) bool {
+	if _, ok := err.(timeoutError); ok {
+		return true
+	}
+	return core.CardErrorKindOf(err) == core.CardsUnavailable
+}
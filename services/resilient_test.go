@@ -0,0 +1,120 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	virgil "gopkg.in/virgil.v5/sdk"
+
+	"github.com/VirgilSecurity/virgil-services-auth/core"
+)
+
+func cardsDown() error {
+	return &core.CardError{Kind: core.CardsUnavailable, Err: fmt.Errorf("bad gateway")}
+}
+
+func TestResilientCards_CardsUnavailable_Retried(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(nil, cardsDown()).Twice()
+	c.On("GetCard", "id").Return(&virgil.Card{Id: "id"}, nil).Once()
+
+	r := &ResilientCards{Cards: c, Retries: 2, RetryBackoff: time.Millisecond}
+	card, err := r.GetCard("id")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "id", card.Id)
+	c.AssertExpectations(t)
+}
+
+func TestResilientCards_CardErr_NotRetried(t *testing.T) {
+	table := []error{
+		&core.CardError{Kind: core.CardNotFound, Err: fmt.Errorf("not found")},
+		&core.CardError{Kind: core.CardSignatureInvalid, Err: fmt.Errorf("signature validation failed")},
+		fmt.Errorf("format"),
+	}
+	for _, v := range table {
+		c := new(FakeCardGetter)
+		c.On("GetCard", "id").Return(nil, v).Once()
+
+		r := &ResilientCards{Cards: c, Retries: 2, FailureThreshold: 1}
+		_, err := r.GetCard("id")
+
+		assert.Equal(t, v, err)
+		c.AssertExpectations(t)
+		info, _ := r.Info()
+		assert.Equal(t, breakerClosed, info["state"])
+	}
+}
+
+func TestResilientCards_RetriesExhausted_ReturnUnavailable(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("SearchCards", "alice").Return(nil, cardsDown()).Times(3)
+
+	r := &ResilientCards{Cards: c, Retries: 2}
+	_, err := r.SearchCards("alice")
+
+	assert.Equal(t, core.CardsUnavailable, core.CardErrorKindOf(err))
+	c.AssertExpectations(t)
+}
+
+func TestResilientCards_CallTimedOut_ReturnUnavailable(t *testing.T) {
+	release := make(chan time.Time)
+	defer close(release)
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").WaitUntil(release).Return(&virgil.Card{Id: "id"}, nil)
+
+	r := &ResilientCards{Cards: c, Timeout: 10 * time.Millisecond}
+	_, err := r.GetCard("id")
+
+	assert.Equal(t, core.CardsUnavailable, core.CardErrorKindOf(err))
+}
+
+func TestResilientCards_FailureThresholdReached_FailFast(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(nil, cardsDown()).Twice()
+
+	r := &ResilientCards{Cards: c, FailureThreshold: 2, OpenTimeout: time.Minute}
+	r.GetCard("id")
+	r.GetCard("id")
+	_, err := r.GetCard("id")
+
+	assert.Equal(t, &core.CardError{Kind: core.CardsUnavailable, Err: errBreakerOpen}, err)
+	c.AssertExpectations(t)
+	info, _ := r.Info()
+	assert.Equal(t, breakerOpen, info["state"])
+}
+
+func TestResilientCards_OpenTimeoutPassed_TrialCallClosesBreaker(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(nil, cardsDown()).Once()
+	c.On("GetCard", "id").Return(&virgil.Card{Id: "id"}, nil).Once()
+
+	r := &ResilientCards{Cards: c, FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond}
+	r.GetCard("id")
+	time.Sleep(20 * time.Millisecond)
+	info, _ := r.Info()
+	assert.Equal(t, breakerHalfOpen, info["state"])
+
+	_, err := r.GetCard("id")
+
+	assert.NoError(t, err)
+	info, _ = r.Info()
+	assert.Equal(t, breakerClosed, info["state"])
+	c.AssertExpectations(t)
+}
+
+func TestResilientCards_TrialCallFailed_BreakerOpenAgain(t *testing.T) {
+	c := new(FakeCardGetter)
+	c.On("GetCard", "id").Return(nil, cardsDown()).Twice()
+
+	r := &ResilientCards{Cards: c, FailureThreshold: 1, OpenTimeout: 10 * time.Millisecond}
+	r.GetCard("id")
+	time.Sleep(20 * time.Millisecond)
+	r.GetCard("id")
+	_, err := r.GetCard("id")
+
+	assert.Equal(t, &core.CardError{Kind: core.CardsUnavailable, Err: errBreakerOpen}, err)
+	c.AssertNumberOfCalls(t, "GetCard", 2)
+}